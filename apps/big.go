@@ -41,13 +41,11 @@ func (b *Big) Populate(s *ddtxn.Store, ex ddtxn.ETransaction) {
 // Calls rand 4 times
 func (b *Big) MakeOne(w int, local_seed *uint32, txn *ddtxn.Query) {
 	rnd := uint64(ddtxn.RandN(local_seed, uint32(b.ni)))
-	txn.U1 = rnd
-	txn.U2 = (rnd * 2) % b.ni
-	txn.U3 = (rnd * 3) % b.ni
-	txn.U4 = (rnd * 4) % b.ni
-	txn.U5 = (rnd * 5) % b.ni
-	txn.U6 = (rnd * 6) % b.ni
-	txn.U7 = (rnd) % uint64(b.np)
+	txn.SetNum(ddtxn.BIG_BIDS[0], rnd)
+	for i := uint64(1); i < 6; i++ {
+		txn.SetNum(ddtxn.BIG_BIDS[i], (rnd*(i+1))%b.ni)
+	}
+	txn.SetNum(ddtxn.BIG_PRODUCT, (rnd)%uint64(b.np))
 	if *incr {
 		txn.TXN = ddtxn.BIG_INCR
 	} else {
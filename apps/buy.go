@@ -81,28 +81,28 @@ func (b *Buy) MakeOne(w int, local_seed *uint32, sp uint32, txn *ddtxn.Query) {
 	if x < b.read_rate {
 		if x > b.ncontended_rate {
 			// Contended read; use Zipfian distribution or np
-			txn.K1 = ddtxn.UserKey(uint64(bidder))
-			txn.K2 = ddtxn.ProductKey(product)
+			txn.SetKey(ddtxn.MICRO_K1, ddtxn.UserKey(uint64(bidder)))
+			txn.SetKey(ddtxn.MICRO_K2, ddtxn.ProductKey(product))
 		} else {
 			// (Hopefully) uncontended read.  Random product.
 			product = int(ddtxn.RandN(local_seed, uint32(b.nbidders)))
-			txn.K1 = ddtxn.UserKey(uint64(bidder))
-			txn.K2 = ddtxn.ProductKey(product)
+			txn.SetKey(ddtxn.MICRO_K1, ddtxn.UserKey(uint64(bidder)))
+			txn.SetKey(ddtxn.MICRO_K2, ddtxn.ProductKey(product))
 		}
 		txn.TXN = ddtxn.D_READ_TWO
 	} else {
 		amt := int32(ddtxn.RandN(local_seed, 10))
-		txn.K1 = ddtxn.UserKey(uint64(bidder))
-		txn.K2 = ddtxn.ProductKey(product)
-		txn.A = amt
+		txn.SetKey(ddtxn.MICRO_K1, ddtxn.UserKey(uint64(bidder)))
+		txn.SetKey(ddtxn.MICRO_K2, ddtxn.ProductKey(product))
+		txn.SetNum(ddtxn.MICRO_A, uint64(amt))
 		txn.TXN = ddtxn.D_BUY
 	}
 }
 
 func (b *Buy) Add(t ddtxn.Query) {
 	if t.TXN == ddtxn.D_BUY {
-		x, _ := ddtxn.UndoCKey(t.K2)
-		atomic.AddInt32(&b.validate[x], t.A)
+		x, _ := ddtxn.UndoCKey(t.Key(ddtxn.MICRO_K2))
+		atomic.AddInt32(&b.validate[x], int32(t.Num(ddtxn.MICRO_A)))
 	}
 }
 
@@ -56,18 +56,15 @@ func (b *Rubis) Init(np, nb, nw, ngo int, zipfd, bidrate float64) {
 }
 
 func (b *Rubis) Populate(s *ddtxn.Store, c *ddtxn.Coordinator) {
-	tmp := *ddtxn.Allocate
+	tmp := s.SetAllocate(true)
 	tmp2 := *dlog.Debug
-	*ddtxn.Allocate = true
 	*dlog.Debug = false
 	for wi := 0; wi < b.nworkers; wi++ {
 		w := c.Workers[wi]
 		ex := w.E
 		for i := b.sp * uint32(wi); i < b.sp*(uint32(wi+1)); i++ {
-			q := ddtxn.Query{
-				U1: uint64(rand.Intn(ddtxn.NUM_REGIONS)),
-				U2: 0,
-			}
+			var q ddtxn.Query
+			q.SetNum(ddtxn.REG_REGION, uint64(rand.Intn(ddtxn.NUM_REGIONS)))
 			r, err := ddtxn.RegisterUserTxn(q, ex)
 			if err != nil {
 				log.Fatalf("Could not create user %v; err:%v\n", i, err)
@@ -90,48 +87,43 @@ func (b *Rubis) Populate(s *ddtxn.Store, c *ddtxn.Coordinator) {
 		ex := w.E
 		nx := rand.Intn(b.nbidders)
 		for i := chunk * wi; i < chunk*(wi+1); i++ {
-			q := ddtxn.Query{
-				T:  ddtxn.TID(i + 1),
-				S1: "xxx",
-				S2: "lovely",
-				U1: b.users[nx],
-				U2: 100,
-				U3: 100,
-				U4: 1000,
-				U5: 1000,
-				U6: 1,
-				U7: uint64(rand.Intn(ddtxn.NUM_CATEGORIES)),
-			}
+			q := ddtxn.Query{T: ddtxn.TID(i + 1)}
+			q.SetNum(ddtxn.NI_SELLER, b.users[nx])
+			q.SetStr(ddtxn.NI_NAME, "xxx")
+			q.SetStr(ddtxn.NI_DESC, "lovely")
+			q.SetNum(ddtxn.NI_SPRICE, 100)
+			q.SetNum(ddtxn.NI_RPRICE, 100)
+			q.SetNum(ddtxn.NI_BUYNOW, 1000)
+			q.SetNum(ddtxn.NI_DUR, 1000)
+			q.SetNum(ddtxn.NI_QTY, 1)
+			q.SetNum(ddtxn.NI_CATEG, uint64(rand.Intn(ddtxn.NUM_CATEGORIES)))
 			r, err := ddtxn.NewItemTxn(q, ex)
 			if err != nil {
-				fmt.Printf("%v Could not create item index %v error: %v user_id: %v user index: %v nb: %v\n", wi, i, err, q.U1, nx, b.nbidders)
+				fmt.Printf("%v Could not create item index %v error: %v user_id: %v user index: %v nb: %v\n", wi, i, err, q.Num(ddtxn.NI_SELLER), nx, b.nbidders)
 				continue
 			}
 			v := r.V.(uint64)
 			b.products[i] = v
 			b.pidIdx[v] = i
 			k := ddtxn.BidsPerItemKey(v)
-			w.Store().CreateKey(k, nil, ddtxn.LIST)
+			w.Store().CreateList(k, ddtxn.BidListOptions())
 			ex.Reset()
 		}
 	}
-	*ddtxn.Allocate = tmp
+	s.SetAllocate(tmp)
 	*dlog.Debug = tmp2
 }
 
 func (b *Rubis) PopulateBids(s *ddtxn.Store, c *ddtxn.Coordinator) {
-	tmp := *ddtxn.Allocate
+	tmp := s.SetAllocate(true)
 	tmp2 := *dlog.Debug
-	*ddtxn.Allocate = true
 	*dlog.Debug = false
 	chunk := ddtxn.NUM_ITEMS / b.nworkers
 	b.nbidders = 1
 	b.users = make([]uint64, 1)
 	ex := c.Workers[0].E
-	q := ddtxn.Query{
-		U1: uint64(rand.Intn(ddtxn.NUM_REGIONS)),
-		U2: 0,
-	}
+	var q ddtxn.Query
+	q.SetNum(ddtxn.REG_REGION, uint64(rand.Intn(ddtxn.NUM_REGIONS)))
 	r, err := ddtxn.RegisterUserTxn(q, ex)
 	if err != nil {
 		log.Fatalf("Could not create user; err:%v\n", err)
@@ -147,28 +139,26 @@ func (b *Rubis) PopulateBids(s *ddtxn.Store, c *ddtxn.Coordinator) {
 		ex := w.E
 		nx := rand.Intn(b.nbidders)
 		for i := chunk * wi; i < chunk*(wi+1); i++ {
-			q := ddtxn.Query{
-				T:  ddtxn.TID(i + 1),
-				S1: "xxx",
-				S2: "lovely",
-				U1: b.users[nx],
-				U2: 100,
-				U3: 100,
-				U4: 1000,
-				U5: 1000,
-				U6: 1,
-				U7: uint64(rand.Intn(ddtxn.NUM_CATEGORIES)),
-			}
+			q := ddtxn.Query{T: ddtxn.TID(i + 1)}
+			q.SetNum(ddtxn.NI_SELLER, b.users[nx])
+			q.SetStr(ddtxn.NI_NAME, "xxx")
+			q.SetStr(ddtxn.NI_DESC, "lovely")
+			q.SetNum(ddtxn.NI_SPRICE, 100)
+			q.SetNum(ddtxn.NI_RPRICE, 100)
+			q.SetNum(ddtxn.NI_BUYNOW, 1000)
+			q.SetNum(ddtxn.NI_DUR, 1000)
+			q.SetNum(ddtxn.NI_QTY, 1)
+			q.SetNum(ddtxn.NI_CATEG, uint64(rand.Intn(ddtxn.NUM_CATEGORIES)))
 			r, err := ddtxn.NewItemTxn(q, ex)
 			if err != nil {
-				fmt.Printf("%v Could not create item index %v error: %v user_id: %v user index: %v nb: %v\n", wi, i, err, q.U1, nx, b.nbidders)
+				fmt.Printf("%v Could not create item index %v error: %v user_id: %v user index: %v nb: %v\n", wi, i, err, q.Num(ddtxn.NI_SELLER), nx, b.nbidders)
 				continue
 			}
 			v := r.V.(uint64)
 			b.products[i] = v
 			b.pidIdx[v] = i
 			k := ddtxn.BidsPerItemKey(v)
-			w.Store().CreateKey(k, nil, ddtxn.LIST)
+			w.Store().CreateList(k, ddtxn.BidListOptions())
 			ex.Reset()
 		}
 		if b.zipfd > 0 {
@@ -176,7 +166,7 @@ func (b *Rubis) PopulateBids(s *ddtxn.Store, c *ddtxn.Coordinator) {
 			b.zip[wi] = ddtxn.NewZipf(r, b.zipfd, 1, uint64(b.nproducts-1))
 		}
 	}
-	*ddtxn.Allocate = tmp
+	s.SetAllocate(tmp)
 	*dlog.Debug = tmp2
 }
 
@@ -193,12 +183,12 @@ func (b *Rubis) MakeBid(w int, local_seed *uint32, txn *ddtxn.Query) {
 		log.Fatalf("Huh %v %v %v\n", x, len(b.products), b.nproducts)
 	}
 	product := b.products[x]
-	txn.U1 = uint64(bidder)
+	txn.SetNum(ddtxn.BID_USER, uint64(bidder))
 	if product == 0 {
 		log.Fatalf("store bid ID 0? %v %v", x, b.products[x])
 	}
-	txn.U2 = uint64(product)
-	txn.U3 = uint64(time.Now().UnixNano())
+	txn.SetNum(ddtxn.BID_ITEM, uint64(product))
+	txn.SetNum(ddtxn.BID_PRICE, uint64(time.Now().UnixNano()))
 }
 
 func (b *Rubis) MakeOne(w int, local_seed *uint32, txn *ddtxn.Query) {
@@ -215,13 +205,13 @@ func (b *Rubis) MakeOne(w int, local_seed *uint32, txn *ddtxn.Query) {
 			x = ddtxn.RandN(local_seed, uint32(b.nproducts))
 		}
 		product := b.products[x]
-		txn.U1 = uint64(bidder)
+		txn.SetNum(ddtxn.BID_USER, uint64(bidder))
 		if product == 0 {
 			log.Fatalf("store bid ID 0? %v %v", x, b.products[x])
 		}
-		txn.U2 = uint64(product)
-		//txn.U3 = uint64(ddtxn.RandN(local_seed, 10))
-		txn.U3 = uint64(time.Now().UnixNano()) & 0x000efff
+		txn.SetNum(ddtxn.BID_ITEM, uint64(product))
+		//txn.SetNum(ddtxn.BID_PRICE, uint64(ddtxn.RandN(local_seed, 10)))
+		txn.SetNum(ddtxn.BID_PRICE, uint64(time.Now().UnixNano())&0x000efff)
 	} else if x < b.rates[1] {
 		txn.TXN = ddtxn.RUBIS_VIEWBIDHIST
 		x := ddtxn.RandN(local_seed, uint32(b.nproducts))
@@ -229,7 +219,7 @@ func (b *Rubis) MakeOne(w int, local_seed *uint32, txn *ddtxn.Query) {
 		if product == 0 {
 			log.Fatalf("view bid hist ID 0? %v %v", x, b.products[x])
 		}
-		txn.U1 = uint64(product)
+		txn.SetNum(ddtxn.VBH_ITEM, uint64(product))
 	} else if x < b.rates[2] {
 		txn.TXN = ddtxn.RUBIS_BUYNOW
 		//bidder := b.users[int(ddtxn.RandN(local_seed, b.sp))+w*int(b.sp)]
@@ -239,9 +229,9 @@ func (b *Rubis) MakeOne(w int, local_seed *uint32, txn *ddtxn.Query) {
 		if product == 0 {
 			log.Fatalf("buy now ID 0? %v %v", x, b.products[x])
 		}
-		txn.U1 = uint64(bidder)
-		txn.U2 = uint64(product)
-		txn.A = int32(ddtxn.RandN(local_seed, 10))
+		txn.SetNum(ddtxn.BN_USER, uint64(bidder))
+		txn.SetNum(ddtxn.BN_ITEM, uint64(product))
+		txn.SetNum(ddtxn.BN_QTY, uint64(ddtxn.RandN(local_seed, 10)))
 	} else if x < b.rates[3] {
 		txn.TXN = ddtxn.RUBIS_COMMENT
 		//		u1 := b.users[int(ddtxn.RandN(local_seed, b.sp))+w*int(b.sp)]
@@ -253,25 +243,25 @@ func (b *Rubis) MakeOne(w int, local_seed *uint32, txn *ddtxn.Query) {
 		if product == 0 {
 			log.Fatalf("comment ID 0? %v %v", x, b.products[x])
 		}
-		txn.U1 = uint64(u1)
-		txn.U2 = uint64(u2)
-		txn.U3 = uint64(product)
-		txn.S1 = "xxxx"
-		txn.U4 = 1
+		txn.SetNum(ddtxn.COM_TO, uint64(u1))
+		txn.SetNum(ddtxn.COM_FROM, uint64(u2))
+		txn.SetNum(ddtxn.COM_ITEM, uint64(product))
+		txn.SetStr(ddtxn.COM_COMMENT, "xxxx")
+		txn.SetNum(ddtxn.COM_RATING, 1)
 	} else if x < b.rates[4] {
 		txn.TXN = ddtxn.RUBIS_NEWITEM
 		//		bidder := b.users[int(ddtxn.RandN(local_seed, b.sp))+w*int(b.sp)]
 		bidder := b.users[int(ddtxn.RandN(local_seed, uint32(b.nbidders)))]
 		amt := uint64(ddtxn.RandN(local_seed, 10))
-		txn.U1 = uint64(bidder)
-		txn.S1 = "yyyy"
-		txn.S2 = "zzzz"
-		txn.U2 = amt
-		txn.U3 = amt
-		txn.U4 = amt
-		txn.U5 = 1
-		txn.U6 = 1
-		txn.U7 = uint64(ddtxn.RandN(local_seed, uint32(ddtxn.NUM_CATEGORIES)))
+		txn.SetNum(ddtxn.NI_SELLER, uint64(bidder))
+		txn.SetStr(ddtxn.NI_NAME, "yyyy")
+		txn.SetStr(ddtxn.NI_DESC, "zzzz")
+		txn.SetNum(ddtxn.NI_SPRICE, amt)
+		txn.SetNum(ddtxn.NI_RPRICE, amt)
+		txn.SetNum(ddtxn.NI_BUYNOW, amt)
+		txn.SetNum(ddtxn.NI_DUR, 1)
+		txn.SetNum(ddtxn.NI_QTY, 1)
+		txn.SetNum(ddtxn.NI_CATEG, uint64(ddtxn.RandN(local_seed, uint32(ddtxn.NUM_CATEGORIES))))
 	} else if x < b.rates[5] {
 		txn.TXN = ddtxn.RUBIS_PUTBID
 		x := ddtxn.RandN(local_seed, uint32(b.nproducts))
@@ -279,7 +269,7 @@ func (b *Rubis) MakeOne(w int, local_seed *uint32, txn *ddtxn.Query) {
 		if product == 0 {
 			log.Fatalf("put bid ID 0? %v %v", x, b.products[x])
 		}
-		txn.U1 = uint64(product)
+		txn.SetNum(ddtxn.PB_ITEM, uint64(product))
 	} else if x < b.rates[6] {
 		txn.TXN = ddtxn.RUBIS_PUTCOMMENT
 		x := ddtxn.RandN(local_seed, uint32(b.nproducts))
@@ -289,21 +279,21 @@ func (b *Rubis) MakeOne(w int, local_seed *uint32, txn *ddtxn.Query) {
 		}
 		//		bidder := b.users[int(ddtxn.RandN(local_seed, b.sp))+w*int(b.sp)]
 		bidder := b.users[int(ddtxn.RandN(local_seed, uint32(b.nbidders)))]
-		txn.U1 = uint64(bidder)
-		txn.U2 = uint64(product)
+		txn.SetNum(ddtxn.PC_TO, uint64(bidder))
+		txn.SetNum(ddtxn.PC_ITEM, uint64(product))
 	} else if x < b.rates[7] {
 		txn.TXN = ddtxn.RUBIS_REGISTER
-		txn.U1 = uint64(ddtxn.RandN(local_seed, uint32(ddtxn.NUM_REGIONS)))
-		txn.U2 = uint64(ddtxn.RandN(local_seed, 1000000000))
+		txn.SetNum(ddtxn.REG_REGION, uint64(ddtxn.RandN(local_seed, uint32(ddtxn.NUM_REGIONS))))
+		txn.SetNum(ddtxn.REG_NICKNAME, uint64(ddtxn.RandN(local_seed, 1000000000)))
 	} else if x < b.rates[8] {
 		txn.TXN = ddtxn.RUBIS_SEARCHCAT
-		txn.U1 = uint64(ddtxn.RandN(local_seed, uint32(ddtxn.NUM_CATEGORIES)))
-		txn.U2 = 5
+		txn.SetNum(ddtxn.SC_CATEG, uint64(ddtxn.RandN(local_seed, uint32(ddtxn.NUM_CATEGORIES))))
+		txn.SetNum(ddtxn.SC_NUM, 5)
 	} else if x < b.rates[9] {
 		txn.TXN = ddtxn.RUBIS_SEARCHREG
-		txn.U1 = uint64(ddtxn.RandN(local_seed, uint32(ddtxn.NUM_REGIONS)))
-		txn.U2 = uint64(ddtxn.RandN(local_seed, uint32(ddtxn.NUM_CATEGORIES)))
-		txn.U3 = 5
+		txn.SetNum(ddtxn.SR_REGION, uint64(ddtxn.RandN(local_seed, uint32(ddtxn.NUM_REGIONS))))
+		txn.SetNum(ddtxn.SR_CATEG, uint64(ddtxn.RandN(local_seed, uint32(ddtxn.NUM_CATEGORIES))))
+		txn.SetNum(ddtxn.SR_NUM, 5)
 	} else if x < b.rates[10] {
 		txn.TXN = ddtxn.RUBIS_VIEW
 		x := ddtxn.RandN(local_seed, uint32(b.nproducts))
@@ -311,12 +301,12 @@ func (b *Rubis) MakeOne(w int, local_seed *uint32, txn *ddtxn.Query) {
 		if product == 0 {
 			log.Fatalf("view ID 0? %v %v", x, b.products[x])
 		}
-		txn.U1 = uint64(product)
+		txn.SetNum(ddtxn.VI_ITEM, uint64(product))
 	} else if x < b.rates[11] {
 		txn.TXN = ddtxn.RUBIS_VIEWUSER
 		//		bidder := b.users[int(ddtxn.RandN(local_seed, b.sp))+w*int(b.sp)]
 		bidder := b.users[int(ddtxn.RandN(local_seed, uint32(b.nbidders)))]
-		txn.U1 = uint64(bidder)
+		txn.SetNum(ddtxn.VU_USER, uint64(bidder))
 	} else {
 		log.Fatalf("No such transaction\n")
 	}
@@ -324,18 +314,19 @@ func (b *Rubis) MakeOne(w int, local_seed *uint32, txn *ddtxn.Query) {
 
 func (b *Rubis) Add(t ddtxn.Query) {
 	if t.TXN == ddtxn.RUBIS_BID {
-		x := b.pidIdx[t.U2]
+		x := b.pidIdx[t.Num(ddtxn.BID_ITEM)]
+		price := int32(t.Num(ddtxn.BID_PRICE))
 		atomic.AddInt32(&b.num_bids[x], 1)
-		for int32(t.U3) > b.maxes[x] {
+		for price > b.maxes[x] {
 			v := atomic.LoadInt32(&b.maxes[x])
-			done := atomic.CompareAndSwapInt32(&b.maxes[x], v, int32(t.U3))
+			done := atomic.CompareAndSwapInt32(&b.maxes[x], v, price)
 			if done {
 				break
 			}
 		}
 	} else if t.TXN == ddtxn.RUBIS_COMMENT {
 		b.Lock()
-		b.ratings[t.U1] += 1
+		b.ratings[t.Num(ddtxn.COM_TO)] += 1
 		b.Unlock()
 	}
 }
@@ -0,0 +1,168 @@
+package ddtxn
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Transaction arguments.  A transaction describes its arguments with
+// a Schema; each named argument gets a typed slot in the Query's
+// fixed-size Args arena.  Reading or writing an argument is an array
+// index, so nothing is allocated or reflected on the hot path.
+//
+// Every Query carries the whole arena and is copied into every call
+// and stash, so it is only as big as the builtin transactions need.
+
+const (
+	MAX_KEY_ARGS = 2
+	MAX_NUM_ARGS = 8
+	MAX_STR_ARGS = 2
+)
+
+type Args struct {
+	Keys [MAX_KEY_ARGS]Key
+	Nums [MAX_NUM_ARGS]uint64
+	Strs [MAX_STR_ARGS]string
+}
+
+// Handles for argument slots, returned by a Schema.  The type of the
+// handle picks the accessor, so reading a string slot as a number
+// doesn't compile.
+type KeyArg int
+type NumArg int
+type StrArg int
+
+type ArgType int
+
+const (
+	KEY_ARG ArgType = iota
+	NUM_ARG
+	STR_ARG
+)
+
+func (t ArgType) String() string {
+	switch t {
+	case KEY_ARG:
+		return "key"
+	case NUM_ARG:
+		return "num"
+	case STR_ARG:
+		return "str"
+	}
+	return "unknown"
+}
+
+type ArgSpec struct {
+	Name string
+	Type ArgType
+	Slot int
+}
+
+type Schema struct {
+	Name  string
+	Args  []ArgSpec
+	nkeys int
+	nnums int
+	nstrs int
+	err   error // The first mistake declaring Args; see Err()
+}
+
+func NewSchema(name string) *Schema {
+	return &Schema{Name: name, Args: make([]ArgSpec, 0)}
+}
+
+// Schemas are declared in package-level vars, where there's nowhere
+// to return an error, so add() records the first mistake for Err() and
+// returns a handle of -1, which panics if it's used.
+func (s *Schema) add(name string, t ArgType, slot *int, max int) int {
+	if _, ok := s.Lookup(name); ok {
+		s.fail("already has an argument named %v", name)
+		return -1
+	}
+	if *slot >= max {
+		s.fail("has too many %v arguments; max is %v", t, max)
+		return -1
+	}
+	s.Args = append(s.Args, ArgSpec{name, t, *slot})
+	*slot++
+	return *slot - 1
+}
+
+func (s *Schema) fail(format string, args ...interface{}) {
+	if s.err == nil {
+		s.err = fmt.Errorf("%w: %v "+format, append([]interface{}{ErrSchema, s.Name}, args...)...)
+	}
+}
+
+// The first mistake declaring s's arguments, or nil.
+// Registry.RegisterSchema() checks it.
+func (s *Schema) Err() error {
+	return s.err
+}
+
+func (s *Schema) Key(name string) KeyArg {
+	return KeyArg(s.add(name, KEY_ARG, &s.nkeys, MAX_KEY_ARGS))
+}
+
+func (s *Schema) Num(name string) NumArg {
+	return NumArg(s.add(name, NUM_ARG, &s.nnums, MAX_NUM_ARGS))
+}
+
+func (s *Schema) Str(name string) StrArg {
+	return StrArg(s.add(name, STR_ARG, &s.nstrs, MAX_STR_ARGS))
+}
+
+func (s *Schema) Lookup(name string) (ArgSpec, bool) {
+	for _, a := range s.Args {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return ArgSpec{}, false
+}
+
+// Print q's arguments by name, for debugging.
+func (s *Schema) Format(q *Query) string {
+	var b bytes.Buffer
+	b.WriteString(s.Name)
+	b.WriteString("(")
+	for i, a := range s.Args {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		switch a.Type {
+		case KEY_ARG:
+			fmt.Fprintf(&b, "%v=%v", a.Name, q.Args.Keys[a.Slot])
+		case NUM_ARG:
+			fmt.Fprintf(&b, "%v=%v", a.Name, q.Args.Nums[a.Slot])
+		case STR_ARG:
+			fmt.Fprintf(&b, "%v=%q", a.Name, q.Args.Strs[a.Slot])
+		}
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+func (q *Query) Key(a KeyArg) Key {
+	return q.Args.Keys[a]
+}
+
+func (q *Query) SetKey(a KeyArg, k Key) {
+	q.Args.Keys[a] = k
+}
+
+func (q *Query) Num(a NumArg) uint64 {
+	return q.Args.Nums[a]
+}
+
+func (q *Query) SetNum(a NumArg, v uint64) {
+	q.Args.Nums[a] = v
+}
+
+func (q *Query) Str(a StrArg) string {
+	return q.Args.Strs[a]
+}
+
+func (q *Query) SetStr(a StrArg, v string) {
+	q.Args.Strs[a] = v
+}
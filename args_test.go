@@ -0,0 +1,76 @@
+package ddtxn
+
+import (
+	"errors"
+	"testing"
+	"unsafe"
+)
+
+func TestSchema(t *testing.T) {
+	s := NewSchema("Test")
+	k := s.Key("k")
+	n1 := s.Num("n1")
+	n2 := s.Num("n2")
+	str := s.Str("s")
+	if int(n1) != 0 || int(n2) != 1 || int(k) != 0 || int(str) != 0 {
+		t.Errorf("Wrong slots %v %v %v %v\n", k, n1, n2, str)
+	}
+	a, ok := s.Lookup("n2")
+	if !ok || a.Type != NUM_ARG || a.Slot != 1 {
+		t.Errorf("Bad lookup %v %v\n", a, ok)
+	}
+	if _, ok := s.Lookup("x"); ok {
+		t.Errorf("Found missing argument\n")
+	}
+
+	var q Query
+	q.SetKey(k, SKey("a"))
+	q.SetNum(n1, 5)
+	q.SetNum(n2, 7)
+	q.SetStr(str, "hi")
+	if q.Key(k) != SKey("a") || q.Num(n1) != 5 || q.Num(n2) != 7 || q.Str(str) != "hi" {
+		t.Errorf("Wrong arguments %v\n", q.Args)
+	}
+	x := s.Format(&q)
+	if x != `Test(k=`+SKey("a").String()+`, n1=5, n2=7, s="hi")` {
+		t.Errorf("Wrong format %v\n", x)
+	}
+}
+
+func TestSchemaErr(t *testing.T) {
+	s := NewSchema("Test")
+	s.Str("s1")
+	s.Str("s2")
+	if s.Err() != nil {
+		t.Errorf("Unexpected error %v\n", s.Err())
+	}
+	if x := s.Str("s3"); x != -1 || !errors.Is(s.Err(), ErrSchema) {
+		t.Errorf("Expected too many strings, got %v %v\n", x, s.Err())
+	}
+	if len(s.Args) != 2 {
+		t.Errorf("Wrong arguments %v\n", s.Args)
+	}
+}
+
+func TestSchemaNoAlloc(t *testing.T) {
+	var q Query
+	n := testing.AllocsPerRun(100, func() {
+		q.SetNum(BID_ITEM, 3)
+		q.SetStr(NI_NAME, "x")
+		_ = q.Num(BID_ITEM) + uint64(len(q.Str(NI_NAME)))
+	})
+	if n != 0 {
+		t.Errorf("Accessors allocated %v times\n", n)
+	}
+}
+
+// Queries are copied into every call and stash.
+func TestQuerySize(t *testing.T) {
+	if n := unsafe.Sizeof(Query{}); n > 208 {
+		t.Errorf("Query is %v bytes\n", n)
+	}
+	q := MicroQuery(D_BUY, UserKey(1), ProductKey(2), -5)
+	if q.Key(MICRO_K1) != UserKey(1) || q.Key(MICRO_K2) != ProductKey(2) || int32(q.Num(MICRO_A)) != -5 {
+		t.Errorf("Wrong arguments %v\n", q.Args)
+	}
+}
@@ -1,6 +1,8 @@
 package ddtxn
 
 import (
+	"encoding/gob"
+	"flag"
 	"fmt"
 	"log"
 	"time"
@@ -19,6 +21,14 @@ const (
 	FEEDBACK       = .95 * NUM_ITEMS
 )
 
+var BidHistory = flag.Int("bidhistory", BIDS_PER_ITEM, "Number of bids kept in each item's bid history\n")
+
+// How to create the BidsPerItemKey() lists: the highest *BidHistory
+// bids.
+func BidListOptions() ListOptions {
+	return ListOptions{Size: *BidHistory}
+}
+
 type User struct {
 	ID       uint64
 	Name     string
@@ -66,15 +76,85 @@ type Comment struct {
 	Comment string
 }
 
+// Arguments of the RUBiS transactions.
+var (
+	RegisterUserSchema = NewSchema("RegisterUser")
+	REG_REGION         = RegisterUserSchema.Num("region")
+	REG_NICKNAME       = RegisterUserSchema.Num("nickname")
+
+	NewItemSchema = NewSchema("NewItem")
+	NI_SELLER     = NewItemSchema.Num("seller")
+	NI_NAME       = NewItemSchema.Str("name")
+	NI_DESC       = NewItemSchema.Str("desc")
+	NI_SPRICE     = NewItemSchema.Num("sprice") // initial price
+	NI_RPRICE     = NewItemSchema.Num("rprice") // reserve price
+	NI_BUYNOW     = NewItemSchema.Num("buynow") // buy now price
+	NI_DUR        = NewItemSchema.Num("dur")
+	NI_QTY        = NewItemSchema.Num("qty")
+	NI_ENDDATE    = NewItemSchema.Num("enddate")
+	NI_CATEG      = NewItemSchema.Num("categ")
+
+	StoreBidSchema = NewSchema("StoreBid")
+	BID_USER       = StoreBidSchema.Num("user")
+	BID_ITEM       = StoreBidSchema.Num("item")
+	BID_PRICE      = StoreBidSchema.Num("price")
+
+	StoreCommentSchema = NewSchema("StoreComment")
+	COM_TO             = StoreCommentSchema.Num("to")
+	COM_FROM           = StoreCommentSchema.Num("from")
+	COM_ITEM           = StoreCommentSchema.Num("item")
+	COM_COMMENT        = StoreCommentSchema.Str("comment")
+	COM_RATING         = StoreCommentSchema.Num("rating")
+
+	StoreBuyNowSchema = NewSchema("StoreBuyNow")
+	BN_USER           = StoreBuyNowSchema.Num("user")
+	BN_ITEM           = StoreBuyNowSchema.Num("item")
+	BN_QTY            = StoreBuyNowSchema.Num("qty")
+
+	ViewBidHistorySchema = NewSchema("ViewBidHistory")
+	VBH_ITEM             = ViewBidHistorySchema.Num("item")
+
+	ViewUserInfoSchema = NewSchema("ViewUserInfo")
+	VU_USER            = ViewUserInfoSchema.Num("user")
+
+	PutBidSchema = NewSchema("PutBid")
+	PB_ITEM      = PutBidSchema.Num("item")
+
+	PutCommentSchema = NewSchema("PutComment")
+	PC_TO            = PutCommentSchema.Num("to")
+	PC_ITEM          = PutCommentSchema.Num("item")
+
+	SearchItemsCategSchema = NewSchema("SearchItemsCateg")
+	SC_CATEG               = SearchItemsCategSchema.Num("categ")
+	SC_NUM                 = SearchItemsCategSchema.Num("num")
+
+	SearchItemsRegionSchema = NewSchema("SearchItemsRegion")
+	SR_REGION               = SearchItemsRegionSchema.Num("region")
+	SR_CATEG                = SearchItemsRegionSchema.Num("categ")
+	SR_NUM                  = SearchItemsRegionSchema.Num("num")
+
+	ViewItemSchema = NewSchema("ViewItem")
+	VI_ITEM        = ViewItemSchema.Num("item")
+)
+
+func init() {
+	// So RUBiS records can be written to the redo log.
+	gob.Register(&User{})
+	gob.Register(&Item{})
+	gob.Register(&Bid{})
+	gob.Register(&BuyNow{})
+	gob.Register(&Comment{})
+}
+
 func RegisterUserTxn(t Query, tx ETransaction) (*Result, error) {
-	region := t.U1
-	nickname := t.U2
+	region := t.Num(REG_REGION)
+	nickname := t.Num(REG_NICKNAME)
 	var r *Result = nil
 
 	var n uint64
 	var nick Key
 
-	if !*Allocate || nickname == 0 {
+	if !tx.Store().cfg.Allocate || nickname == 0 {
 		n = tx.UID('u')
 		nick = NicknameKey(tx.UID('d'))
 	} else {
@@ -108,8 +188,8 @@ func RegisterUserTxn(t Query, tx ETransaction) (*Result, error) {
 		dlog.Printf("RegisterUser() Abort\n")
 		return nil, EABORT
 	}
-	if *Allocate {
-		r = &Result{uint64(n)}
+	if tx.Store().cfg.Allocate {
+		r = &Result{V: uint64(n)}
 		// dlog.Printf("Registered user %v %v\n", nickname, n)
 	}
 	return r, nil
@@ -127,27 +207,27 @@ func NewItemTxn(t Query, tx ETransaction) (*Result, error) {
 	item := ItemKey(n)
 	x := &Item{
 		ID:        n,
-		Name:      t.S1,
-		Seller:    t.U1,
-		Desc:      t.S2,
-		Sprice:    t.U2,
-		Rprice:    t.U3,
-		Buynow:    t.U4,
-		Dur:       t.U5,
-		Qty:       t.U6,
+		Name:      t.Str(NI_NAME),
+		Seller:    t.Num(NI_SELLER),
+		Desc:      t.Str(NI_DESC),
+		Sprice:    t.Num(NI_SPRICE),
+		Rprice:    t.Num(NI_RPRICE),
+		Buynow:    t.Num(NI_BUYNOW),
+		Dur:       t.Num(NI_DUR),
+		Qty:       t.Num(NI_QTY),
 		Startdate: now,
-		Enddate:   t.I,
-		Categ:     t.U7,
+		Enddate:   int(t.Num(NI_ENDDATE)),
+		Categ:     t.Num(NI_CATEG),
 	}
-	urec, err := tx.Read(UserKey(t.U1))
+	urec, err := tx.Read(UserKey(t.Num(NI_SELLER)))
 	if err != nil {
 		if err == ESTASH {
-			dlog.Printf("User stashed %v\n", UserKey(t.U1))
+			dlog.Printf("User stashed %v\n", UserKey(t.Num(NI_SELLER)))
 			return nil, ESTASH
 		} else if err == EABORT {
 			return nil, EABORT
 		} else if err == ENOKEY {
-			fmt.Printf("NewItemTxn(): User doesn't exist %v\n", t.U1)
+			fmt.Printf("NewItemTxn(): User doesn't exist %v\n", t.Num(NI_SELLER))
 			if tx.Commit() == 0 {
 				return nil, EABORT
 			} else {
@@ -195,8 +275,8 @@ func NewItemTxn(t Query, tx ETransaction) (*Result, error) {
 		return r, EABORT
 	}
 
-	if *Allocate {
-		r = &Result{n}
+	if tx.Store().cfg.Allocate {
+		r = &Result{V: n}
 		//dlog.Printf("Registered new item %v %v\n", x, n)
 	}
 	return r, nil
@@ -205,11 +285,11 @@ func NewItemTxn(t Query, tx ETransaction) (*Result, error) {
 // TODO: Check and see if I need more tx.MaybeWrite()s
 func StoreBidTxn(t Query, tx ETransaction) (*Result, error) {
 	var r *Result = nil
-	user := t.U1
-	item := t.U2
-	price := int32(t.U3)
+	user := t.Num(BID_USER)
+	item := t.Num(BID_ITEM)
+	price := int32(t.Num(BID_PRICE))
 	if price < 0 {
-		log.Fatalf("price %v %v", price, t.U3)
+		log.Fatalf("price %v %v", price, t.Num(BID_PRICE))
 	}
 	// insert bid
 	n := tx.UID('b')
@@ -252,7 +332,7 @@ func StoreBidTxn(t Query, tx ETransaction) (*Result, error) {
 		return nil, err
 	}
 	// add to item's bid list
-	e := Entry{int(bid.Price), bid_key, 0}
+	e := NewEntry(int(bid.Price), bid_key, bid)
 	err = tx.WriteList(BidsPerItemKey(item), e, LIST)
 	if err != nil {
 		tx.RelinquishKey(n, 'b')
@@ -266,19 +346,19 @@ func StoreBidTxn(t Query, tx ETransaction) (*Result, error) {
 		return r, EABORT
 	}
 
-	if *Allocate {
-		r = &Result{uint64(n)}
+	if tx.Store().cfg.Allocate {
+		r = &Result{V: uint64(n)}
 		// dlog.Printf("User %v Bid on item %v for %v dollars\n", user, item, price)
 	}
 	return r, nil
 }
 
 func StoreCommentTxn(t Query, tx ETransaction) (*Result, error) {
-	touser := t.U1
-	fromuser := t.U2
-	item := t.U3
-	comment_s := t.S1
-	rating := t.U4
+	touser := t.Num(COM_TO)
+	fromuser := t.Num(COM_FROM)
+	item := t.Num(COM_ITEM)
+	comment_s := t.Str(COM_COMMENT)
+	rating := t.Num(COM_RATING)
 
 	n := tx.UID('c')
 	com := CommentKey(n)
@@ -306,8 +386,8 @@ func StoreCommentTxn(t Query, tx ETransaction) (*Result, error) {
 		return nil, EABORT
 	}
 	var r *Result = nil
-	if *Allocate {
-		r = &Result{uint64(n)}
+	if tx.Store().cfg.Allocate {
+		r = &Result{V: uint64(n)}
 		dlog.Printf("%v Comment %v %v\n", touser, fromuser, item)
 	}
 	return r, nil
@@ -315,25 +395,25 @@ func StoreCommentTxn(t Query, tx ETransaction) (*Result, error) {
 
 func StoreBuyNowTxn(t Query, tx ETransaction) (*Result, error) {
 	now := 1
-	user := t.U1
-	item := t.U2
-	qty := t.U3
+	user := t.Num(BN_USER)
+	item := t.Num(BN_ITEM)
+	qty := t.Num(BN_QTY)
 	bnrec := &BuyNow{
 		BuyerID: user,
 		ItemID:  item,
 		Qty:     qty,
 		Date:    now,
 	}
-	uk := UserKey(t.U1)
+	uk := UserKey(t.Num(BN_USER))
 	br, err := tx.Read(uk)
 	if err != nil {
 		if err == ESTASH {
-			dlog.Printf("User  %v stashed\n", t.U1)
+			dlog.Printf("User  %v stashed\n", t.Num(BN_USER))
 			return nil, ESTASH
 		} else if err == EABORT {
 			return nil, EABORT
 		} else if err == ENOKEY {
-			dlog.Printf("StoreBuyNowTxn(): No user? %v\n", t.U1)
+			dlog.Printf("StoreBuyNowTxn(): No user? %v\n", t.Num(BN_USER))
 			if tx.Commit() == 0 {
 				return nil, EABORT
 			} else {
@@ -389,14 +469,14 @@ func StoreBuyNowTxn(t Query, tx ETransaction) (*Result, error) {
 	}
 
 	var r *Result = nil
-	if *Allocate {
-		r = &Result{qty}
+	if tx.Store().cfg.Allocate {
+		r = &Result{V: qty}
 	}
 	return r, nil
 }
 
 func ViewBidHistoryTxn(t Query, tx ETransaction) (*Result, error) {
-	item := t.U1
+	item := t.Num(VBH_ITEM)
 	ik := ItemKey(item)
 	br, err := tx.Read(ik)
 	if err != nil {
@@ -442,32 +522,37 @@ func ViewBidHistoryTxn(t Query, tx ETransaction) (*Result, error) {
 	var rbids []Bid
 	var rnn []string
 
-	if *Allocate {
+	if tx.Store().cfg.Allocate {
 		rbids = make([]Bid, len(listy))
 		rnn = make([]string, len(listy))
 	}
 
 	for i := 0; i < len(listy); i++ {
-		b, err := tx.Read(listy[i].key)
-		if err != nil {
-			if err == ESTASH {
-				dlog.Printf("ViewBidHist() key stashed %v\n", listy[i].key)
-				return nil, ESTASH
-			} else if err == EABORT {
-				return nil, EABORT
-			} else if err == ENOKEY {
-				dlog.Printf("ViewBidHist() No such key %v\n", listy[i].key)
-				if tx.Commit() == 0 {
+		// Bids never change, so use the copy in the list if there is
+		// one
+		bid, ok := listy[i].payload.(*Bid)
+		if !ok {
+			b, err := tx.Read(listy[i].key)
+			if err != nil {
+				if err == ESTASH {
+					dlog.Printf("ViewBidHist() key stashed %v\n", listy[i].key)
+					return nil, ESTASH
+				} else if err == EABORT {
 					return nil, EABORT
+				} else if err == ENOKEY {
+					dlog.Printf("ViewBidHist() No such key %v\n", listy[i].key)
+					if tx.Commit() == 0 {
+						return nil, EABORT
+					} else {
+						return nil, ENORETRY
+					}
 				} else {
-					return nil, ENORETRY
+					log.Fatalf("err %v\n", err)
 				}
-			} else {
-				log.Fatalf("err %v\n", err)
 			}
+			bid = b.Value().(*Bid)
 		}
-		bid := b.Value().(*Bid)
-		if *Allocate {
+		if tx.Store().cfg.Allocate {
 			rbids[i] = *bid
 		}
 		uk := UserKey(bid.Bidder)
@@ -489,7 +574,7 @@ func ViewBidHistoryTxn(t Query, tx ETransaction) (*Result, error) {
 				log.Fatalf("err %v\n", err)
 			}
 		}
-		if *Allocate {
+		if tx.Store().cfg.Allocate {
 			rnn[i] = u.Value().(*User).Nickname
 		}
 	}
@@ -498,9 +583,9 @@ func ViewBidHistoryTxn(t Query, tx ETransaction) (*Result, error) {
 		return nil, EABORT
 	}
 	var r *Result = nil
-	if *Allocate {
+	if tx.Store().cfg.Allocate {
 		r = &Result{
-			&struct {
+			V: &struct {
 				bids []Bid
 				nns  []string
 			}{rbids, rnn}}
@@ -509,16 +594,16 @@ func ViewBidHistoryTxn(t Query, tx ETransaction) (*Result, error) {
 }
 
 func ViewUserInfoTxn(t Query, tx ETransaction) (*Result, error) {
-	uk := UserKey(t.U1)
+	uk := UserKey(t.Num(VU_USER))
 	urec, err := tx.Read(uk)
 	if err != nil {
 		if err == ESTASH {
-			dlog.Printf("User  %v stashed\n", t.U1)
+			dlog.Printf("User  %v stashed\n", t.Num(VU_USER))
 			return nil, ESTASH
 		} else if err == EABORT {
 			return nil, EABORT
 		} else if err == ENOKEY {
-			dlog.Printf("No user? %v\n", t.U1)
+			dlog.Printf("No user? %v\n", t.Num(VU_USER))
 			if tx.Commit() == 0 {
 				return nil, EABORT
 			} else {
@@ -533,14 +618,14 @@ func ViewUserInfoTxn(t Query, tx ETransaction) (*Result, error) {
 		return nil, EABORT
 	}
 	var r *Result = nil
-	if *Allocate {
-		r = &Result{urec.Value()}
+	if tx.Store().cfg.Allocate {
+		r = &Result{V: urec.Value()}
 	}
 	return r, nil
 }
 
 func PutBidTxn(t Query, tx ETransaction) (*Result, error) {
-	item := t.U1
+	item := t.Num(PB_ITEM)
 
 	ik := ItemKey(item)
 	irec, err := tx.Read(ik)
@@ -628,9 +713,9 @@ func PutBidTxn(t Query, tx ETransaction) (*Result, error) {
 		return nil, EABORT
 	}
 	var r *Result = nil
-	if *Allocate {
+	if tx.Store().cfg.Allocate {
 		r = &Result{
-			&struct {
+			V: &struct {
 				nick string
 				max  int32
 				numb int32
@@ -642,8 +727,8 @@ func PutBidTxn(t Query, tx ETransaction) (*Result, error) {
 
 func PutCommentTxn(t Query, tx ETransaction) (*Result, error) {
 	var r *Result = nil
-	touser := t.U1
-	item := t.U2
+	touser := t.Num(PC_TO)
+	item := t.Num(PC_ITEM)
 	tok := UserKey(touser)
 	torec, err := tx.Read(tok)
 	if err != nil {
@@ -687,9 +772,9 @@ func PutCommentTxn(t Query, tx ETransaction) (*Result, error) {
 	if tx.Commit() == 0 {
 		return r, EABORT
 	}
-	if *Allocate {
+	if tx.Store().cfg.Allocate {
 		r = &Result{
-			&struct {
+			V: &struct {
 				nick  string
 				iname string
 			}{nickname, itemname},
@@ -699,8 +784,8 @@ func PutCommentTxn(t Query, tx ETransaction) (*Result, error) {
 }
 
 func SearchItemsCategTxn(t Query, tx ETransaction) (*Result, error) {
-	categ := t.U1
-	num := t.U2
+	categ := t.Num(SC_CATEG)
+	num := t.Num(SC_NUM)
 	var r *Result = nil
 	if num > 10 {
 		log.Fatalf("Only 10 search items are currently supported.\n")
@@ -736,7 +821,7 @@ func SearchItemsCategTxn(t Query, tx ETransaction) (*Result, error) {
 	var maxb []int32
 	var numb []int32
 
-	if *Allocate {
+	if tx.Store().cfg.Allocate {
 		ret = make([]*Item, len(listy))
 		maxb = make([]int32, len(listy))
 		numb = make([]int32, len(listy))
@@ -758,7 +843,7 @@ func SearchItemsCategTxn(t Query, tx ETransaction) (*Result, error) {
 		} else {
 			val2 := br.Value().(*Item)
 			_ = *val2
-			if *Allocate {
+			if tx.Store().cfg.Allocate {
 				ret[i] = val2
 			}
 		}
@@ -774,7 +859,7 @@ func SearchItemsCategTxn(t Query, tx ETransaction) (*Result, error) {
 		} else {
 			val4 := br.int_value
 			_ = val4
-			if *Allocate {
+			if tx.Store().cfg.Allocate {
 				numb[i] = val4
 			}
 		}
@@ -790,7 +875,7 @@ func SearchItemsCategTxn(t Query, tx ETransaction) (*Result, error) {
 		} else {
 			val3 := br.int_value
 			_ = val3
-			if *Allocate {
+			if tx.Store().cfg.Allocate {
 				maxb[i] = val3
 			}
 		}
@@ -799,9 +884,9 @@ func SearchItemsCategTxn(t Query, tx ETransaction) (*Result, error) {
 	if tx.Commit() == 0 {
 		return r, EABORT
 	}
-	if *Allocate {
+	if tx.Store().cfg.Allocate {
 		r = &Result{
-			&struct {
+			V: &struct {
 				items   []*Item
 				maxbids []int32
 				numbids []int32
@@ -813,9 +898,9 @@ func SearchItemsCategTxn(t Query, tx ETransaction) (*Result, error) {
 }
 
 func SearchItemsRegionTxn(t Query, tx ETransaction) (*Result, error) {
-	region := t.U1
-	categ := t.U2
-	num := t.U3
+	region := t.Num(SR_REGION)
+	categ := t.Num(SR_CATEG)
+	num := t.Num(SR_NUM)
 	var r *Result = nil
 	if num > 10 {
 		log.Fatalf("Only 10 search items are currently supported.\n")
@@ -851,7 +936,7 @@ func SearchItemsRegionTxn(t Query, tx ETransaction) (*Result, error) {
 	var maxb []int32
 	var numb []int32
 
-	if *Allocate {
+	if tx.Store().cfg.Allocate {
 		ret = make([]*Item, len(listy))
 		maxb = make([]int32, len(listy))
 		numb = make([]int32, len(listy))
@@ -877,7 +962,7 @@ func SearchItemsRegionTxn(t Query, tx ETransaction) (*Result, error) {
 		} else {
 			val2 := br.Value().(*Item)
 			_ = *val2
-			if *Allocate {
+			if tx.Store().cfg.Allocate {
 				ret[i] = val2
 			}
 		}
@@ -895,7 +980,7 @@ func SearchItemsRegionTxn(t Query, tx ETransaction) (*Result, error) {
 		} else {
 			val4 := br.int_value
 			_ = val4
-			if *Allocate {
+			if tx.Store().cfg.Allocate {
 				numb[i] = val4
 			}
 		}
@@ -913,7 +998,7 @@ func SearchItemsRegionTxn(t Query, tx ETransaction) (*Result, error) {
 		} else {
 			val3 := br.int_value
 			_ = val3
-			if *Allocate {
+			if tx.Store().cfg.Allocate {
 				maxb[i] = val3
 			}
 		}
@@ -922,9 +1007,9 @@ func SearchItemsRegionTxn(t Query, tx ETransaction) (*Result, error) {
 	if tx.Commit() == 0 {
 		return r, EABORT
 	}
-	if *Allocate {
+	if tx.Store().cfg.Allocate {
 		r = &Result{
-			&struct {
+			V: &struct {
 				items   []*Item
 				maxbids []int32
 				numbids []int32
@@ -936,7 +1021,7 @@ func SearchItemsRegionTxn(t Query, tx ETransaction) (*Result, error) {
 
 func ViewItemTxn(t Query, tx ETransaction) (*Result, error) {
 	var r *Result = nil
-	id := t.U1
+	id := t.Num(VI_ITEM)
 	item, err := tx.Read(ItemKey(id))
 	if err != nil {
 		if err == ESTASH {
@@ -995,8 +1080,8 @@ func ViewItemTxn(t Query, tx ETransaction) (*Result, error) {
 	if tx.Commit() == 0 {
 		return r, EABORT
 	}
-	if *Allocate {
-		r = &Result{&struct {
+	if tx.Store().cfg.Allocate {
+		r = &Result{V: &struct {
 			Item
 			int32
 			uint64
@@ -8,23 +8,24 @@ import (
 )
 
 func TestBasic(t *testing.T) {
-	s := NewStore()
-	c := NewCoordinator(1, s)
+	s := NewStore(FlagConfig())
+	c := NewCoordinator(1, s, BuiltinRegistry())
 	w := c.Workers[0]
 	s.CreateKey(ProductKey(4), int32(0), SUM)
 	s.CreateKey(ProductKey(5), int32(0), WRITE)
 	s.CreateKey(UserKey(1), "u1", WRITE)
 	s.CreateKey(UserKey(2), "u2", WRITE)
 	s.CreateKey(UserKey(3), "u3", WRITE)
-	tx := Query{TXN: D_BUY, K1: UserKey(1), A: int32(5), K2: ProductKey(4), W: nil, T: 0}
+	tx := MicroQuery(D_BUY, UserKey(1), ProductKey(4), 5)
 
 	r, err := w.One(tx)
 	_ = err
 	// Fresh read test
-	tx = Query{TXN: D_READ_ONE, K1: ProductKey(4), W: make(chan struct {
+	tx = MicroQuery(D_READ_ONE, ProductKey(4), Key{}, 0)
+	tx.W = make(chan struct {
 		R *Result
 		E error
-	}), T: 0}
+	})
 	r, err = w.One(tx)
 	dlog.Printf("[test] Returned from one\n")
 	if r.V.(int32) != 5 {
@@ -67,15 +68,15 @@ func Test2RandN(t *testing.T) {
 }
 
 func TestListRecord(t *testing.T) {
-	br := MakeBR(SKey("x"), Entry{1, SKey("y"), 0}, LIST)
+	br := MakeBR(SKey("x"), Entry{order: 1, key: SKey("y")}, LIST)
 	new_entries := make([]Entry, 5)
 	for i := 4; i > 0; i-- {
-		new_entries[4-i] = Entry{i, SKey("x"), 0}
+		new_entries[4-i] = Entry{order: i, key: SKey("x")}
 	}
 	br.Apply(new_entries)
 
 	for i := 4; i > 0; i-- {
-		new_entries[4-i] = Entry{i * 3, SKey("z"), 0}
+		new_entries[4-i] = Entry{order: i * 3, key: SKey("z")}
 	}
 	br.Apply(new_entries)
 
@@ -89,12 +90,12 @@ func TestListRecord(t *testing.T) {
 }
 
 func TestTStore(t *testing.T) {
-	ts := TSInit(10)
+	ts := TSInit(10, DefaultConfig().TriggerCount)
 	if len(ts.t) != 0 {
 		t.Errorf("Should have 0 length\n")
 	}
-	ts.Add(Query{K2: SKey("product")})
-	if ts.t[0].K2 != SKey("product") {
+	ts.Add(MicroQuery(0, Key{}, SKey("product"), 0))
+	if ts.t[0].Key(MICRO_K2) != SKey("product") {
 		t.Errorf("Wrong value %v\n", ts.t)
 	}
 }
@@ -121,39 +122,45 @@ func TestStddev(t *testing.T) {
 }
 
 func TestAuction(t *testing.T) {
-	s := NewStore()
-	c := NewCoordinator(1, s)
+	s := NewStore(FlagConfig())
+	c := NewCoordinator(1, s, BuiltinRegistry())
 	w := c.Workers[0]
 	myname := uint64(12345)
-	tx := Query{TXN: RUBIS_REGISTER, U2: myname, U1: 1}
+	tx := Query{TXN: RUBIS_REGISTER}
+	tx.SetNum(REG_REGION, 1)
+	tx.SetNum(REG_NICKNAME, myname)
 	r, err := w.One(tx)
 	if err != nil {
 		t.Errorf("Register\n")
 	}
 	jaid := r.V.(uint64)
 
-	tx = Query{TXN: RUBIS_NEWITEM, U1: jaid, S1: "burrito", S2: "slightly used burrito",
-		T:  0,
-		U2: 1,   // initial price
-		U3: 2,   // reserve price
-		U4: 5,   // buy now price
-		U5: 100, // duration
-		U6: 10,  // quantity
-		I:  42,  // enddate
-		U7: 1,   // category
-		W:  nil}
+	tx = Query{TXN: RUBIS_NEWITEM, T: 0, W: nil}
+	tx.SetNum(NI_SELLER, jaid)
+	tx.SetStr(NI_NAME, "burrito")
+	tx.SetStr(NI_DESC, "slightly used burrito")
+	tx.SetNum(NI_SPRICE, 1)
+	tx.SetNum(NI_RPRICE, 2)
+	tx.SetNum(NI_BUYNOW, 5)
+	tx.SetNum(NI_DUR, 100)
+	tx.SetNum(NI_QTY, 10)
+	tx.SetNum(NI_ENDDATE, 42)
+	tx.SetNum(NI_CATEG, 1)
 	r, err = w.One(tx)
 	if err != nil {
 		t.Fatalf("New item %v\n", err)
 	}
 	burrito := r.V.(uint64)
 
-	tx = Query{TXN: RUBIS_BID, U1: jaid, U2: burrito, U3: 20}
+	tx = Query{TXN: RUBIS_BID}
+	tx.SetNum(BID_USER, jaid)
+	tx.SetNum(BID_ITEM, burrito)
+	tx.SetNum(BID_PRICE, 20)
 	r, err = w.One(tx)
 	if err != nil {
 		t.Fatalf("Bid %v\n", err)
 	}
-	tx = Query{TXN: D_READ_ONE, K1: MaxBidKey(burrito)}
+	tx = MicroQuery(D_READ_ONE, MaxBidKey(burrito), Key{}, 0)
 	r, err = w.One(tx)
 	if err != nil {
 		t.Errorf("Get bid %v\n", err)
@@ -161,7 +168,9 @@ func TestAuction(t *testing.T) {
 	if r.V.(int32) != 20 {
 		t.Errorf("Wrong max bid %v\n", r)
 	}
-	tx = Query{TXN: RUBIS_SEARCHCAT, U1: 1, U2: 1}
+	tx = Query{TXN: RUBIS_SEARCHCAT}
+	tx.SetNum(SC_CATEG, 1)
+	tx.SetNum(SC_NUM, 1)
 	r, err = w.One(tx)
 	if err != nil {
 		t.Errorf("Search cat\n", err)
@@ -184,7 +193,8 @@ func TestAuction(t *testing.T) {
 		t.Errorf("Wrong numbids %v\n", st.maxbids)
 	}
 
-	tx = Query{TXN: RUBIS_VIEWBIDHIST, U1: burrito}
+	tx = Query{TXN: RUBIS_VIEWBIDHIST}
+	tx.SetNum(VBH_ITEM, burrito)
 	r, err = w.One(tx)
 	if err != nil {
 		t.Fatalf("View Bid Hist\n", err)
@@ -205,9 +215,10 @@ func TestAuction(t *testing.T) {
 }
 
 func TestCandidates(t *testing.T) {
+	cfg := DefaultConfig()
 	h := make([]*OneStat, 0)
 	sh := StatsHeap(h)
-	c := Candidates{make(map[Key]*OneStat), &sh}
+	c := Candidates{make(map[Key]*OneStat), &sh, &cfg}
 	k := ProductKey(1)
 	br := &BRecord{}
 	for i := 0; i < 10; i++ {
@@ -216,7 +227,7 @@ func TestCandidates(t *testing.T) {
 	c.Read(k, br)
 	h2 := make([]*OneStat, 0)
 	sh2 := StatsHeap(h2)
-	c2 := Candidates{make(map[Key]*OneStat), &sh2}
+	c2 := Candidates{make(map[Key]*OneStat), &sh2, &cfg}
 	for i := 0; i < 9; i++ {
 		c2.Write(k, br, SUM)
 	}
@@ -56,8 +56,8 @@ func main() {
 	} else {
 		nproducts = ddtxn.NUM_ITEMS
 	}
-	s := ddtxn.NewStore()
-	coord := ddtxn.NewCoordinator(*nworkers, s)
+	s := ddtxn.NewStore(ddtxn.FlagConfig())
+	coord := ddtxn.NewCoordinator(*nworkers, s, ddtxn.BuiltinRegistry())
 
 	if *ddtxn.CountKeys {
 		for i := 0; i < *nworkers; i++ {
@@ -73,9 +73,8 @@ func main() {
 
 	if !*ddtxn.Allocate {
 		prealloc := time.Now()
-		tmp := *ddtxn.UseRLocks
-		*ddtxn.UseRLocks = true
-		// Preallocate keys
+		// Preallocate keys.  Creating keys locks the chunk whatever
+		// -rlock says.
 
 		bids_per_worker := 200000.0
 		if *nworkers == 20 {
@@ -114,7 +113,6 @@ func main() {
 			}
 			wg.Wait()
 		}
-		*ddtxn.UseRLocks = tmp
 		fmt.Printf("Allocation took %v\n", time.Since(prealloc))
 	}
 	fmt.Printf("Done initializing rubis\n")
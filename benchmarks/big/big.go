@@ -46,8 +46,8 @@ func main() {
 			log.Fatalf("Cannot correctly validate without waiting for results; add -allocate\n")
 		}
 	}
-	s := ddtxn.NewStore()
-	coord := ddtxn.NewCoordinator(*nworkers, s)
+	s := ddtxn.NewStore(ddtxn.FlagConfig())
+	coord := ddtxn.NewCoordinator(*nworkers, s, ddtxn.BuiltinRegistry())
 
 	if *ddtxn.CountKeys {
 		for i := 0; i < *nworkers; i++ {
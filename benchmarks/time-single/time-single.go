@@ -46,14 +46,14 @@ func main() {
 	if *ZipfDist >= 0 && *prob > -1 {
 		log.Fatalf("Set contention to -1 to use Zipf distribution of keys")
 	}
-	s := ddtxn.NewStore()
+	s := ddtxn.NewStore(ddtxn.FlagConfig())
 	for i := 0; i < *nbidders; i++ {
 		k := ddtxn.ProductKey(i)
 		s.CreateKey(k, int32(0), ddtxn.SUM)
 	}
 	dlog.Printf("Done with Populate")
 
-	coord := ddtxn.NewCoordinator(*nworkers, s)
+	coord := ddtxn.NewCoordinator(*nworkers, s, ddtxn.BuiltinRegistry())
 
 	if *ddtxn.CountKeys {
 		for i := 0; i < *nworkers; i++ {
@@ -134,10 +134,10 @@ func main() {
 						if x >= uint64(*nbidders) || x < 0 {
 							log.Fatalf("x not in bounds: %v\n", x)
 						}
-						t.K1 = ddtxn.ProductKey(int(x))
+						t.SetKey(ddtxn.MICRO_K1, ddtxn.ProductKey(int(x)))
 					} else if x < *prob {
 						// contended txn
-						t.K1 = ddtxn.ProductKey(pkey)
+						t.SetKey(ddtxn.MICRO_K1, ddtxn.ProductKey(pkey))
 					} else {
 						// uncontended
 						k := pkey
@@ -153,7 +153,7 @@ func main() {
 								k = int(ddtxn.RandN(&local_seed, uint32(*nbidders)))
 							}
 						}
-						t.K1 = ddtxn.ProductKey(k)
+						t.SetKey(ddtxn.MICRO_K1, ddtxn.ProductKey(k))
 					}
 					t.TXN = ddtxn.D_INCR_ONE
 					if *atomicIncr {
@@ -195,6 +195,7 @@ func main() {
 
 	stats := make([]int64, ddtxn.LAST_STAT)
 	nitr, nwait, _, _, _, _, _ := ddtxn.CollectCounts(coord, stats)
+	txns := ddtxn.CollectTxns(coord)
 
 	for i := 1; i < *clientGoRoutines; i++ {
 		gave_up[0] = gave_up[0] + gave_up[i]
@@ -203,7 +204,7 @@ func main() {
 	// nitr + NABORTS + ENOKEY is how many requests were issued.  A
 	// stashed transaction eventually executes and contributes to
 	// nitr.
-	out := fmt.Sprintf(" nworkers: %v, nwmoved: %v, nrmoved: %v, sys: %v, total/sec: %v, abortrate: %.2f, stashrate: %.2f, rr: %v, nkeys: %v, contention: %v, zipf: %v, done: %v, actual time: %v, nreads: %v, nincrs: %v, epoch changes: %v, throughput ns/txn: %v, naborts: %v, coord time: %v, coord stats time: %v, total worker time transitioning: %v, nstashed: %v, rlock: %v, wrratio: %v, nsamples: %v, getkeys: %v, ddwrites: %v, nolock: %v, failv: %v, nlocked: %v, stashdone: %v, nfast: %v, gaveup: %v, potential: %v ", *nworkers, ddtxn.WMoved, ddtxn.RMoved, *ddtxn.SysType, float64(nitr)/end.Seconds(), 100*float64(stats[ddtxn.NABORTS])/float64(nitr+stats[ddtxn.NABORTS]), 100*float64(stats[ddtxn.NSTASHED])/float64(nitr+stats[ddtxn.NABORTS]), *readrate, *nbidders, *prob, *ZipfDist, nitr, end, stats[ddtxn.D_READ_ONE], stats[ddtxn.D_INCR_ONE], ddtxn.NextEpoch, end.Nanoseconds()/nitr, stats[ddtxn.NABORTS], ddtxn.Time_in_IE, ddtxn.Time_in_IE1, nwait, stats[ddtxn.NSTASHED], *ddtxn.UseRLocks, *ddtxn.WRRatio, stats[ddtxn.NSAMPLES], stats[ddtxn.NGETKEYCALLS], stats[ddtxn.NDDWRITES], stats[ddtxn.NO_LOCK], stats[ddtxn.NFAIL_VERIFY], stats[ddtxn.NLOCKED], stats[ddtxn.NDIDSTASHED], ddtxn.Nfast, gave_up[0], coord.PotentialPhaseChanges)
+	out := fmt.Sprintf(" nworkers: %v, nwmoved: %v, nrmoved: %v, sys: %v, total/sec: %v, abortrate: %.2f, stashrate: %.2f, rr: %v, nkeys: %v, contention: %v, zipf: %v, done: %v, actual time: %v, nreads: %v, nincrs: %v, epoch changes: %v, throughput ns/txn: %v, naborts: %v, coord time: %v, coord stats time: %v, total worker time transitioning: %v, nstashed: %v, rlock: %v, wrratio: %v, nsamples: %v, getkeys: %v, ddwrites: %v, nolock: %v, failv: %v, nlocked: %v, stashdone: %v, nfast: %v, gaveup: %v, potential: %v ", *nworkers, ddtxn.WMoved, ddtxn.RMoved, *ddtxn.SysType, float64(nitr)/end.Seconds(), 100*float64(stats[ddtxn.NABORTS])/float64(nitr+stats[ddtxn.NABORTS]), 100*float64(stats[ddtxn.NSTASHED])/float64(nitr+stats[ddtxn.NABORTS]), *readrate, *nbidders, *prob, *ZipfDist, nitr, end, txns[ddtxn.D_READ_ONE], txns[ddtxn.D_INCR_ONE], ddtxn.NextEpoch, end.Nanoseconds()/nitr, stats[ddtxn.NABORTS], ddtxn.Time_in_IE, ddtxn.Time_in_IE1, nwait, stats[ddtxn.NSTASHED], *ddtxn.UseRLocks, *ddtxn.WRRatio, stats[ddtxn.NSAMPLES], stats[ddtxn.NGETKEYCALLS], stats[ddtxn.NDDWRITES], stats[ddtxn.NO_LOCK], stats[ddtxn.NFAIL_VERIFY], stats[ddtxn.NLOCKED], stats[ddtxn.NDIDSTASHED], ddtxn.Nfast, gave_up[0], coord.PotentialPhaseChanges)
 	//	fmt.Printf(out)
 	//	fmt.Printf("\n")
 
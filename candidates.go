@@ -4,10 +4,9 @@ import (
 	"container/heap"
 	"flag"
 	"fmt"
-	"log"
-	"runtime/debug"
 )
 
+// Flags for FlagConfig()
 var WRRatio = flag.Float64("wr", 2.0, "Ratio of sampled write conflicts and sampled writes to sampled reads at which to move a piece of data to split.  Default 3")
 
 var ConflictWeight = flag.Float64("cw", 2.0, "Weight given to conflicts over writes\n")
@@ -21,10 +20,11 @@ type OneStat struct {
 	conflicts float64
 	stash     float64
 	index     int
+	cfg       *Config
 }
 
 func (o *OneStat) ratio() float64 {
-	return float64((*ConflictWeight)*o.conflicts+o.writes) / (float64((*ReadWeight)*o.reads) + float64(o.stash))
+	return float64(o.cfg.ConflictWeight*o.conflicts+o.writes) / (float64(o.cfg.ReadWeight*o.reads) + float64(o.stash))
 }
 
 // m is very big; it should have every key the worker sampled.  h is a
@@ -38,8 +38,15 @@ func (o *OneStat) ratio() float64 {
 // heap.  But one could imagine eliminating m and only looking at the
 // top set of things in the heap instead.
 type Candidates struct {
-	m map[Key]*OneStat
-	h *StatsHeap
+	m   map[Key]*OneStat
+	h   *StatsHeap
+	cfg *Config
+}
+
+func newCandidates(cfg *Config) *Candidates {
+	x := make([]*OneStat, 0)
+	sh := StatsHeap(x)
+	return &Candidates{make(map[Key]*OneStat), &sh, cfg}
 }
 
 func (c *Candidates) Merge(c2 *Candidates) {
@@ -47,7 +54,7 @@ func (c *Candidates) Merge(c2 *Candidates) {
 		o2 := heap.Pop(c2.h).(*OneStat)
 		o, ok := c.m[o2.k]
 		if !ok {
-			c.m[o2.k] = &OneStat{k: o2.k, op: o2.op, reads: 0, writes: 0, conflicts: 0, stash: 0, index: -1}
+			c.m[o2.k] = &OneStat{k: o2.k, op: o2.op, reads: 0, writes: 0, conflicts: 0, stash: 0, index: -1, cfg: c.cfg}
 			o = c.m[o2.k]
 		}
 		o.reads += o2.reads
@@ -61,12 +68,12 @@ func (c *Candidates) Merge(c2 *Candidates) {
 func (c *Candidates) Read(k Key, br *BRecord) {
 	o, ok := c.m[k]
 	if !ok {
-		c.m[k] = &OneStat{k: k, op: -1, reads: 1, writes: 0, conflicts: 0, stash: 0, index: -1}
+		c.m[k] = &OneStat{k: k, op: -1, reads: 1, writes: 0, conflicts: 0, stash: 0, index: -1, cfg: c.cfg}
 		o = c.m[k]
 	} else {
 		o.reads++
 	}
-	if o.ratio() > *WRRatio || (br != nil && br.dd) {
+	if o.ratio() > c.cfg.WRRatio || (br != nil && br.isDD()) {
 		c.h.update(o)
 	}
 }
@@ -74,50 +81,52 @@ func (c *Candidates) Read(k Key, br *BRecord) {
 // This is only used when a key is in split mode (can't count
 // conflicts anymore because they don't happen).  Make it count for
 // more.
-func (c *Candidates) Write(k Key, br *BRecord, op KeyType) {
+func (c *Candidates) Write(k Key, br *BRecord, op KeyType) error {
 	o, ok := c.m[k]
 	if !ok {
-		c.m[k] = &OneStat{k: k, op: op, reads: 1, writes: 1, conflicts: 0, stash: 0, index: -1}
+		c.m[k] = &OneStat{k: k, op: op, reads: 1, writes: 1, conflicts: 0, stash: 0, index: -1, cfg: c.cfg}
 		o = c.m[k]
 	} else {
 		if o.op == -1 {
 			o.op = op
 		}
 		if op != o.op {
-			debug.PrintStack()
-			log.Fatalf("Do not support multiple types of writes right now key %v, op write: %v op was: %v\n", k, op, o.op)
+			// Can't split a key written as more than one type
+			return typeMismatch(k, op, o.op)
 		}
 		o.writes++
 	}
-	if (o.ratio() > *WRRatio && o.conflicts > 1) || (br != nil && br.dd) {
+	if (o.ratio() > c.cfg.WRRatio && o.conflicts > 1) || (br != nil && br.isDD()) {
 		c.h.update(o)
 	}
+	return nil
 }
 
-func (c *Candidates) Conflict(k Key, br *BRecord, op KeyType) {
+func (c *Candidates) Conflict(k Key, br *BRecord, op KeyType) error {
 	o, ok := c.m[k]
 	if !ok {
-		c.m[k] = &OneStat{k: k, op: op, reads: 1, writes: 0, conflicts: 1, stash: 0, index: -1}
+		c.m[k] = &OneStat{k: k, op: op, reads: 1, writes: 0, conflicts: 1, stash: 0, index: -1, cfg: c.cfg}
 		o = c.m[k]
 	} else {
 		if o.op == -1 {
 			o.op = op
 		}
 		if op != o.op {
-			debug.PrintStack()
-			log.Fatalf("Do not support multiple types of writes right now key %v, op conflict: %v op was: %v\n", k, op, o.op)
+			// Can't split a key written as more than one type
+			return typeMismatch(k, op, o.op)
 		}
 		o.conflicts++
 	}
-	if o.ratio() > *WRRatio || (br != nil && br.dd) {
+	if o.ratio() > c.cfg.WRRatio || (br != nil && br.isDD()) {
 		c.h.update(o)
 	}
+	return nil
 }
 
 func (c *Candidates) Stash(k Key) {
 	o, ok := c.m[k]
 	if !ok {
-		c.m[k] = &OneStat{k: k, op: -1, reads: 0, writes: 0, conflicts: 0, stash: 1, index: -1}
+		c.m[k] = &OneStat{k: k, op: -1, reads: 0, writes: 0, conflicts: 0, stash: 1, index: -1, cfg: c.cfg}
 		o = c.m[k]
 	} else {
 		o.stash++
@@ -128,17 +137,32 @@ func (c *Candidates) Stash(k Key) {
 func (c *Candidates) ReadWrite(k Key, br *BRecord) {
 	o, ok := c.m[k]
 	if !ok {
-		c.m[k] = &OneStat{k: k, op: -1, reads: 5, writes: 0, conflicts: 0, stash: 0, index: -1}
+		c.m[k] = &OneStat{k: k, op: -1, reads: 5, writes: 0, conflicts: 0, stash: 0, index: -1, cfg: c.cfg}
 		o = c.m[k]
 	} else {
 		o.reads = o.reads + 10
 		o.conflicts = o.conflicts - 1
 	}
-	if o.ratio() > *WRRatio || o.index > -1 || br.dd {
+	if o.ratio() > c.cfg.WRRatio || o.index > -1 || br.isDD() {
 		c.h.update(o)
 	}
 }
 
+// Forget keys which were deleted since they were sampled, so they
+// can't be picked to split, first or otherwise.  Nothing may be
+// running transactions.
+func (c *Candidates) dropMissing(s *Store) {
+	for k, o := range c.m {
+		if _, err := s.Get(k); err == nil {
+			continue
+		}
+		if o.index != -1 {
+			heap.Remove(c.h, o.index)
+		}
+		delete(c.m, k)
+	}
+}
+
 func (c *Candidates) Print() {
 	for i := 0; i < len(*c.h); i++ {
 		if i > 20 {
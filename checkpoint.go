@@ -0,0 +1,177 @@
+package ddtxn
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"unsafe"
+
+	"github.com/narula/gotomic"
+)
+
+var ECKPT = errors.New("doppel: truncated checkpoint")
+
+// A checkpoint is a gob stream: a header, one ckptRecord per
+// BRecord, and a final record with End set.
+
+type ckptHeader struct {
+	// Epoch the checkpoint was taken at, 0 if not taken by the
+	// coordinator.
+	Epoch TID
+	// Segments[i] is the first log segment of worker i written after
+	// the checkpoint.  -1 if worker i wasn't logging.
+	Segments []int
+}
+
+type ckptEntry struct {
+	Order   int
+	Key     Key
+	Top     int
+	Payload Value
+}
+
+type ckptRecord struct {
+	K       Key
+	KT      KeyType
+	I       int32
+	I64     int64
+	F       uint64
+	V       Value
+	Entries []ckptEntry
+	List    *ListOptions
+	TID     TID
+	End     bool
+}
+
+// Calls f on every record in the store.  f doesn't hold the chunk's
+// lock, so it can take its time.
+func (s *Store) forEach(f func(br *BRecord)) {
+	if s.cfg.GStore {
+		s.gstore.Each(func(k gotomic.Key, v unsafe.Pointer) bool {
+			f((*BRecord)(v))
+			return false
+		})
+		return
+	}
+	var rows []*BRecord
+	for _, chunk := range s.store {
+		rows = rows[:0]
+		chunk.RLock()
+		for _, br := range chunk.rows {
+			rows = append(rows, br)
+		}
+		chunk.RUnlock()
+		for _, br := range rows {
+			f(br)
+		}
+	}
+}
+
+// Write the records as of snapshot generation gen, which has to stay
+// current until this returns; see pinSnapshot().  With gen 0, write
+// them as they are, which is only consistent if nothing is running.
+func (s *Store) checkpoint(w io.Writer, hdr ckptHeader, gen uint64) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(&hdr); err != nil {
+		return err
+	}
+	var err error
+	var r ckptRecord
+	s.forEach(func(br *BRecord) {
+		if err != nil {
+			return
+		}
+		var v *version
+		if gen == 0 {
+			v = br.copyVersion(0)
+		} else {
+			v = br.snapshot(gen, false)
+		}
+		if v.absent {
+			// Tombstones, and placeholders created by 2PL for reads
+			// of missing keys
+			return
+		}
+		r.K = br.key
+		r.KT = br.key_type
+		r.I = v.int_value
+		r.I64 = v.i64_value
+		r.F = v.f64_value
+		r.V = v.value
+		r.List = br.lopts
+		r.Entries = r.Entries[:0]
+		for _, e := range v.entries {
+			r.Entries = append(r.Entries, ckptEntry{e.order, e.key, e.top, e.payload})
+		}
+		r.TID = TID(br.last.Read())
+		err = enc.Encode(&r)
+	})
+	if err != nil {
+		return err
+	}
+	return enc.Encode(&ckptRecord{End: true})
+}
+
+// Write every record in the store to w.  This does not stop
+// transactions, so it is only consistent if nothing is running; use
+// Coordinator.Checkpoint() on a live system.
+func (s *Store) Checkpoint(w io.Writer) error {
+	return s.checkpoint(w, ckptHeader{}, 0)
+}
+
+func loadCheckpoint(r io.Reader, cfg Config) (*Store, *ckptHeader, error) {
+	dec := gob.NewDecoder(r)
+	hdr := &ckptHeader{}
+	if err := dec.Decode(hdr); err != nil {
+		return nil, nil, err
+	}
+	s := NewStore(cfg)
+	for {
+		var cr ckptRecord
+		err := dec.Decode(&cr)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, ECKPT
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if cr.End {
+			break
+		}
+		br := s.CreateKey(cr.K, nil, cr.KT)
+		br.int_value = cr.I
+		br.i64_value = cr.I64
+		br.f64_value = cr.F
+		br.value = cr.V
+		br.lopts = cr.List
+		for _, e := range cr.Entries {
+			br.entries = append(br.entries, Entry{order: e.Order, key: e.Key, top: e.Top, payload: e.Payload})
+		}
+		br.Lock()
+		br.Unlock(cr.TID)
+	}
+	return s, hdr, nil
+}
+
+// Build a store configured by cfg from a checkpoint written by
+// Store.Checkpoint() or Coordinator.Checkpoint().
+func LoadCheckpoint(r io.Reader, cfg Config) (*Store, error) {
+	s, _, err := loadCheckpoint(r, cfg)
+	return s, err
+}
+
+// Load a checkpoint taken by Coordinator.Checkpoint() and replay the
+// redo logs in dir which were written after it.
+func RecoverCheckpoint(r io.Reader, dir string, cfg Config) (*Store, error) {
+	s, hdr, err := loadCheckpoint(r, cfg)
+	if err != nil {
+		return nil, err
+	}
+	from := hdr.Segments
+	if from == nil {
+		from = []int{}
+	}
+	if err := s.replayLogs(dir, from); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
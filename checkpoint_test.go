@@ -0,0 +1,176 @@
+package ddtxn
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	s := NewStore(FlagConfig())
+	s.CreateKey(ProductKey(4), int32(7), SUM)
+	s.CreateKey(UserKey(1), "alice", WRITE)
+	s.CreateKey(SKey("list"), Entry{order: 3, key: UserKey(1), top: 0}, LIST)
+	s.CreateList(SKey("asc"), ListOptions{Size: 2, Ascending: true}).AddOneToRecord(NewEntry(5, UserKey(2), "bob"))
+
+	var buf bytes.Buffer
+	if err := s.Checkpoint(&buf); err != nil {
+		t.Fatalf("Checkpoint %v\n", err)
+	}
+	n := buf.Len()
+	s2, err := LoadCheckpoint(bytes.NewReader(buf.Bytes()), s.Config())
+	if err != nil {
+		t.Fatalf("LoadCheckpoint %v\n", err)
+	}
+	br, err := s2.Get(ProductKey(4))
+	if err != nil || br.Value().(int32) != 7 {
+		t.Errorf("Wrong product after load %v %v\n", br, err)
+	}
+	br, err = s2.Get(UserKey(1))
+	if err != nil || br.Value().(string) != "alice" {
+		t.Errorf("Wrong user after load %v %v\n", br, err)
+	}
+	br, err = s2.Get(SKey("list"))
+	if err != nil {
+		t.Fatalf("No list after load %v\n", err)
+	}
+	e := br.Value().([]Entry)
+	if len(e) != 1 || e[0].order != 3 || e[0].key != UserKey(1) {
+		t.Errorf("Wrong list after load %v\n", e)
+	}
+	br, err = s2.Get(SKey("asc"))
+	if err != nil || br.lopts == nil || !br.lopts.Ascending || br.entries[0].Payload() != "bob" {
+		t.Fatalf("Lost list options or payload %v %v\n", br, err)
+	}
+	br.AddOneToRecord(NewEntry(9, UserKey(3), nil))
+	br.AddOneToRecord(NewEntry(1, UserKey(4), nil))
+	if !sameOrders(br.entries, 1, 5) {
+		t.Errorf("Wrong list after load %v\n", orders(br.entries))
+	}
+
+	_, err = LoadCheckpoint(bytes.NewReader(buf.Bytes()[:n-4]), s.Config())
+	if err == nil {
+		t.Errorf("Loaded a truncated checkpoint\n")
+	}
+}
+
+func TestRecoverCheckpoint(t *testing.T) {
+	cfg := FlagConfig()
+	cfg.LogDir = t.TempDir()
+	s := NewStore(cfg)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	w := c.Workers[0]
+	s.CreateKey(ProductKey(4), int32(0), SUM)
+	s.CreateKey(UserKey(1), int32(0), SUM)
+	buy := func() {
+		tx := MicroQuery(D_BUY, UserKey(1), ProductKey(4), 5)
+		if _, err := w.One(tx); err != nil {
+			t.Fatalf("Buy %v\n", err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		buy()
+	}
+	var buf bytes.Buffer
+	if err := c.Checkpoint(&buf); err != nil {
+		t.Fatalf("Checkpoint %v\n", err)
+	}
+	for i := 0; i < 5; i++ {
+		buy()
+	}
+	c.Finish()
+
+	s2, err := RecoverCheckpoint(&buf, cfg.LogDir, cfg)
+	if err != nil {
+		t.Fatalf("RecoverCheckpoint %v\n", err)
+	}
+	br, err := s2.Get(ProductKey(4))
+	if err != nil {
+		t.Fatalf("No product after recovery %v\n", err)
+	}
+	if br.Value().(int32) != 75 {
+		t.Errorf("Wrong product value after recovery %v\n", br.Value())
+	}
+	br, err = s2.Get(UserKey(1))
+	if err != nil {
+		t.Fatalf("No user after recovery %v\n", err)
+	}
+	if br.Value().(int32) != 15 {
+		t.Errorf("Wrong user value after recovery %v\n", br.Value())
+	}
+}
+
+// Holds up the first write until release is closed.
+type slowWriter struct {
+	bytes.Buffer
+	once    sync.Once
+	started chan bool
+	release chan bool
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.started)
+		<-w.release
+	})
+	return w.Buffer.Write(p)
+}
+
+// Transactions commit while a checkpoint is being written, and it
+// doesn't see them.
+func TestCheckpointWhileRunning(t *testing.T) {
+	for _, sys := range []int{DOPPEL, OCC, LOCKING, SSI} {
+		cfg := FlagConfig()
+		cfg.SysType = sys
+		s := NewStore(cfg)
+		s.CreateKey(ProductKey(4), int32(0), SUM)
+		s.CreateKey(UserKey(1), int32(0), SUM)
+		c := NewCoordinator(2, s, BuiltinRegistry())
+		w := c.Workers[0]
+		buy := func() error {
+			errc := make(chan error, 1)
+			go func() {
+				_, err := w.One(MicroQuery(D_BUY, UserKey(1), ProductKey(4), 5))
+				errc <- err
+			}()
+			select {
+			case err := <-errc:
+				return err
+			case <-time.After(10 * time.Second):
+				return EABORT
+			}
+		}
+		buy()
+		buy()
+
+		sw := &slowWriter{started: make(chan bool), release: make(chan bool)}
+		done := make(chan error, 1)
+		go func() { done <- c.Checkpoint(sw) }()
+		<-sw.started
+		for i := 0; i < 3; i++ {
+			if err := buy(); err != nil {
+				t.Errorf("%v: Buy during checkpoint %v\n", sys, err)
+				break
+			}
+		}
+		close(sw.release)
+		if err := <-done; err != nil {
+			t.Fatalf("%v: Checkpoint %v\n", sys, err)
+		}
+		c.Finish()
+
+		s2, err := LoadCheckpoint(&sw.Buffer, cfg)
+		if err != nil {
+			t.Fatalf("%v: LoadCheckpoint %v\n", sys, err)
+		}
+		if br, err := s2.Get(ProductKey(4)); err != nil {
+			t.Errorf("%v: No product in checkpoint %v\n", sys, err)
+		} else if br.Value() != int32(10) {
+			t.Errorf("%v: Wrong value in checkpoint %v\n", sys, br.Value())
+		}
+		if br, _ := s.Get(ProductKey(4)); br.Value() != int32(25) {
+			t.Errorf("%v: Wrong value after checkpoint %v\n", sys, br.Value())
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package ddtxn
+
+import (
+	"time"
+)
+
+// How a Store, and the Coordinator and Workers running on it, behave.
+// Each Store keeps its own copy, so differently configured stores can
+// live in one process.  The command-line flags are just one way to
+// fill it in; see FlagConfig().
+type Config struct {
+	SysType int // DOPPEL, OCC, LOCKING or SSI
+
+	// The store
+	NChunks   int  // Chunks the hash map is split into
+	UseRLocks bool // Lock chunks; if false keys must all be created up front
+	GStore    bool // Use a gotomic hash map instead of Go maps
+	Spinlock  bool // Use spinlocks for 2PL
+
+	// Phases
+	PhaseLength  time.Duration
+	GCInterval   time.Duration // Between pausing workers to remove deleted keys and start snapshots
+	TriggerCount int           // Stashed transactions which trigger a phase change
+	StashRetries int           // Times to retry an aborted stashed transaction in the join phase
+	SpinBarrier  bool          // Spin on counters at phase changes instead of using channels
+	// Wait for a worker to notice a phase change before merging for
+	// it, if it's idle; 0 never does
+	StragglerTimeout time.Duration
+
+	// Adapting the phase length, starting from PhaseLength; see
+	// phaseController
+	AdaptivePhase bool
+	MinPhase      time.Duration
+	MaxPhase      time.Duration
+	StashLatency  time.Duration // Longest a stashed transaction should wait
+	MergeCost     float64       // Fraction of the time to spend reconciling
+
+	// Deciding what to split
+	AlwaysSplit    bool
+	SampleRate     int64   // Sample every SampleRate transactions
+	WRRatio        float64 // Ratio of sampled conflicts and writes to reads at which to split
+	ConflictWeight float64 // Weight given to conflicts over writes
+	ReadWeight     float64 // Weight given to reads over stashes
+	NoConflictType int     // KeyType not to record conflicts on, or -1
+	// Picks the keys to split from the samples every SplitInterval
+	// checks for a phase change; nil means DefaultSplitPolicy().
+	// Policies keep state, so don't share one between stores.
+	SplitPolicy   SplitPolicy
+	SplitInterval int
+
+	MaxKeys  int    // Most keys a transaction can read, or write
+	LogDir   string // Directory for per-worker redo logs; empty means none
+	Allocate bool   // Return results from transactions; if false most return nil
+
+	// Measurement
+	CountKeys bool
+	Latency   bool
+	Conflicts bool
+}
+
+// The defaults, which are also the flags' defaults.
+func DefaultConfig() Config {
+	return Config{
+		SysType:          DOPPEL,
+		NChunks:          CHUNKS,
+		UseRLocks:        true,
+		PhaseLength:      20 * time.Millisecond,
+		GCInterval:       100 * time.Millisecond,
+		TriggerCount:     100000,
+		StashRetries:     10,
+		MinPhase:         2 * time.Millisecond,
+		MaxPhase:         200 * time.Millisecond,
+		StashLatency:     50 * time.Millisecond,
+		MergeCost:        0.1,
+		SampleRate:       500,
+		WRRatio:          2.0,
+		ConflictWeight:   2.0,
+		ReadWeight:       0.5,
+		NoConflictType:   -1,
+		SplitInterval:    10,
+		MaxKeys:          100000,
+		Allocate:         true,
+	}
+}
+
+// The configuration the command-line flags ask for.  Call after
+// flag.Parse().
+func FlagConfig() Config {
+	return Config{
+		SysType:          *SysType,
+		NChunks:          *NChunks,
+		UseRLocks:        *UseRLocks,
+		GStore:           *GStore,
+		Spinlock:         *Spinlock,
+		PhaseLength:      time.Duration(*PhaseLength) * time.Millisecond,
+		GCInterval:       time.Duration(*GCInterval) * time.Millisecond,
+		TriggerCount:     *TriggerCount,
+		StashRetries:     *StashRetries,
+		SpinBarrier:      *SpinBarrier,
+		StragglerTimeout: time.Duration(*Straggler) * time.Microsecond,
+		AdaptivePhase:    *AdaptivePhase,
+		MinPhase:         time.Duration(*MinPhase) * time.Millisecond,
+		MaxPhase:         time.Duration(*MaxPhase) * time.Millisecond,
+		StashLatency:     time.Duration(*StashLatency) * time.Millisecond,
+		MergeCost:        *MergeCost,
+		AlwaysSplit:      *AlwaysSplit,
+		SampleRate:       *SampleRate,
+		WRRatio:          *WRRatio,
+		ConflictWeight:   *ConflictWeight,
+		ReadWeight:       *ReadWeight,
+		NoConflictType:   *NoConflictType,
+		SplitInterval:    *SplitInterval,
+		MaxKeys:          *MaxKeys,
+		LogDir:           *LogDir,
+		Allocate:         *Allocate,
+		CountKeys:        *CountKeys,
+		Latency:          *Latency,
+		Conflicts:        *Conflicts,
+	}
+}
+
+// How often to check for a phase change; phases can't be shorter.
+func (cfg *Config) shortestPhase() time.Duration {
+	if cfg.AdaptivePhase && cfg.MinPhase < cfg.PhaseLength {
+		return cfg.MinPhase
+	}
+	return cfg.PhaseLength
+}
@@ -0,0 +1,63 @@
+package ddtxn
+
+import (
+	"testing"
+)
+
+func TestTwoConfigs(t *testing.T) {
+	cfg := noGC()
+	cfg.SysType = LOCKING
+	cfg.MaxKeys = 1
+	s1 := NewStore(cfg)
+	cfg2 := noGC()
+	cfg2.SysType = OCC
+	s2 := NewStore(cfg2)
+
+	var c [2]*Coordinator
+	for i, s := range []*Store{s1, s2} {
+		s.CreateKey(ProductKey(1), int32(0), SUM)
+		s.CreateKey(UserKey(1), int32(0), SUM)
+		c[i] = NewCoordinator(1, s, BuiltinRegistry())
+		defer c[i].Finish()
+	}
+	if _, ok := c[0].Workers[0].E.(*LTransaction); !ok {
+		t.Errorf("Expected 2PL, got %T\n", c[0].Workers[0].E)
+	}
+	if _, ok := c[1].Workers[0].E.(*OTransaction); !ok {
+		t.Errorf("Expected OCC, got %T\n", c[1].Workers[0].E)
+	}
+
+	q := MicroQuery(D_BUY, UserKey(1), ProductKey(1), 5)
+	if _, err := c[0].Workers[0].One(q); err != ETOOBIG {
+		t.Errorf("Expected ETOOBIG with one key, got %v\n", err)
+	}
+	if _, err := c[1].Workers[0].One(q); err != nil {
+		t.Errorf("Buy %v\n", err)
+	}
+	if br, _ := s2.Get(ProductKey(1)); br.Value() != int32(5) {
+		t.Errorf("Wrong value %v\n", br.Value())
+	}
+	if s1.Config().MaxKeys != 1 || s2.Config().MaxKeys != cfg2.MaxKeys {
+		t.Errorf("Wrong configs %v %v\n", s1.Config(), s2.Config())
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	cfg := noGC()
+	cfg.Allocate = false
+	s := NewStore(cfg)
+	s.CreateKey(ProductKey(1), int32(7), SUM)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+
+	q := MicroQuery(D_READ_ONE, ProductKey(1), Key{}, 0)
+	if r, err := c.Workers[0].One(q); err != nil || r != nil {
+		t.Errorf("Expected no result, got %v %v\n", r, err)
+	}
+	if was := s.SetAllocate(true); was {
+		t.Errorf("Expected Allocate off\n")
+	}
+	if r, err := c.Workers[0].One(q); err != nil || r == nil || r.V != int32(7) {
+		t.Errorf("Expected 7, got %v %v\n", r, err)
+	}
+}
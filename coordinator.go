@@ -1,13 +1,17 @@
 package ddtxn
 
 import (
-	"container/heap"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/narula/ddtxn/stats"
 	"github.com/narula/dlog"
 )
 
@@ -18,12 +22,18 @@ const (
 	CLEAR_TID     = 0xffffffff00000000
 )
 
+// Flags for FlagConfig(); a Coordinator goes by its Store's Config.
 var PhaseLength = flag.Int("phase", 20, "Phase length in milliseconds, default 20")
+var SpinBarrier = flag.Bool("spinbarrier", false, "Spin on shared counters at phase changes instead of handing off on channels\n")
+var Straggler = flag.Int("straggler", 0, "Microseconds to wait for a worker to notice a phase change before merging for it, if it's idle; 0, the default, never does\n")
+var GCInterval = flag.Int("gc", 100, "Milliseconds between pausing workers to remove deleted keys and start snapshots\n")
 
 type Coordinator struct {
 	n        int
 	Workers  []*Worker
-	epochTID uint64 // Global TID, atomically incremented and read
+	reg      *Registry
+	cfg      *Config // The Store's
+	epochTID uint64  // Global TID, atomically incremented and read
 
 	padding [128]byte
 	// Notify workers
@@ -32,11 +42,11 @@ type Coordinator struct {
 	wgo    []chan TID
 	wdone  []chan TID
 
-	// Used in spin-based phase transitions
+	// Used in spin-based phase transitions, with Config.SpinBarrier
 	wcepoch  uint64 // Count of workers who have seen epoch change AND merged
-	gojoin   uint64 // 0 or 1; Tells workers safe to progress to JOIN phase
+	gojoin   uint64 // Epoch; Tells workers safe to progress to JOIN phase
 	wcdone   uint64 // Count of workers who have finished JOIN phase
-	gosplit  uint64 // 0 or 1; Tells workers safe to progress to SPLIT phase
+	gosplit  uint64 // Epoch; Tells workers safe to progress to SPLIT phase
 	padding1 [128]byte
 
 	Coordinate            bool
@@ -44,9 +54,22 @@ type Coordinator struct {
 	Done                  chan chan bool
 	Accelerate            chan bool
 	trigger               int32
-	to_remove             map[Key]bool
+	policy                SplitPolicy
+	checkpoint            chan *ckptRequest
+	ckpt                  *ckptRequest  // taken at the next merge barrier
+	ckptDone              chan struct{} // closed once the one being written is
+
+	// Shutdown()
+	stopping sync.Once
+	closed   chan struct{}  // Closed once One() starts refusing queries
+	stopped  chan struct{}  // Closed once every goroutine below exited
+	wg       sync.WaitGroup // Process() and the workers' run()
 
 	StartTime      time.Time
+	phase          *phaseController // If Config.AdaptivePhase
+	phaseEnd       time.Time        // When the last phase change finished
+	phaseLength    int64            // Current phase length; atomic
+	LastPhase      PhaseStats
 	Finished       []bool
 	TotalCoordTime time.Duration
 	GoTime         time.Duration
@@ -54,10 +77,13 @@ type Coordinator struct {
 	MergeTime      time.Duration
 }
 
-func NewCoordinator(n int, s *Store) *Coordinator {
+// Workers run the transactions in reg, configured by s.Config().
+func NewCoordinator(n int, s *Store, reg *Registry) *Coordinator {
 	c := &Coordinator{
 		n:                     n,
 		Workers:               make([]*Worker, n),
+		reg:                   reg,
+		cfg:                   &s.cfg,
 		epochTID:              EPOCH_INCR,
 		wepoch:                make([]chan TID, n),
 		wsafe:                 make([]chan TID, n),
@@ -65,10 +91,22 @@ func NewCoordinator(n int, s *Store) *Coordinator {
 		wdone:                 make([]chan TID, n),
 		Done:                  make(chan chan bool),
 		Accelerate:            make(chan bool),
+		checkpoint:            make(chan *ckptRequest),
+		closed:                make(chan struct{}),
+		stopped:               make(chan struct{}),
 		Coordinate:            false,
 		PotentialPhaseChanges: 0,
-		to_remove:             make(map[Key]bool),
+		policy:                s.cfg.SplitPolicy,
 		Finished:              make([]bool, n),
+		phaseEnd:              time.Now(),
+		phaseLength:           int64(s.cfg.PhaseLength),
+	}
+	if c.policy == nil {
+		c.policy = DefaultSplitPolicy(&s.cfg)
+	}
+	if s.cfg.AdaptivePhase {
+		c.phase = newPhaseController(&s.cfg)
+		c.phaseLength = int64(c.phase.length)
 	}
 	for i := 0; i < n; i++ {
 		c.wepoch[i] = make(chan TID)
@@ -80,10 +118,15 @@ func NewCoordinator(n int, s *Store) *Coordinator {
 	}
 	c.Finished = make([]bool, n)
 	dlog.Printf("[coordinator] %v workers\n", n)
+	c.wg.Add(1)
 	go c.Process()
 	return c
 }
 
+func (c *Coordinator) Registry() *Registry {
+	return c.reg
+}
+
 var NextEpoch int64
 
 func (c *Coordinator) NextGlobalTID() TID {
@@ -109,7 +152,7 @@ func (c *Coordinator) Stats() (map[Key]bool, map[Key]bool) {
 			return nil, nil
 		}
 	}
-	if c.PotentialPhaseChanges%(10) != 0 {
+	if n := int64(c.cfg.SplitInterval); n > 1 && c.PotentialPhaseChanges%n != 0 {
 		return nil, nil
 	}
 	start2 := time.Now()
@@ -119,61 +162,23 @@ func (c *Coordinator) Stats() (map[Key]bool, map[Key]bool) {
 		c.Workers[i].Lock()
 		s.cand.Merge(w.local_store.candidates)
 	}
-	potential_dd_keys := make(map[Key]bool)
-	to_remove := make(map[Key]bool)
-	xx := len(*s.cand.h)
-	for i := 0; i < xx; i++ {
-		o := heap.Pop(s.cand.h).(*OneStat)
-		br, _ := s.getKey(o.k, nil)
-		if !br.dd {
-			if !s.any_dd {
-				// Higher threshold for the first one, since it kicks off phases
-				if o.ratio() > 1.33*(*WRRatio) && (o.writes > 1 || o.conflicts > 5) {
-					potential_dd_keys[o.k] = true
-					dlog.Printf("move %v to split1 r:%v w:%v c:%v s:%v ra:%v after: %v\n", o.k, o.reads, o.writes, o.conflicts, o.stash, o.ratio(), c.PotentialPhaseChanges)
-					s.any_dd = true
-				} else {
-					dlog.Printf("%v no move inertia r:%v w:%v c:%v s:%v ra:%v after: %v\n", o.k, o.reads, o.writes, o.conflicts, o.stash, o.ratio(), c.PotentialPhaseChanges)
-				}
-				continue
-			}
-			if o.ratio() > *WRRatio && (o.writes > 1 || o.conflicts > 1) {
-				potential_dd_keys[o.k] = true
-				dlog.Printf("move %v to split2 r:%v w:%v c:%v s:%v ra:%v after: %v\n", o.k, o.reads, o.writes, o.conflicts, o.stash, o.ratio(), c.PotentialPhaseChanges)
-				s.any_dd = true
-			} else {
-				dlog.Printf("too low; no move :%v; r:%v w:%v c:%v s:%v ra:%v; wr: %v\n", o.k, o.reads, o.writes, o.conflicts, o.stash, o.ratio(), *WRRatio)
-			}
+	s.cand.dropMissing(s)
+	dd := s.splitKeys()
+	potential_dd_keys, to_remove := c.policy.Classify(s.cand, dd)
+	for k := range potential_dd_keys {
+		if dd[k] {
+			delete(potential_dd_keys, k)
+		} else if _, err := s.Get(k); err != nil {
+			// Deleted, or the policy made it up
+			delete(potential_dd_keys, k)
 		}
 	}
-	// Check to see if we need to remove anything from dd
-	for k, v := range s.dd {
-		if !v {
-			continue
-		}
-		o, ok := s.cand.m[k]
-		if !ok {
-			dlog.Printf("Key %v was split but now is not in store candidates\n", k)
-			if x, ok := c.to_remove[k]; x && ok {
-				c.to_remove[k] = false
-				to_remove[k] = true
-			} else {
-				c.to_remove[k] = true
-			}
-			dlog.Printf("move %v from split2 \n", k)
-			continue
-		}
-		if o.ratio() < (*WRRatio)/2 {
-			if x, ok := c.to_remove[k]; x && ok {
-				c.to_remove[k] = false
-				to_remove[k] = true
-			} else {
-				c.to_remove[k] = true
-			}
-			dlog.Printf("move %v from split r:%v w:%v c:%v s:%v ratio:%v\n", k, o.reads, o.writes, o.conflicts, o.stash, o.ratio())
+	for k := range to_remove {
+		if !dd[k] {
+			delete(to_remove, k)
 		}
 	}
-	if len(s.dd) == 0 && len(potential_dd_keys) == 0 {
+	if len(dd) == 0 && len(potential_dd_keys) == 0 {
 		if c.Coordinate {
 			fmt.Printf("Do not have to coordinate! after %v phases\n", c.PotentialPhaseChanges)
 		}
@@ -187,16 +192,12 @@ func (c *Coordinator) Stats() (map[Key]bool, map[Key]bool) {
 		s.any_dd = true
 	}
 	// Reset global store
-	x := make([]*OneStat, 0)
-	sh := StatsHeap(x)
-	s.cand = &Candidates{make(map[Key]*OneStat), &sh}
+	s.cand = newCandidates(&s.cfg)
 
 	for i := 0; i < len(c.Workers); i++ {
 		// Reset local stores and unlock
 		w := c.Workers[i]
-		x := make([]*OneStat, 0)
-		sh := StatsHeap(x)
-		w.local_store.candidates = &Candidates{make(map[Key]*OneStat), &sh}
+		w.local_store.candidates = newCandidates(&s.cfg)
 		w.Unlock()
 	}
 	end := time.Since(start2)
@@ -209,7 +210,7 @@ func (c *Coordinator) IncrementEpoch(force bool) {
 	c.PotentialPhaseChanges++
 	s := c.Workers[0].store
 	var move_dd, remove_dd map[Key]bool
-	if *AlwaysSplit {
+	if c.cfg.AlwaysSplit {
 		c.Coordinate = true
 		s.any_dd = true
 	} else {
@@ -221,92 +222,430 @@ func (c *Coordinator) IncrementEpoch(force bool) {
 	}
 	c.StartTime = time.Now()
 	next_epoch := c.NextGlobalTID()
+	c.tickle(next_epoch)
 
 	// Wait for everyone to merge the previous epoch
-	for i := 0; i < c.n; i++ {
-		e := <-c.wepoch[i]
-		if e != next_epoch {
-			log.Fatalf("Out of alignment in epoch ack; I expected %v, got %v\n", next_epoch, e)
+	proxied := c.waitMerged(next_epoch)
+	c.MergeTime += time.Since(c.StartTime)
+
+	// Every worker has merged and is waiting for wsafe, so the store
+	// holds exactly the transactions up to next_epoch, and their
+	// stashes are done growing.
+	st := PhaseStats{Length: c.StartTime.Sub(c.phaseEnd)}
+	var oldest time.Time
+	for _, w := range c.Workers {
+		if w.waiters.n == 0 {
+			continue
+		}
+		if w.waiters.n > st.Stashed {
+			st.Stashed = w.waiters.n
+		}
+		if oldest.IsZero() || w.waiters.first.Before(oldest) {
+			oldest = w.waiters.first
 		}
 	}
-	c.MergeTime += time.Since(c.StartTime)
+	s.nextSnapshot()
+	if c.ckpt != nil {
+		c.startCheckpoint(c.ckpt, next_epoch)
+		c.ckpt = nil
+	}
 
 	// All merged.  The previous epoch is now safe; tell everyone to
 	// do their reads.
 	sx := time.Now()
 	atomic.StoreInt32(&c.trigger, 0)
-	for i := 0; i < c.n; i++ {
-		c.wsafe[i] <- next_epoch
-	}
-	for i := 0; i < c.n; i++ {
-		e := <-c.wdone[i]
-		if e != next_epoch {
-			log.Fatalf("Out of alignment in done; I expected %v, got %v\n", next_epoch, e)
+	if c.cfg.SpinBarrier {
+		atomic.StoreUint64(&c.gojoin, uint64(next_epoch))
+		c.proxyJoin(proxied)
+		spinUntil(&c.wcdone, uint64(c.n))
+		atomic.StoreUint64(&c.wcdone, 0)
+	} else {
+		for i := 0; i < c.n; i++ {
+			if !proxied[i] {
+				c.wsafe[i] <- next_epoch
+			}
 		}
+		c.proxyJoin(proxied)
+		for i := 0; i < c.n; i++ {
+			if proxied[i] {
+				continue
+			}
+			e := <-c.wdone[i]
+			if e != next_epoch {
+				log.Fatalf("Out of alignment in done; I expected %v, got %v\n", next_epoch, e)
+			}
 
+		}
 	}
 	c.ReadTime += time.Since(sx)
+	if !oldest.IsZero() {
+		st.Latency = time.Since(oldest)
+	}
 	// Merge dd
-	if !*AlwaysSplit {
-		if move_dd != nil {
-			for k, _ := range move_dd {
-				br, _ := s.getKey(k, nil)
-				br.dd = true
-				s.dd[k] = true
-				WMoved += 1
+	if !c.cfg.AlwaysSplit && len(move_dd)+len(remove_dd) > 0 {
+		s.moveSplit(move_dd, remove_dd)
+		WMoved += int64(len(move_dd))
+		RMoved += int64(len(remove_dd))
+	}
+
+	sx = time.Now()
+	if c.cfg.SpinBarrier {
+		atomic.StoreUint64(&c.gosplit, uint64(next_epoch))
+	} else {
+		for i := 0; i < c.n; i++ {
+			if !proxied[i] {
+				c.wgo[i] <- next_epoch
+			}
+		}
+	}
+	for i, w := range c.Workers {
+		if proxied[i] {
+			w.epoch = next_epoch
+			w.Unlock()
+		}
+	}
+	c.GoTime += time.Since(sx)
+	c.TotalCoordTime += time.Since(start1)
+	c.phaseEnd = time.Now()
+	st.Cost = c.phaseEnd.Sub(c.StartTime)
+	c.LastPhase = st
+	if c.phase != nil {
+		atomic.StoreInt64(&c.phaseLength, int64(c.phase.next(st)))
+	}
+}
+
+// How long split phases are now.  Changes after every phase change
+// with Config.AdaptivePhase.
+func (c *Coordinator) PhaseLength() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.phaseLength))
+}
+
+// Tell the workers about epoch e now, rather than whenever their
+// run() ticks or they're next called.
+func (c *Coordinator) tickle(e TID) {
+	for _, w := range c.Workers {
+		select {
+		case w.tickle <- e:
+		default:
+		}
+	}
+}
+
+// Wait for every worker to merge for epoch e.  Past
+// Config.StragglerTimeout I merge for the ones that haven't noticed
+// and aren't running a transaction, and hold their locks until the
+// phase change is over; those are true in the result.  A worker stuck
+// in a transaction holds up the phase change until it's done.
+func (c *Coordinator) waitMerged(e TID) []bool {
+	proxied := make([]bool, c.n)
+	late := make(chan struct{})
+	if c.cfg.StragglerTimeout > 0 {
+		t := time.AfterFunc(c.cfg.StragglerTimeout-time.Since(c.StartTime), func() { close(late) })
+		defer t.Stop()
+	}
+	if c.cfg.SpinBarrier {
+		wait := (<-chan struct{})(late)
+		var retry <-chan time.Time
+		for i := 0; atomic.LoadUint64(&c.wcepoch) != uint64(c.n); i++ {
+			select {
+			case <-wait:
+			case <-retry:
+			default:
+				if i >= 1000 {
+					runtime.Gosched()
+				}
+				continue
 			}
+			for j, w := range c.Workers {
+				// Fails for anyone merged: they hold their lock
+				// until the phase change is over.
+				if c.proxyMerge(w, e) {
+					proxied[j] = true
+					atomic.AddUint64(&c.wcepoch, 1)
+				}
+			}
+			// Anyone left is busy; try again in a bit
+			wait = nil
+			retry = time.After(c.cfg.StragglerTimeout)
 		}
-		if remove_dd != nil {
-			for k, _ := range remove_dd {
-				br, _ := s.getKey(k, nil)
-				br.dd = false
-				s.dd[k] = false
-				RMoved += 1
+		atomic.StoreUint64(&c.wcepoch, 0)
+		return proxied
+	}
+	for i, w := range c.Workers {
+		wait := (<-chan struct{})(late)
+		var retry <-chan time.Time
+		for acked := false; !acked; {
+			select {
+			case x := <-c.wepoch[i]:
+				if x != e {
+					log.Fatalf("Out of alignment in epoch ack; I expected %v, got %v\n", e, x)
+				}
+				acked = true
+			case <-wait:
+			case <-retry:
+			}
+			if !acked && c.proxyMerge(w, e) {
+				proxied[i] = true
+				acked = true
+			} else if !acked {
+				// Busy; try again in a bit
+				wait = nil
+				retry = time.After(c.cfg.StragglerTimeout)
 			}
 		}
 	}
+	return proxied
+}
 
-	sx = time.Now()
-	for i := 0; i < c.n; i++ {
-		c.wgo[i] <- next_epoch
+// Do w's merge for epoch e if it isn't running a transaction.  Taking
+// its lock hands me its LocalStore and stashed transactions; it gets
+// them back in IncrementEpoch() once the phase change is over, at
+// epoch e.
+func (c *Coordinator) proxyMerge(w *Worker, e TID) bool {
+	if !w.TryLock() {
+		return false
+	}
+	if w.epoch >= e {
+		// Shouldn't happen, but don't merge twice
+		w.Unlock()
+		return false
+	}
+	tt := time.Since(c.StartTime)
+	w.Nnoticed += tt
+	w.Noticed.AddOne(tt.Nanoseconds() / 1000)
+	w.Ncounters[NPROXIED]++
+	w.E.SetPhase(MERGE)
+	w.local_store.Merge()
+	if w.wal != nil {
+		w.wal.Merged()
+		w.flushLog(e)
+	}
+	return true
+}
+
+// Run the stashed transactions of the workers I merged for.
+func (c *Coordinator) proxyJoin(proxied []bool) {
+	for i, w := range c.Workers {
+		if !proxied[i] {
+			continue
+		}
+		w.E.SetPhase(JOIN)
+		w.joinPhase()
+		w.E.SetPhase(SPLIT)
+		if c.cfg.SpinBarrier {
+			atomic.AddUint64(&c.wcdone, 1)
+		}
+	}
+}
+
+// Per-worker notice latency, in microseconds, summed over the workers.
+func (c *Coordinator) Noticed() *stats.LatencyHist {
+	h := stats.MakeLatencyHistogram(NOTICE_US, NOTICE_BUCKETS)
+	for _, w := range c.Workers {
+		h.Combine(w.Noticed)
+	}
+	return h
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait for the barrier at x to reach v.  Spins a while before
+// yielding, so a worker waiting on the coordinator doesn't starve it
+// with few cores.
+func spinUntil(x *uint64, v uint64) {
+	for i := 0; atomic.LoadUint64(x) != v; i++ {
+		if i >= 1000 {
+			runtime.Gosched()
+		}
 	}
-	c.GoTime += time.Since(sx)
-	c.TotalCoordTime += time.Since(start1)
 }
 
+// Stop the workers.  From now on One() fails with ESHUTDOWN; the
+// transactions already running finish, the workers reconcile one last
+// time, and every stashed transaction runs and replies on its
+// Query.W.  Returns once every goroutine the coordinator started has
+// exited, or with ctx.Err() if ctx is done first; shutting down
+// carries on regardless.  Safe to call more than once, concurrently.
+func (c *Coordinator) Shutdown(ctx context.Context) error {
+	c.stopping.Do(func() {
+		dlog.Printf("Coordinator shutting down\n")
+		close(c.closed)
+		go func() {
+			x := make(chan bool)
+			c.Done <- x
+			<-x
+			c.wg.Wait()
+			close(c.stopped)
+		}()
+	})
+	select {
+	case <-c.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown() and wait however long it takes.
 func (c *Coordinator) Finish() {
-	dlog.Printf("Coordinator finishing\n")
-	x := make(chan bool)
-	c.Done <- x
-	<-x
+	c.Shutdown(context.Background())
+}
+
+func (c *Coordinator) closing() bool {
+	return isClosed(c.closed)
+}
+
+type ckptRequest struct {
+	w    io.Writer
+	done chan error
+}
+
+// Write a transaction-consistent checkpoint of the store to w.  With
+// Doppel phases it is taken at the barrier after every worker has
+// merged; otherwise the workers are paused.  Either way, they only
+// wait while each worker's redo log is rotated, so that
+// RecoverCheckpoint() knows which segments come after the checkpoint.
+// The records are written from a snapshot afterwards, while
+// transactions run.  One checkpoint is written at a time.
+func (c *Coordinator) Checkpoint(w io.Writer) error {
+	r := &ckptRequest{w: w, done: make(chan error, 1)}
+	select {
+	case c.checkpoint <- r:
+	case <-c.closed:
+		return ESHUTDOWN
+	}
+	return <-r.done
+}
+
+// Start a checkpoint at epoch e.  The workers are paused, the store
+// holds exactly the transactions before e, and a snapshot generation
+// just started.  Pin it and write it out in the background.
+func (c *Coordinator) startCheckpoint(r *ckptRequest, e TID) {
+	hdr := ckptHeader{Epoch: e, Segments: make([]int, c.n)}
+	for i := 0; i < c.n; i++ {
+		hdr.Segments[i] = -1
+		if c.Workers[i].wal == nil {
+			continue
+		}
+		seg, err := c.Workers[i].wal.Rotate(e)
+		if err != nil {
+			log.Fatalf("Worker %v could not rotate log: %v\n", i, err)
+		}
+		hdr.Segments[i] = seg
+	}
+	s := c.Workers[0].store
+	gen := s.pinSnapshot()
+	done := make(chan struct{})
+	c.ckptDone = done
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		err := s.checkpoint(r.w, hdr, gen)
+		s.unpinSnapshot()
+		close(done)
+		r.done <- err
+	}()
+}
+
+// Move the paused workers to the next epoch without a phase change,
+// writing out their logs up to it.  Returns the new epoch.
+func (c *Coordinator) pausedEpoch() TID {
+	e := c.NextGlobalTID()
+	for _, w := range c.Workers {
+		if w.wal != nil {
+			w.flushLog(e)
+		}
+		w.epoch = e
+	}
+	return e
+}
+
+// Housekeeping while the workers are paused.
+func (c *Coordinator) pause() {
+	// With split records the store is only consistent at the merge
+	// barrier.
+	s := c.Workers[0].store
+	if !(c.cfg.SysType == DOPPEL && c.n > 1 && (s.any_dd || c.cfg.AlwaysSplit)) {
+		s.nextSnapshot()
+	}
+	c.collect()
+}
+
+// Remove the keys the workers deleted from the store.  Caller makes
+// sure no worker is running a transaction.
+func (c *Coordinator) collect() {
+	s := c.Workers[0].store
+	if s.snapshotPinned() {
+		// The checkpoint still needs to see what they held
+		return
+	}
+	for _, w := range c.Workers {
+		if len(w.deleted) == 0 {
+			continue
+		}
+		w.deleted = s.removeDeleted(w.deleted)
+	}
 }
 
 var Nfast int64
 
 func (c *Coordinator) Process() {
-	tm := time.NewTicker(time.Duration(*PhaseLength) * time.Millisecond).C
+	defer c.wg.Done()
+	length := c.PhaseLength()
+	phase := time.NewTicker(length)
+	defer phase.Stop()
+	tm := phase.C
 
 	// More frequently, check if the workers are demanding a phase
 	// change due to long stashed queue lengths.
-	check_trigger := time.NewTicker(time.Duration(*PhaseLength) * time.Microsecond * 10).C
+	trigger := time.NewTicker(c.cfg.shortestPhase() / 100)
+	defer trigger.Stop()
+	check_trigger := trigger.C
+	collect := time.NewTicker(c.cfg.GCInterval)
+	defer collect.Stop()
+	gc := collect.C
+	ckpts := c.checkpoint
 
 	for {
 		select {
 		case x := <-c.Done:
-			if *SysType == DOPPEL && c.n > 1 && c.Workers[0].store.any_dd {
+			// Reconcile even if nothing looks split; stashed
+			// transactions only run at a phase change.
+			if c.cfg.SysType == DOPPEL && c.n > 1 {
 				c.IncrementEpoch(true)
 			}
+			for i := 0; i < c.n; i++ {
+				c.Workers[i].drain()
+			}
 			for i := 0; i < c.n; i++ {
 				c.Workers[i].done <- true
+				c.Workers[i].closeLog()
 			}
 			x <- true
 			return
 		case <-tm:
-			if *SysType == DOPPEL && c.n > 1 {
-				c.IncrementEpoch(false)
+			// With logging, close the epoch even if nothing is
+			// split, so that the logs reach disk.
+			if c.cfg.SysType == DOPPEL && c.n > 1 {
+				c.IncrementEpoch(c.cfg.LogDir != "")
+			} else if c.cfg.LogDir != "" {
+				for i := 0; i < c.n; i++ {
+					c.Workers[i].Lock()
+				}
+				c.pausedEpoch()
+				for i := 0; i < c.n; i++ {
+					c.Workers[i].Unlock()
+				}
 			}
 		case <-check_trigger:
-			if *SysType == DOPPEL && c.n > 1 {
+			if c.cfg.SysType == DOPPEL && c.n > 1 {
 				x := atomic.LoadInt32(&c.trigger)
 				if x == int32(c.n) {
 					Nfast++
@@ -314,12 +653,43 @@ func (c *Coordinator) Process() {
 					c.IncrementEpoch(true)
 				}
 			}
+		case r := <-ckpts:
+			ckpts = nil
+			if c.cfg.SysType == DOPPEL && c.n > 1 {
+				c.ckpt = r
+				c.IncrementEpoch(true)
+			} else {
+				for i := 0; i < c.n; i++ {
+					c.Workers[i].Lock()
+				}
+				e := c.pausedEpoch()
+				c.Workers[0].store.nextSnapshot()
+				c.startCheckpoint(r, e)
+				for i := 0; i < c.n; i++ {
+					c.Workers[i].Unlock()
+				}
+			}
+		case <-c.ckptDone:
+			c.ckptDone = nil
+			ckpts = c.checkpoint
+		case <-gc:
+			for i := 0; i < c.n; i++ {
+				c.Workers[i].Lock()
+			}
+			c.pause()
+			for i := 0; i < c.n; i++ {
+				c.Workers[i].Unlock()
+			}
 		case <-c.Accelerate:
-			if *SysType == DOPPEL && c.n > 1 {
+			if c.cfg.SysType == DOPPEL && c.n > 1 {
 				dlog.Printf("Accelerating\n")
 				c.IncrementEpoch(true)
 			}
 		}
+		if l := c.PhaseLength(); l != length {
+			length = l
+			phase.Reset(l)
+		}
 	}
 }
 
@@ -373,7 +743,7 @@ func compute(w *Worker, txn int) (int64, int64) {
 }
 
 func (c *Coordinator) Latency() (string, string) {
-	if !*Latency {
+	if !c.cfg.Latency {
 		return "", ""
 	}
 	for i := 1; i < c.n; i++ {
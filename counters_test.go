@@ -0,0 +1,117 @@
+package ddtxn
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestWideCounters(t *testing.T) {
+	s := NewStore(noGC())
+	s.CreateKey(ProductKey(1), int64(math.MaxInt32), SUM64)
+	s.CreateKey(ProductKey(2), float64(0.5), FSUM)
+	s.CreateKey(ProductKey(3), int32(math.MaxInt32-1), SUM)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+	tx := StartOTransaction(c.Workers[0])
+
+	tx.Reset()
+	tx.WriteInt64(ProductKey(1), math.MaxInt32, SUM64)
+	tx.WriteFloat64(ProductKey(2), 0.25, FSUM)
+	tx.WriteFloat64(ProductKey(4), 1.5, FMAX)
+	if tx.Commit() == 0 {
+		t.Fatalf("Aborted\n")
+	}
+	for k, want := range map[Key]Value{ProductKey(1): int64(2 * math.MaxInt32), ProductKey(2): 0.75, ProductKey(4): 1.5} {
+		if br, err := s.Get(k); err != nil || br.Value() != want {
+			t.Errorf("Wrong value for %v: %v %v\n", k, br.Value(), err)
+		}
+	}
+
+	tx.Reset()
+	if err := tx.WriteInt32(ProductKey(3), 2, SUM); err != EOVERFLOW {
+		t.Errorf("Expected EOVERFLOW, got %v\n", err)
+	}
+	br, _ := s.Get(ProductKey(3))
+	if err := s.SetInt32(br, 2, SUM); err != EOVERFLOW || br.Value() != int32(math.MaxInt32-1) {
+		t.Errorf("Overflowed %v %v\n", err, br.Value())
+	}
+
+	var buf bytes.Buffer
+	if err := s.Checkpoint(&buf); err != nil {
+		t.Fatalf("Checkpoint %v\n", err)
+	}
+	s2, err := LoadCheckpoint(&buf, s.Config())
+	if err != nil {
+		t.Fatalf("Load %v\n", err)
+	}
+	if br, err := s2.Get(ProductKey(2)); err != nil || br.Value() != 0.75 {
+		t.Errorf("Wrong value after checkpoint %v %v\n", br, err)
+	}
+
+	if *SysType != DOPPEL {
+		return
+	}
+	// Split sums saturate at the merge
+	s.cfg.AlwaysSplit = true
+	tx.SetPhase(SPLIT)
+	for i := 0; i < 3; i++ {
+		tx.Reset()
+		tx.WriteInt64(ProductKey(1), math.MaxInt64/2, SUM64)
+		tx.WriteFloat64(ProductKey(2), 1, FSUM)
+		if tx.Commit() == 0 {
+			t.Fatalf("Aborted\n")
+		}
+	}
+	tx.ls.Merge()
+	if br, _ := s.Get(ProductKey(1)); br.Value() != int64(math.MaxInt64) {
+		t.Errorf("Didn't saturate %v\n", br.Value())
+	}
+	if br, _ := s.Get(ProductKey(2)); br.Value() != 3.75 {
+		t.Errorf("Wrong value after merge %v\n", br.Value())
+	}
+}
+
+// A sum which fit when written but not once another commits aborts
+// with EOVERFLOW, which One() returns instead of retrying.
+func TestCommitOverflow(t *testing.T) {
+	for _, sys := range []int{OCC, SSI} {
+		s := NewStore(noGC())
+		s.CreateKey(ProductKey(1), int32(math.MaxInt32-1), SUM)
+		c := NewCoordinator(1, s, BuiltinRegistry())
+		w := c.Workers[0]
+		var tx1, tx2 ETransaction
+		if sys == OCC {
+			tx1, tx2 = StartOTransaction(w), StartOTransaction(w)
+		} else {
+			tx1, tx2 = StartSTransaction(w), StartSTransaction(w)
+		}
+		tx1.Reset()
+		tx2.Reset()
+		if err := tx1.WriteInt32(ProductKey(1), 1, SUM); err != nil {
+			t.Fatalf("%v: Write %v\n", sys, err)
+		}
+		if err := tx2.WriteInt32(ProductKey(1), 1, SUM); err != nil {
+			t.Fatalf("%v: Write %v\n", sys, err)
+		}
+		if tx2.Commit() == 0 {
+			t.Fatalf("%v: Aborted\n", sys)
+		}
+		nv := w.Ncounters[NFAIL_VERIFY]
+		if tx1.Commit() != 0 {
+			t.Errorf("%v: Overflowed\n", sys)
+		}
+		if w.Ncounters[NFAIL_VERIFY] != nv+1 {
+			t.Errorf("%v: Didn't count the overflow\n", sys)
+		}
+		if err := tx1.(interface {
+			commitError() error
+		}).commitError(); err != EOVERFLOW {
+			t.Errorf("%v: Expected EOVERFLOW, got %v\n", sys, err)
+		}
+		if br, _ := s.Get(ProductKey(1)); br.Value() != int32(math.MaxInt32) {
+			t.Errorf("%v: Wrong value %v\n", sys, br.Value())
+		}
+		c.Finish()
+	}
+}
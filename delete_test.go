@@ -0,0 +1,208 @@
+package ddtxn
+
+import (
+	"testing"
+	"time"
+)
+
+// Like the coordinator's periodic pause.
+func pause(c *Coordinator) {
+	for _, w := range c.Workers {
+		w.Lock()
+	}
+	c.pause()
+	for _, w := range c.Workers {
+		w.Unlock()
+	}
+}
+
+// Tests which run transactions themselves, outside the workers, can't
+// have the coordinator pausing them.
+func noGC() Config {
+	cfg := FlagConfig()
+	cfg.GCInterval = time.Hour
+	return cfg
+}
+
+func TestDelete(t *testing.T) {
+	s := NewStore(noGC())
+	s.CreateKey(ProductKey(1), int32(5), SUM)
+	s.CreateKey(ProductKey(2), int32(5), SUM)
+	c := NewCoordinator(2, s, BuiltinRegistry())
+	defer c.Finish()
+	tx1 := StartOTransaction(c.Workers[0])
+	tx2 := StartOTransaction(c.Workers[1])
+
+	// Concurrent reader aborts
+	tx1.Reset()
+	if _, err := tx1.Read(ProductKey(1)); err != nil {
+		t.Fatalf("Read %v\n", err)
+	}
+	tx1.WriteInt32(ProductKey(2), 1, SUM)
+	tx2.Reset()
+	if err := tx2.Delete(ProductKey(1)); err != nil {
+		t.Fatalf("Delete %v\n", err)
+	}
+	if _, err := tx2.Read(ProductKey(1)); err != ENOKEY {
+		t.Errorf("Read my own delete %v\n", err)
+	}
+	if tx2.Commit() == 0 {
+		t.Fatalf("Delete aborted\n")
+	}
+	if tx1.Commit() != 0 {
+		t.Errorf("Read of deleted key committed\n")
+	}
+	if _, err := s.Get(ProductKey(1)); err != ENOKEY {
+		t.Errorf("Expected ENOKEY after delete, got %v\n", err)
+	}
+	tx1.Reset()
+	if _, err := tx1.Read(ProductKey(1)); err != ENOKEY {
+		t.Errorf("Expected ENOKEY in transaction, got %v\n", err)
+	}
+	if err := tx1.Delete(ProductKey(1)); err != ENOKEY {
+		t.Errorf("Expected ENOKEY deleting twice, got %v\n", err)
+	}
+
+	// Collect the tombstone
+	pause(c)
+	if _, err := s.getKey(ProductKey(1), nil); err != ENOKEY {
+		t.Errorf("Tombstone not collected\n")
+	}
+
+	// Writing it again starts over
+	tx1.Reset()
+	tx1.WriteInt32(ProductKey(1), 3, SUM)
+	if tx1.Commit() == 0 {
+		t.Fatalf("Recreate aborted\n")
+	}
+	br, err := s.Get(ProductKey(1))
+	if err != nil || br.Value().(int32) != 3 {
+		t.Errorf("Wrong value after recreating %v %v\n", br, err)
+	}
+
+	// Deleting something I wrote means I don't create it
+	tx1.Reset()
+	tx1.WriteInt32(ProductKey(3), 3, SUM)
+	if err := tx1.Delete(ProductKey(3)); err != nil {
+		t.Errorf("Delete %v\n", err)
+	}
+	if tx1.Commit() == 0 {
+		t.Fatalf("Aborted\n")
+	}
+	if _, err := s.getKey(ProductKey(3), nil); err != ENOKEY {
+		t.Errorf("Created a deleted key\n")
+	}
+}
+
+func TestDeleteSplit(t *testing.T) {
+	if *SysType != DOPPEL {
+		t.Skip("Splitting only happens in Doppel")
+	}
+	cfg := noGC()
+	cfg.AlwaysSplit = true
+	s := NewStore(cfg)
+	s.CreateKey(ProductKey(1), int32(5), SUM)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+	tx := StartOTransaction(c.Workers[0])
+	tx.Reset()
+	tx.SetPhase(SPLIT)
+	if err := tx.Delete(ProductKey(1)); err != ESTASH {
+		t.Errorf("Expected ESTASH deleting split key, got %v\n", err)
+	}
+	tx.Reset()
+	tx.SetPhase(JOIN)
+	if err := tx.Delete(ProductKey(1)); err != nil {
+		t.Fatalf("Delete in join phase %v\n", err)
+	}
+	if tx.Commit() == 0 {
+		t.Fatalf("Aborted\n")
+	}
+	if _, err := s.Get(ProductKey(1)); err != ENOKEY {
+		t.Errorf("Expected ENOKEY after delete, got %v\n", err)
+	}
+}
+
+// A split key deleted in the join phase stays deleted through the
+// next merges unless it's written again.
+func TestDeleteSplitMerge(t *testing.T) {
+	if *SysType != DOPPEL {
+		t.Skip("Splitting only happens in Doppel")
+	}
+	cfg := noGC()
+	cfg.AlwaysSplit = true
+	s := NewStore(cfg)
+	m, v := ProductKey(1), ProductKey(2)
+	s.CreateKey(m, int32(0), MAX)
+	s.CreateKey(v, "", WRITE)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+	tx := StartOTransaction(c.Workers[0])
+	tx.Reset()
+	tx.SetPhase(SPLIT)
+	tx.WriteInt32(m, 7, MAX)
+	tx.Write(v, "x", WRITE)
+	if tx.Commit() == 0 {
+		t.Fatalf("Aborted\n")
+	}
+	tx.ls.Merge()
+
+	tx.Reset()
+	tx.SetPhase(JOIN)
+	tx.Delete(m)
+	tx.Delete(v)
+	if tx.Commit() == 0 {
+		t.Fatalf("Delete aborted\n")
+	}
+	for i := 0; i < 2; i++ {
+		tx.ls.Merge()
+		for _, k := range []Key{m, v} {
+			if _, err := s.Get(k); err != ENOKEY {
+				t.Errorf("%v: Expected ENOKEY for %v, got %v\n", i, k, err)
+			}
+		}
+	}
+
+	tx.Reset()
+	tx.SetPhase(SPLIT)
+	tx.WriteInt32(m, 3, MAX)
+	if tx.Commit() == 0 {
+		t.Fatalf("Aborted\n")
+	}
+	tx.ls.Merge()
+	if br, err := s.Get(m); err != nil || br.Value() != int32(3) {
+		t.Errorf("Wrong value after writing again %v %v\n", br, err)
+	}
+}
+
+func TestDeleteLocking(t *testing.T) {
+	cfg := noGC()
+	cfg.SysType = LOCKING
+	s := NewStore(cfg)
+	s.CreateKey(ProductKey(1), int32(5), SUM)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+	tx := StartLTransaction(c.Workers[0])
+	tx.Reset()
+	if err := tx.Delete(ProductKey(1)); err != nil {
+		t.Fatalf("Delete %v\n", err)
+	}
+	if _, err := tx.Read(ProductKey(1)); err != ENOKEY {
+		t.Errorf("Read my own delete %v\n", err)
+	}
+	tx.Commit()
+	tx.Reset()
+	if _, err := tx.Read(ProductKey(1)); err != ENOKEY {
+		t.Errorf("Expected ENOKEY, got %v\n", err)
+	}
+	tx.Commit()
+	tx.Reset()
+	if err := tx.Delete(ProductKey(2)); err != ENOKEY {
+		t.Errorf("Expected ENOKEY, got %v\n", err)
+	}
+	tx.Commit()
+	pause(c)
+	if _, err := s.getKey(ProductKey(1), nil); err != ENOKEY {
+		t.Errorf("Tombstone not collected\n")
+	}
+}
@@ -0,0 +1,51 @@
+package ddtxn
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of TxnError.  Check for them with errors.Is().
+var (
+	// A write of one KeyType to a record of another, or to a key
+	// the transaction already wrote as another.
+	ErrTypeMismatch = errors.New("doppel: key type mismatch")
+	// A transaction used the API in a way it doesn't support, like
+	// writing a key it read under 2PL without MaybeWrite().
+	ErrMisuse = errors.New("doppel: unsupported use of transaction")
+	// Something that shouldn't happen did, like a TransactionFunc
+	// panicking.  The transaction was aborted.
+	ErrInternal = errors.New("doppel: internal error")
+)
+
+// What went wrong with a transaction, in a way retrying won't fix.
+// Returned by ETransaction methods and Worker.One() instead of killing
+// the process.
+type TxnError struct {
+	Kind error // ErrTypeMismatch, ErrMisuse or ErrInternal
+	Key  Key   // The key involved, if any
+	Msg  string
+}
+
+func (e *TxnError) Error() string {
+	if e.Key == (Key{}) {
+		return fmt.Sprintf("%v: %v", e.Kind, e.Msg)
+	}
+	return fmt.Sprintf("%v: %v: %v", e.Kind, e.Key, e.Msg)
+}
+
+func (e *TxnError) Unwrap() error {
+	return e.Kind
+}
+
+func typeMismatch(k Key, op, was KeyType) error {
+	return &TxnError{Kind: ErrTypeMismatch, Key: k, Msg: fmt.Sprintf("write of type %v to %v", op, was)}
+}
+
+func misuse(k Key, msg string) error {
+	return &TxnError{Kind: ErrMisuse, Key: k, Msg: msg}
+}
+
+func internalError(k Key, format string, args ...interface{}) error {
+	return &TxnError{Kind: ErrInternal, Key: k, Msg: fmt.Sprintf(format, args...)}
+}
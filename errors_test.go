@@ -0,0 +1,178 @@
+package ddtxn
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTypeMismatch(t *testing.T) {
+	starts := map[string]func(*Worker) ETransaction{
+		"occ": func(w *Worker) ETransaction { return StartOTransaction(w) },
+		"2pl": func(w *Worker) ETransaction { return StartLTransaction(w) },
+		"ssi": func(w *Worker) ETransaction { return StartSTransaction(w) },
+	}
+	for name, start := range starts {
+		s := NewStore(noGC())
+		s.CreateKey(ProductKey(1), int32(5), SUM)
+		c := NewCoordinator(1, s, BuiltinRegistry())
+		tx := start(c.Workers[0])
+		tx.Reset()
+		if err := tx.WriteInt32(ProductKey(1), 1, SUM); err != nil {
+			t.Fatalf("%v: Write %v\n", name, err)
+		}
+		err := tx.Write(ProductKey(1), "x", WRITE)
+		if !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("%v: Expected ErrTypeMismatch, got %v\n", name, err)
+		}
+		var te *TxnError
+		if !errors.As(err, &te) || te.Key != ProductKey(1) {
+			t.Errorf("%v: Expected a TxnError for the key, got %v\n", name, err)
+		}
+		if err := tx.WriteList(ProductKey(2), NewEntry(1, ProductKey(3), nil), SUM); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("%v: Expected ErrTypeMismatch from WriteList, got %v\n", name, err)
+		}
+		// The first write still commits
+		if tx.Commit() == 0 {
+			t.Fatalf("%v: Aborted\n", name)
+		}
+		if br, _ := s.Get(ProductKey(1)); br.Value() != int32(6) {
+			t.Errorf("%v: Wrong value %v\n", name, br.Value())
+		}
+		c.Finish()
+	}
+}
+
+func TestTypeMismatchSplit(t *testing.T) {
+	if *SysType != DOPPEL {
+		t.Skip("Splitting only happens in Doppel")
+	}
+	cfg := noGC()
+	cfg.AlwaysSplit = true
+	s := NewStore(cfg)
+	s.CreateKey(ProductKey(1), int32(5), SUM)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+	tx := StartOTransaction(c.Workers[0])
+	tx.Reset()
+	tx.SetPhase(SPLIT)
+	if err := tx.WriteInt32(ProductKey(1), 1, MAX); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected ErrTypeMismatch, got %v\n", err)
+	}
+}
+
+func TestUpgradeLocking(t *testing.T) {
+	s := NewStore(noGC())
+	s.CreateKey(ProductKey(1), int32(5), SUM)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+	tx := StartLTransaction(c.Workers[0])
+	tx.Reset()
+	if _, err := tx.Read(ProductKey(1)); err != nil {
+		t.Fatalf("Read %v\n", err)
+	}
+	if err := tx.WriteInt32(ProductKey(1), 1, SUM); !errors.Is(err, ErrMisuse) {
+		t.Errorf("Expected ErrMisuse, got %v\n", err)
+	}
+	tx.Abort()
+	// Abort released the read lock
+	tx.Reset()
+	tx.MaybeWrite(ProductKey(1))
+	if err := tx.WriteInt32(ProductKey(1), 1, SUM); err != nil {
+		t.Fatalf("Write after MaybeWrite %v\n", err)
+	}
+	if tx.Commit() == 0 {
+		t.Fatalf("Aborted\n")
+	}
+}
+
+func TestTxnPanic(t *testing.T) {
+	for _, sys := range []int{DOPPEL, OCC, LOCKING, SSI} {
+		reg := BuiltinRegistry()
+		boom := reg.MustRegister("boom", func(q Query, tx ETransaction) (*Result, error) {
+			if err := tx.WriteInt32(ProductKey(1), 1, SUM); err != nil {
+				return nil, err
+			}
+			var m map[Key]int
+			m[ProductKey(1)]++
+			return nil, nil
+		})
+		cfg := FlagConfig()
+		cfg.SysType = sys
+		s := NewStore(cfg)
+		s.CreateKey(ProductKey(1), int32(5), SUM)
+		c := NewCoordinator(1, s, reg)
+		w := c.Workers[0]
+		if _, err := w.One(Query{TXN: boom}); !errors.Is(err, ErrInternal) {
+			t.Errorf("%v: Expected ErrInternal, got %v\n", sys, err)
+		}
+		if _, err := w.One(Query{TXN: 1000}); !errors.Is(err, ErrMisuse) {
+			t.Errorf("%v: Expected ErrMisuse, got %v\n", sys, err)
+		}
+		// Nothing is left locked
+		_, err := w.One(MicroQuery(D_BUY, ProductKey(1), ProductKey(2), 1))
+		if err != nil {
+			t.Errorf("%v: Buy after panic %v\n", sys, err)
+		}
+		if br, _ := s.Get(ProductKey(1)); br.Value() != int32(6) {
+			t.Errorf("%v: Wrong value %v\n", sys, br.Value())
+		}
+		if w.Ncounters[NINTERNAL] != 1 {
+			t.Errorf("%v: Expected one internal error, got %v\n", sys, w.Ncounters[NINTERNAL])
+		}
+		c.Finish()
+	}
+}
+
+// A worker told about the wrong epoch still finishes the phase
+// change, so nothing waits for it forever.  I play the coordinator.
+func TestMisaligned(t *testing.T) {
+	if *SysType != DOPPEL {
+		t.Skip("Phases only happen in Doppel")
+	}
+	cfg := noGC()
+	cfg.AlwaysSplit = true
+	cfg.SpinBarrier = false
+	cfg.PhaseLength = time.Hour
+	s := NewStore(cfg)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+	w := c.Workers[0]
+	w.done <- true
+	send := func(ch chan TID, x TID) {
+		select {
+		case ch <- x:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Worker stopped listening for %v\n", x)
+		}
+	}
+
+	e := c.NextGlobalTID()
+	errc := make(chan error, 1)
+	go func() { errc <- w.transition() }()
+	if x := <-c.wepoch[0]; x != e {
+		t.Fatalf("Acked %v, expected %v\n", x, e)
+	}
+	send(c.wsafe[0], e-EPOCH_INCR)
+	send(c.wsafe[0], e)
+	if x := <-c.wdone[0]; x != e {
+		t.Fatalf("Done %v, expected %v\n", x, e)
+	}
+	send(c.wgo[0], e+EPOCH_INCR)
+	send(c.wgo[0], e)
+	if err := <-errc; !errors.Is(err, ErrInternal) {
+		t.Errorf("Expected ErrInternal, got %v\n", err)
+	}
+	if w.epoch != e || w.Ncounters[NINTERNAL] != 2 {
+		t.Errorf("Wrong epoch %v or count %v\n", w.epoch, w.Ncounters[NINTERNAL])
+	}
+
+	c.wg.Add(1)
+	go w.run()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown %v\n", err)
+	}
+}
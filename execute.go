@@ -2,15 +2,22 @@ package ddtxn
 
 import (
 	"flag"
-	"log"
 	"math/rand"
 
 	"github.com/narula/dlog"
 )
 
+// Flags for FlagConfig()
 var SampleRate = flag.Int64("sr", 500, "Sample every sr transactions\n")
 var AlwaysSplit = flag.Bool("split", false, "Split every piece of data\n")
 var NoConflictType = flag.Int("noconflict", -1, "Type of operation NOT to record conflicts on")
+var MaxKeys = flag.Int("maxkeys", 100000, "Most keys a transaction can read, or write; more fail with ETOOBIG\n")
+
+// Read and write sets start with room for this many keys, and grow
+// as needed up to Config.MaxKeys.  Each worker reuses its
+// transaction, so once grown they stay grown and the common case
+// doesn't allocate.
+const TXN_KEYS = 100
 
 // Phases
 const (
@@ -19,15 +26,11 @@ const (
 	JOIN
 )
 
-// TODO: Handle writing more than once to a key in one transaction
 type WriteKey struct {
 	key    Key
 	br     *BRecord
-	v      Value
-	op     KeyType
 	locked bool
-	vint32 int32
-	ve     Entry
+	pending
 }
 
 type ReadKey struct {
@@ -36,13 +39,40 @@ type ReadKey struct {
 	last uint64
 }
 
+// A leaf of an Index an OCC transaction scanned, and its version
+// then.
+type ScanLeaf struct {
+	x       *Index
+	l       *leaf
+	version uint64
+}
+
+// Reads and writes return ETOOBIG once a transaction has touched more
+// than Config.MaxKeys keys; it can't commit after that.
 type ETransaction interface {
 	Reset()
 	Read(k Key) (*BRecord, error)
+	// Keys in [start, end) from the Index on start, in order.  At
+	// most limit keys if limit > 0.  Returns ENOINDEX if start isn't
+	// indexed.  The transaction aborts if a key is inserted into the
+	// range before it commits.
+	Scan(start, end Key, limit int) ([]Key, error)
+	// The value of k as of the last snapshot; see snapshot.go.  Never
+	// aborts or stashes, and isn't validated at commit.
+	ReadSnapshot(k Key) (Value, error)
+	// Numeric writes to SUM, MAX and their 64-bit and float64
+	// variants.  Sums return EOVERFLOW instead of overflowing; sums
+	// of split records can't tell until the merge, which saturates.
 	WriteInt32(k Key, a int32, op KeyType) error
+	WriteInt64(k Key, a int64, op KeyType) error
+	WriteFloat64(k Key, a float64, op KeyType) error
 	WriteList(k Key, l Entry, op KeyType) error
 	WriteOO(k Key, a int32, v Value, op KeyType) error
-	Write(k Key, v Value, op KeyType)
+	Write(k Key, v Value, op KeyType) error
+	// Delete k when the transaction commits.  Returns ENOKEY if it
+	// doesn't exist, and ESTASH for split keys, which can only be
+	// deleted in the join phase.
+	Delete(k Key) error
 	Abort() TID
 	Commit() TID
 	SetPhase(int)
@@ -65,19 +95,22 @@ type ETransaction interface {
 
 // Tracks execution of transaction.
 type OTransaction struct {
-	padding0    [128]byte
-	read        []ReadKey
-	w           *Worker
-	s           *Store
-	ls          *LocalStore
-	phase       int
-	writes      []WriteKey
-	maxSeen     uint64
-	t           int64 // Used just as a rough count
-	count       bool
-	sr_rate     int64
-	dummyRecord *BRecord
-	padding     [128]byte
+	padding0 [128]byte
+	read     []ReadKey
+	w        *Worker
+	s        *Store
+	ls       *LocalStore
+	phase    int
+	writes   []WriteKey
+	scans    []ScanLeaf
+	created  []Key // Keys I inserted while committing
+	maxSeen  uint64
+	t        int64 // Used just as a rough count
+	count    bool
+	sr_rate  int64
+	full     bool  // Ran out of room; can't commit
+	err      error // Why Commit() aborted, if retrying can't help
+	padding  [128]byte
 }
 
 func (tx *OTransaction) UID(f rune) uint64 {
@@ -94,42 +127,41 @@ func (tx *OTransaction) NoCount() {
 
 func StartOTransaction(w *Worker) *OTransaction {
 	tx := &OTransaction{
-		read:        make([]ReadKey, 0, 100),
-		writes:      make([]WriteKey, 0, 100),
-		w:           w,
-		s:           w.store,
-		ls:          w.local_store,
-		sr_rate:     int64(w.ID),
-		dummyRecord: &BRecord{},
+		read:    make([]ReadKey, 0, TXN_KEYS),
+		writes:  make([]WriteKey, 0, TXN_KEYS),
+		w:       w,
+		s:       w.store,
+		ls:      w.local_store,
+		sr_rate: int64(w.ID),
 	}
 	return tx
 }
 
 func (tx *OTransaction) Reset() {
+	tx.full = false
+	tx.err = nil
 	tx.read = tx.read[:0]
 	tx.writes = tx.writes[:0]
+	tx.scans = tx.scans[:0]
+	tx.created = tx.created[:0]
 	tx.t++
-	tx.count = (*SysType == DOPPEL && tx.sr_rate == 0)
+	tx.count = (tx.s.cfg.SysType == DOPPEL && tx.sr_rate == 0)
 	if tx.count {
-		tx.w.Nstats[NSAMPLES]++
-		tx.sr_rate = *SampleRate + int64(rand.Intn(100)) - int64(tx.w.ID)
+		tx.w.Ncounters[NSAMPLES]++
+		tx.sr_rate = tx.s.cfg.SampleRate + int64(rand.Intn(100)) - int64(tx.w.ID)
 	} else {
 		tx.sr_rate--
 	}
 }
 
 func (tx *OTransaction) isSplit(br *BRecord) bool {
-	if *SysType == DOPPEL {
+	if tx.s.cfg.SysType == DOPPEL {
 		if tx.phase == SPLIT {
-			if *AlwaysSplit {
+			if tx.s.cfg.AlwaysSplit {
 				return true
 			}
-			if tx.s.any_dd {
-				if br != nil {
-					if br.dd {
-						return true
-					}
-				}
+			if br != nil && br.isDD() {
+				return true
 			}
 		}
 	}
@@ -143,29 +175,29 @@ func (tx *OTransaction) Read(k Key) (*BRecord, error) {
 			if w.key == k {
 				// I wrote and read the same piece of data in one
 				// transaction.  This is a strong signal this
-				// shouldn't be dd.  Also I should return this value.
-				// But I return a pointer to a record (sigh) so use a
-				// dummy record.
-				if tx.count {
-					tx.ls.candidates.ReadWrite(k, w.br)
+				// shouldn't be dd.  Also I should return this value,
+				// so return a copy of the record with my write
+				// applied.  The record is in my read set unless I
+				// overwrote it blindly, so if it changes I abort.
+				base := w.br
+				if base == nil {
+					base, _ = tx.s.getKey(k, tx.w.ld)
+				}
+				if tx.count && base != nil {
+					tx.ls.candidates.ReadWrite(k, base)
 				}
 				if tx.isSplit(w.br) {
 					return nil, ESTASH
 				}
-				tx.dummyRecord.key_type = w.op
-				tx.dummyRecord.int_value = w.vint32
-				tx.dummyRecord.value = w.v
-				if w.op == LIST {
-					tx.dummyRecord.entries = tx.dummyRecord.entries[0 : len(w.br.entries)+1]
-					copy(tx.dummyRecord.entries, w.br.entries)
-					tx.dummyRecord.entries = append(tx.dummyRecord.entries, w.ve)
+				if w.op == DELETE {
+					return nil, ENOKEY
 				}
-				return tx.dummyRecord, nil
+				return w.record(k, base), nil
 			}
 		}
 	}
 	br, err := tx.s.getKey(k, tx.w.ld)
-	if *CountKeys {
+	if tx.s.cfg.CountKeys {
 		p, r := UndoCKey(k)
 		if r == 'm' {
 			tx.w.NKeyAccesses[p]++
@@ -174,11 +206,9 @@ func (tx *OTransaction) Read(k Key) (*BRecord, error) {
 
 	if err == ENOKEY {
 		// Can't be stashed, right?
-		n := len(tx.read)
-		tx.read = tx.read[0 : n+1]
-		tx.read[n].key = k
-		tx.read[n].br = nil
-		tx.read[n].last = 0
+		if err := tx.addRead(k, nil, 0); err != nil {
+			return nil, err
+		}
 		return nil, err
 	} else {
 		if tx.isSplit(br) {
@@ -194,30 +224,153 @@ func (tx *OTransaction) Read(k Key) (*BRecord, error) {
 		// if locked abort
 		// else note the last timestamp, save it, return value
 		if !ok {
-			tx.w.Nstats[NLOCKED]++
+			tx.w.Ncounters[NLOCKED]++
 			return nil, EABORT
 		}
-		n := len(tx.read)
-		tx.read = tx.read[0 : n+1]
-		tx.read[n].key = k
-		tx.read[n].br = br
-		tx.read[n].last = last
+		if err := tx.addRead(k, br, last); err != nil {
+			return nil, err
+		}
 		if last > tx.maxSeen {
 			tx.maxSeen = last
 		}
+		if br.deleted {
+			return nil, ENOKEY
+		}
 		return br, nil
 	}
-	log.Fatalf("What")
-	return nil, nil
+}
+
+func (tx *OTransaction) ReadSnapshot(k Key) (Value, error) {
+	return tx.s.readSnapshot(k, tx.s.SnapshotGen(), false)
+}
+
+func (tx *OTransaction) Scan(start, end Key, limit int) ([]Key, error) {
+	x := tx.s.Index(start)
+	if x == nil {
+		return nil, ENOINDEX
+	}
+	keys := x.scan(start, end, limit, func(l *leaf) {
+		tx.scans = append(tx.scans, ScanLeaf{x, l, l.version})
+	})
+	return keys, nil
+}
+
+// A scanned leaf is unchanged if the only inserts into it since were
+// mine.
+func (tx *OTransaction) verifyScans() bool {
+	for i := range tx.scans {
+		sl := &tx.scans[i]
+		expect := sl.version
+		for _, k := range tx.created {
+			if sl.x.routesTo(k, sl.l) {
+				expect++
+			}
+		}
+		if sl.x.versionOf(sl.l) != expect {
+			return false
+		}
+	}
+	return true
 }
 
 func (tx *OTransaction) WriteInt32(k Key, a int32, op KeyType) error {
+	if w := tx.pending(k); w != nil {
+		return w.write(k, false, tx.sumBase(w.br), op, a, nil, Entry{})
+	}
+	br, err := tx.readForWrite(k, op)
+	if err != nil {
+		return err
+	}
+	return tx.addWrite(k, br, op, a, nil, Entry{})
+}
+
+func (tx *OTransaction) WriteInt64(k Key, a int64, op KeyType) error {
+	if w := tx.pending(k); w != nil {
+		return w.write(k, false, tx.sumBase(w.br), op, 0, a, Entry{})
+	}
+	br, err := tx.readForWrite(k, op)
+	if err != nil {
+		return err
+	}
+	return tx.addWrite(k, br, op, 0, a, Entry{})
+}
+
+func (tx *OTransaction) WriteFloat64(k Key, a float64, op KeyType) error {
+	if w := tx.pending(k); w != nil {
+		return w.write(k, false, tx.sumBase(w.br), op, 0, a, Entry{})
+	}
+	br, err := tx.readForWrite(k, op)
+	if err != nil {
+		return err
+	}
+	return tx.addWrite(k, br, op, 0, a, Entry{})
+}
+
+// My pending write to k, or nil.
+func (tx *OTransaction) pending(k Key) *WriteKey {
+	for i := range tx.writes {
+		if tx.writes[i].key == k {
+			return &tx.writes[i]
+		}
+	}
+	return nil
+}
+
+// The record to check sums to br against: split records can't tell
+// until the merge.
+func (tx *OTransaction) sumBase(br *BRecord) *BRecord {
+	if br == nil || tx.isSplit(br) {
+		return nil
+	}
+	return br
+}
+
+// Whether I can add another key to a set with n keys.  A transaction
+// which can't is too big to commit.
+func (tx *OTransaction) room(n int) bool {
+	if n >= tx.s.cfg.MaxKeys {
+		tx.full = true
+		return false
+	}
+	return true
+}
+
+func (tx *OTransaction) addRead(k Key, br *BRecord, last uint64) error {
+	if !tx.room(len(tx.read)) {
+		return ETOOBIG
+	}
+	tx.read = append(tx.read, ReadKey{key: k, br: br, last: last})
+	return nil
+}
+
+// Add the first write to k, whose record is br (nil if I don't know
+// it yet), to my write set.
+func (tx *OTransaction) addWrite(k Key, br *BRecord, op KeyType, a int32, v Value, e Entry) error {
+	if !tx.room(len(tx.writes)) {
+		return ETOOBIG
+	}
+	n := len(tx.writes)
+	tx.writes = append(tx.writes, WriteKey{})
+	w := &tx.writes[n]
+	if err := w.write(k, true, tx.sumBase(br), op, a, v, e); err != nil {
+		tx.writes = tx.writes[:n]
+		return err
+	}
+	w.key = k
+	w.br = br
+	w.locked = false
+	return nil
+}
+
+// The record a numeric write of k goes to, or nil if k doesn't exist
+// yet.
+func (tx *OTransaction) readForWrite(k Key, op KeyType) (*BRecord, error) {
 	// During the normal phase, Doppel operates just like OCC, for
 	// ease of exposition.  That means it would have to put the key
 	// into the read set and potentially abort accordingly.  Doing so
 	// here, but not using the value until commit time.
 	br, err := tx.s.getKey(k, tx.w.ld)
-	if *CountKeys {
+	if tx.s.cfg.CountKeys {
 		p, r := UndoCKey(k)
 		if r == 'm' {
 			tx.w.NKeyAccesses[p]++
@@ -225,12 +378,69 @@ func (tx *OTransaction) WriteInt32(k Key, a int32, op KeyType) error {
 	}
 	if tx.isSplit(br) {
 		if tx.count {
-			tx.ls.candidates.Write(k, br, op)
+			if err := tx.ls.candidates.Write(k, br, op); err != nil {
+				return nil, err
+			}
 		}
 		if br.key_type != op {
-			log.Fatalf("%v Doing a write to a non-%v type: %v", k, op, br.key_type)
+			return nil, typeMismatch(k, op, br.key_type)
 		}
 		// Do not need to read-validate
+		return br, nil
+	}
+	var last uint64
+	if br == nil || err == ENOKEY {
+		br = nil
+		last = 0
+	} else {
+		var ok bool
+		ok, last = br.IsUnlocked()
+		if !ok {
+			tx.w.Ncounters[NLOCKED]++
+			if tx.count && KeyType(tx.s.cfg.NoConflictType) != op {
+				if err := tx.ls.candidates.Conflict(k, br, op); err != nil {
+					return nil, err
+				}
+			}
+			return nil, EABORT
+		}
+	}
+	// Note the last timestamp and save it
+	if err := tx.addRead(k, br, last); err != nil {
+		return nil, err
+	}
+	if last > tx.maxSeen {
+		tx.maxSeen = last
+	}
+	return br, nil
+}
+
+func (tx *OTransaction) Write(k Key, v Value, op KeyType) error {
+	if op == SUM || op == MAX {
+		return tx.WriteInt32(k, v.(int32), op)
+	}
+	if w := tx.pending(k); w != nil {
+		return w.write(k, false, nil, op, 0, v, Entry{})
+	}
+	if mergeOp(op) != nil {
+		return tx.writeMerge(k, v, op)
+	}
+	return tx.addWrite(k, nil, op, 0, v, Entry{})
+}
+
+// Like WriteInt32(), so registered MergeOps can be split.  A locked
+// record aborts me at commit.
+func (tx *OTransaction) writeMerge(k Key, v Value, op KeyType) error {
+	br, err := tx.s.getKey(k, tx.w.ld)
+	if tx.isSplit(br) {
+		if tx.count {
+			if err := tx.ls.candidates.Write(k, br, op); err != nil {
+				return err
+			}
+		}
+		if br.key_type != op {
+			return typeMismatch(k, op, br.key_type)
+		}
 	} else {
 		var last uint64
 		if br == nil || err == ENOKEY {
@@ -239,52 +449,29 @@ func (tx *OTransaction) WriteInt32(k Key, a int32, op KeyType) error {
 			var ok bool
 			ok, last = br.IsUnlocked()
 			if !ok {
-				tx.w.Nstats[NLOCKED]++
-				if tx.count && KeyType(*NoConflictType) != op {
-					tx.ls.candidates.Conflict(k, br, op)
+				tx.w.Ncounters[NLOCKED]++
+				if tx.count && KeyType(tx.s.cfg.NoConflictType) != op {
+					if err := tx.ls.candidates.Conflict(k, br, op); err != nil {
+						return err
+					}
 				}
-				return EABORT
+			} else if last > tx.maxSeen {
+				tx.maxSeen = last
 			}
 		}
-		// Note the last timestamp and save it
-		n := len(tx.read)
-		tx.read = tx.read[0 : n+1]
-		tx.read[n].key = k
-		tx.read[n].br = br
-		tx.read[n].last = last
-		if last > tx.maxSeen {
-			tx.maxSeen = last
+		if err := tx.addRead(k, br, last); err != nil {
+			return err
 		}
 	}
-	n := len(tx.writes)
-	tx.writes = tx.writes[0 : n+1]
-	tx.writes[n].key = k
-	tx.writes[n].br = br
-	tx.writes[n].vint32 = a
-	tx.writes[n].op = op
-	tx.writes[n].locked = false
-	return nil
-}
-
-func (tx *OTransaction) Write(k Key, v Value, op KeyType) {
-	if len(tx.writes) == cap(tx.writes) {
-		log.Fatalf("Ran out of room\n")
-	}
-	n := len(tx.writes)
-	tx.writes = tx.writes[0 : n+1]
-	tx.writes[n].key = k
-	tx.writes[n].br = nil
-	tx.writes[n].v = v
-	tx.writes[n].op = op
-	tx.writes[n].locked = false
+	return tx.addWrite(k, br, op, 0, v, Entry{})
 }
 
 func (tx *OTransaction) WriteList(k Key, l Entry, op KeyType) error {
 	if op != LIST {
-		log.Fatalf("Not a list\n")
+		return typeMismatch(k, op, LIST)
 	}
-	if len(tx.writes) == cap(tx.writes) {
-		log.Fatalf("Ran out of room\n")
+	if w := tx.pending(k); w != nil {
+		return w.write(k, false, nil, op, 0, nil, l)
 	}
 
 	// During the normal phase, Doppel operates just like OCC, for
@@ -292,7 +479,7 @@ func (tx *OTransaction) WriteList(k Key, l Entry, op KeyType) error {
 	// into the read set and potentially abort accordingly.  Doing so
 	// here, but not using the value until commit time.
 	br, err := tx.s.getKey(k, tx.w.ld)
-	if *CountKeys {
+	if tx.s.cfg.CountKeys {
 		p, r := UndoCKey(k)
 		if r == 'm' {
 			tx.w.NKeyAccesses[p]++
@@ -300,10 +487,12 @@ func (tx *OTransaction) WriteList(k Key, l Entry, op KeyType) error {
 	}
 	if tx.isSplit(br) {
 		if tx.count {
-			tx.ls.candidates.Write(k, br, op)
+			if err := tx.ls.candidates.Write(k, br, op); err != nil {
+				return err
+			}
 		}
 		if br.key_type != LIST {
-			log.Fatalf("%v Doing a write to a non-LIST type: %v", k, br.key_type)
+			return typeMismatch(k, op, br.key_type)
 		}
 		// Do not need to read-validate
 	} else {
@@ -314,40 +503,32 @@ func (tx *OTransaction) WriteList(k Key, l Entry, op KeyType) error {
 			var ok bool
 			ok, last = br.IsUnlocked()
 			if !ok {
-				tx.w.Nstats[NLOCKED]++
-				if tx.count && KeyType(*NoConflictType) != LIST {
-					tx.ls.candidates.Conflict(k, br, LIST)
+				tx.w.Ncounters[NLOCKED]++
+				if tx.count && KeyType(tx.s.cfg.NoConflictType) != LIST {
+					if err := tx.ls.candidates.Conflict(k, br, LIST); err != nil {
+						return err
+					}
 				}
 				return EABORT
 			}
 		}
 		// Note the last timestamp and save it
-		n := len(tx.read)
-		tx.read = tx.read[0 : n+1]
-		tx.read[n].key = k
-		tx.read[n].br = br
-		tx.read[n].last = last
+		if err := tx.addRead(k, br, last); err != nil {
+			return err
+		}
 		if last > tx.maxSeen {
 			tx.maxSeen = last
 		}
 	}
-
-	n := len(tx.writes)
-	tx.writes = tx.writes[0 : n+1]
-	tx.writes[n].key = k
-	tx.writes[n].br = br
-	tx.writes[n].ve = l
-	tx.writes[n].op = op
-	tx.writes[n].locked = false
-	return nil
+	return tx.addWrite(k, br, op, 0, nil, l)
 }
 
 func (tx *OTransaction) WriteOO(k Key, a int32, v Value, op KeyType) error {
 	if op != OOWRITE {
-		log.Fatalf("Not an OOWRITE\n")
+		return typeMismatch(k, op, OOWRITE)
 	}
-	if len(tx.writes) == cap(tx.writes) {
-		log.Fatalf("Ran out of room\n")
+	if w := tx.pending(k); w != nil {
+		return w.write(k, false, nil, op, a, v, Entry{})
 	}
 
 	// During the normal phase, Doppel operates just like OCC, for
@@ -355,7 +536,7 @@ func (tx *OTransaction) WriteOO(k Key, a int32, v Value, op KeyType) error {
 	// into the read set and potentially abort accordingly.  Doing so
 	// here, but not using the value until commit time.
 	br, err := tx.s.getKey(k, tx.w.ld)
-	if *CountKeys {
+	if tx.s.cfg.CountKeys {
 		p, r := UndoCKey(k)
 		if r == 'm' {
 			tx.w.NKeyAccesses[p]++
@@ -363,10 +544,12 @@ func (tx *OTransaction) WriteOO(k Key, a int32, v Value, op KeyType) error {
 	}
 	if tx.isSplit(br) {
 		if tx.count {
-			tx.ls.candidates.Write(k, br, op)
+			if err := tx.ls.candidates.Write(k, br, op); err != nil {
+				return err
+			}
 		}
 		if br.key_type != OOWRITE {
-			log.Fatalf("%v Doing a write to a non-OOWRITE type: %v", k, br.key_type)
+			return typeMismatch(k, op, br.key_type)
 		}
 		// Do not need to read-validate
 	} else {
@@ -377,33 +560,48 @@ func (tx *OTransaction) WriteOO(k Key, a int32, v Value, op KeyType) error {
 			var ok bool
 			ok, last = br.IsUnlocked()
 			if !ok {
-				tx.w.Nstats[NLOCKED]++
-				if tx.count && KeyType(*NoConflictType) != OOWRITE {
-					tx.ls.candidates.Conflict(k, br, OOWRITE)
+				tx.w.Ncounters[NLOCKED]++
+				if tx.count && KeyType(tx.s.cfg.NoConflictType) != OOWRITE {
+					if err := tx.ls.candidates.Conflict(k, br, OOWRITE); err != nil {
+						return err
+					}
 				}
 				return EABORT
 			}
 		}
 		// Note the last timestamp and save it
-		n := len(tx.read)
-		tx.read = tx.read[0 : n+1]
-		tx.read[n].key = k
-		tx.read[n].br = br
-		tx.read[n].last = last
+		if err := tx.addRead(k, br, last); err != nil {
+			return err
+		}
 		if last > tx.maxSeen {
 			tx.maxSeen = last
 		}
 	}
+	return tx.addWrite(k, nil, op, a, v, Entry{})
+}
 
-	n := len(tx.writes)
-	tx.writes = tx.writes[0 : n+1]
-	tx.writes[n].key = k
-	tx.writes[n].br = nil
-	tx.writes[n].v = v
-	tx.writes[n].vint32 = a
-	tx.writes[n].op = op
-	tx.writes[n].locked = false
-	return nil
+func (tx *OTransaction) Delete(k Key) error {
+	for i := range tx.writes {
+		w := &tx.writes[i]
+		if w.key == k {
+			// Delete instead of writing.  If the key didn't exist
+			// Commit() won't create it.
+			if tx.isSplit(w.br) {
+				return ESTASH
+			}
+			if w.op == DELETE {
+				return ENOKEY
+			}
+			w.set(DELETE, 0, nil, Entry{})
+			return nil
+		}
+	}
+	// Read it, so that a concurrent write or delete aborts me.
+	br, err := tx.Read(k)
+	if err != nil {
+		return err
+	}
+	return tx.addWrite(k, br, DELETE, 0, nil, Entry{})
 }
 
 func (tx *OTransaction) SetPhase(p int) {
@@ -426,6 +624,7 @@ func (tx *OTransaction) Abort() TID {
 	for i, _ := range tx.writes {
 		if tx.writes[i].locked {
 			tx.writes[i].br.Unlock(0)
+			tx.writes[i].locked = false
 		}
 	}
 	return 0
@@ -442,7 +641,18 @@ func (tx *OTransaction) checkOwnership(br *BRecord, last uint64) bool {
 	return false
 }
 
+func (tx *OTransaction) tooBig() bool {
+	return tx.full
+}
+
+func (tx *OTransaction) commitError() error {
+	return tx.err
+}
+
 func (tx *OTransaction) Commit() TID {
+	if tx.full {
+		return tx.Abort()
+	}
 	// for each write key
 	//  if global get from global store and lock
 	for i, _ := range tx.writes {
@@ -450,12 +660,17 @@ func (tx *OTransaction) Commit() TID {
 		if w.br == nil {
 			var err error
 			w.br, err = tx.s.getKey(w.key, tx.w.ld)
-			if *CountKeys {
+			if tx.s.cfg.CountKeys {
 				p, r := UndoCKey(w.key)
 				if r == 'm' {
 					tx.w.NKeyAccesses[p]++
 				}
 			}
+			if w.op == DELETE && err == ENOKEY {
+				// Wrote, then deleted, a new key
+				w.br = nil
+				continue
+			}
 			// Data doesn't exist, create it
 			if w.br == nil || err == ENOKEY {
 				if w.br == nil {
@@ -467,14 +682,17 @@ func (tx *OTransaction) Commit() TID {
 				w.br, err2 = tx.s.CreateLockedKey(w.key, w.op)
 				if err2 != nil {
 					// Someone snuck in and created the key
-					if tx.count && w.op != KeyType(*NoConflictType) {
+					if tx.count && w.op != KeyType(tx.s.cfg.NoConflictType) {
 						tx.ls.candidates.Conflict(w.key, w.br, w.op)
 					}
-					tx.w.Nstats[NFAIL_VERIFY]++
+					tx.w.Ncounters[NFAIL_VERIFY]++
 					//dlog.Printf("Fail verify key %v\n", w.key)
 					return tx.Abort()
 				}
 				w.locked = true
+				if len(tx.scans) > 0 {
+					tx.created = append(tx.created, w.key)
+				}
 				continue
 			}
 		}
@@ -485,8 +703,8 @@ func (tx *OTransaction) Commit() TID {
 		var former uint64
 		var ok bool
 		if ok, former = w.br.Lock(); !ok {
-			tx.w.Nstats[NO_LOCK]++
-			if tx.count && w.op != KeyType(*NoConflictType) {
+			tx.w.Ncounters[NO_LOCK]++
+			if tx.count && w.op != KeyType(tx.s.cfg.NoConflictType) {
 				tx.ls.candidates.Conflict(w.key, w.br, w.op)
 			}
 			return tx.Abort()
@@ -495,6 +713,12 @@ func (tx *OTransaction) Commit() TID {
 		if former > tx.maxSeen {
 			tx.maxSeen = former
 		}
+		// Someone else's sum may have committed since I wrote
+		if !w.reset && w.br.overflows(w.op, w.value()) {
+			tx.w.Ncounters[NFAIL_VERIFY]++
+			tx.err = EOVERFLOW
+			return tx.Abort()
+		}
 	}
 
 	// Get TID higher than anything I've seen
@@ -503,7 +727,9 @@ func (tx *OTransaction) Commit() TID {
 		tx.w.resetTID(tx.maxSeen)
 		tid = tx.w.commitTID()
 		if uint64(tid) < tx.maxSeen {
-			log.Fatalf("%v MaxSeen %v, reset TID but %v<%v", tx.w.ID, tx.maxSeen, tid, tx.maxSeen)
+			dlog.Printf("%v MaxSeen %v, reset TID but %v<%v", tx.w.ID, tx.maxSeen, tid, tx.maxSeen)
+			tx.w.Ncounters[NINTERNAL]++
+			return tx.Abort()
 		}
 	}
 
@@ -514,7 +740,7 @@ func (tx *OTransaction) Commit() TID {
 		var err error
 		if rk.br == nil {
 			rk.br, err = tx.s.getKey(rk.key, tx.w.ld)
-			if *CountKeys {
+			if tx.s.cfg.CountKeys {
 				p, r := UndoCKey(rk.key)
 				if r == 'm' {
 					tx.w.NKeyAccesses[p]++
@@ -525,7 +751,7 @@ func (tx *OTransaction) Commit() TID {
 			if err == ENOKEY || tx.checkOwnership(rk.br, rk.last) {
 				continue
 			}
-			tx.w.Nstats[NFAIL_VERIFY]++
+			tx.w.Ncounters[NFAIL_VERIFY]++
 			return tx.Abort()
 		}
 		if rk.br.Verify(rk.last) {
@@ -535,93 +761,176 @@ func (tx *OTransaction) Commit() TID {
 		if tx.checkOwnership(rk.br, rk.last) {
 			continue
 		}
-		tx.w.Nstats[NFAIL_VERIFY]++
+		tx.w.Ncounters[NFAIL_VERIFY]++
+		return tx.Abort()
+	}
+	// Check for phantoms
+	if len(tx.scans) > 0 && !tx.verifyScans() {
+		tx.w.Ncounters[NFAIL_VERIFY]++
 		return tx.Abort()
 	}
 	// for each write key
 	//  if dd and split phase, apply locally
 	//  else apply globally and unlock
+	split := false
 	for i, _ := range tx.writes {
 		w := &tx.writes[i]
+		if w.br == nil && w.op == DELETE {
+			continue
+		}
 		if tx.isSplit(w.br) {
-			switch w.op {
-			case SUM:
-				tx.ls.ApplyInt32(w.key, w.op, w.vint32, w.op)
-			case MAX:
-				tx.ls.ApplyInt32(w.key, w.op, w.vint32, w.op)
-			case LIST:
-				tx.ls.ApplyList(w.key, w.ve)
-			case OOWRITE:
-				tx.ls.ApplyOO(w.key, w.vint32, w.v)
-			default:
-				tx.ls.Apply(w.key, w.op, w.v, w.op)
-			}
+			split = true
+			w.local(tx.ls, w.key)
 		} else {
-			switch w.op {
-			case SUM:
-				tx.s.SetInt32(w.br, w.vint32, w.op)
-			case MAX:
-				tx.s.SetInt32(w.br, w.vint32, w.op)
-			case LIST:
-				tx.s.SetList(w.br, w.ve, w.op)
-			case OOWRITE:
-				tx.s.SetOO(w.br, w.vint32, w.v, w.op)
-			default:
-				if w.br == nil {
-					log.Fatalf("How is this nil?\n")
-				}
-				tx.s.Set(w.br, w.v, w.op)
+			// Snapshot readers copy records holding SRLock()
+			w.br.SLock()
+			w.commit(tx.s, w.br)
+			w.br.SUnlock()
+			if w.op == DELETE {
+				tx.w.deleted = append(tx.w.deleted, w.key)
 			}
 			w.br.Unlock(tid)
+			w.locked = false
 		}
 	}
+	if tx.w.wal != nil {
+		tx.log(tid, split)
+	}
 	return tid
 }
 
+func (tx *OTransaction) log(tid TID, split bool) {
+	writes := make([]logWrite, 0, len(tx.writes))
+	for i, _ := range tx.writes {
+		w := &tx.writes[i]
+		writes = w.log(w.key, writes)
+	}
+	tx.w.wal.Append(tid, writes, split)
+}
+
 func (tx *OTransaction) MaybeWrite(k Key) {
 	// no op
 }
 
 type Rec struct {
-	br     *BRecord
-	read   bool
-	v      interface{}
-	vint32 int32
-	ve     Entry
-	op     KeyType
-	noset  bool
-	key    Key
+	br    *BRecord
+	read  bool
+	noset bool
+	key   Key
+	pending
+}
+
+// A leaf of an Index a 2PL transaction scanned.  I give up the read
+// lock on it if I insert a key into it myself, and then check its
+// version at commit like OCC.
+type RangeLock struct {
+	x       *Index
+	l       *leaf
+	version uint64
+	held    bool
+	inserts int
 }
 
 // Not threadsafe.  Tracks execution of transaction.
 type LTransaction struct {
-	padding0    [128]byte
-	keys        []Rec
-	w           *Worker
-	s           *Store
-	t           int64 // Used just as a rough count
-	ls          *LocalStore
-	phase       int
-	dummyRecord *BRecord
-	padding     [128]byte
+	padding0 [128]byte
+	keys     []Rec
+	ranges   []RangeLock
+	w        *Worker
+	s        *Store
+	t        int64 // Used just as a rough count
+	ls       *LocalStore
+	phase    int
+	full     bool // Ran out of room; can't commit
+	padding  [128]byte
 }
 
 func StartLTransaction(w *Worker) *LTransaction {
 	tx := &LTransaction{
-		keys:        make([]Rec, 0, 100),
-		w:           w,
-		s:           w.store,
-		ls:          w.local_store,
-		dummyRecord: &BRecord{},
+		keys: make([]Rec, 0, TXN_KEYS),
+		w:    w,
+		s:    w.store,
+		ls:   w.local_store,
 	}
 	return tx
 }
 
 func (tx *LTransaction) Reset() {
+	tx.full = false
 	tx.keys = tx.keys[:0]
+	tx.ranges = tx.ranges[:0]
 	tx.t++
 }
 
+func (tx *LTransaction) ReadSnapshot(k Key) (Value, error) {
+	held, _ := tx.already_exists(k)
+	return tx.s.readSnapshot(k, tx.s.SnapshotGen(), held)
+}
+
+func (tx *LTransaction) Scan(start, end Key, limit int) ([]Key, error) {
+	x := tx.s.Index(start)
+	if x == nil {
+		return nil, ENOINDEX
+	}
+	x.lockRange(start, end, tx.holds, func(l *leaf) {
+		tx.ranges = append(tx.ranges, RangeLock{x: x, l: l, version: l.version, held: true})
+	})
+	return x.scan(start, end, limit, nil), nil
+}
+
+func (tx *LTransaction) holds(l *leaf) bool {
+	for i := range tx.ranges {
+		if tx.ranges[i].l == l && tx.ranges[i].held {
+			return true
+		}
+	}
+	return false
+}
+
+// About to create k, which inserts it into its index.  That needs the
+// write lock on its leaf, so give up my read lock if I have it.
+func (tx *LTransaction) inserting(k Key) {
+	if len(tx.ranges) == 0 {
+		return
+	}
+	x := tx.s.Index(k)
+	if x == nil {
+		return
+	}
+	l := x.leafOf(k)
+	for i := range tx.ranges {
+		r := &tx.ranges[i]
+		if r.l != l {
+			continue
+		}
+		if r.held {
+			r.l.RUnlock()
+			r.held = false
+		}
+		r.inserts++
+	}
+}
+
+// Leaves I stopped holding must only have changed by my inserts.
+func (tx *LTransaction) verifyRanges() bool {
+	for i := range tx.ranges {
+		r := &tx.ranges[i]
+		if !r.held && r.x.versionOf(r.l) != r.version+uint64(r.inserts) {
+			return false
+		}
+	}
+	return true
+}
+
+func (tx *LTransaction) unlockRanges() {
+	for i := range tx.ranges {
+		if tx.ranges[i].held {
+			tx.ranges[i].l.RUnlock()
+			tx.ranges[i].held = false
+		}
+	}
+}
+
 func (tx *LTransaction) UID(f rune) uint64 {
 	return tx.w.NextKey(f)
 }
@@ -632,6 +941,9 @@ func (tx *LTransaction) RelinquishKey(n uint64, r rune) {
 
 func (tx *LTransaction) Read(k Key) (*BRecord, error) {
 	if exists, n := tx.already_exists(k); exists {
+		if tx.keys[n].op == DELETE && tx.keys[n].noset == false && tx.keys[n].read == false {
+			return nil, ENOKEY
+		}
 		if tx.keys[n].noset == true && tx.keys[n].br.exists {
 			// MaybeWrite(); if the key exists.
 			return tx.keys[n].br, nil
@@ -640,17 +952,14 @@ func (tx *LTransaction) Read(k Key) (*BRecord, error) {
 			// Doesn't really exist yet; created to lock for read or MaybeWrite()
 			return nil, ENOKEY
 		}
-		if tx.keys[n].br.exists && tx.keys[n].noset == false && tx.keys[n].read == false {
-			tx.dummyRecord.key_type = tx.keys[n].op
-			tx.dummyRecord.int_value = tx.keys[n].vint32
-			tx.dummyRecord.value = tx.keys[n].v
-			dlog.Printf("Creating dummy record for key %v %v %v %v\n", k, tx.dummyRecord.key_type, tx.dummyRecord.int_value, tx.dummyRecord.value)
-			if tx.keys[n].op == LIST {
-				tx.dummyRecord.entries = tx.dummyRecord.entries[0 : len(tx.keys[n].br.entries)+1]
-				copy(tx.dummyRecord.entries, tx.keys[n].br.entries)
-				tx.dummyRecord.entries = append(tx.dummyRecord.entries, tx.keys[n].ve)
+		if tx.keys[n].noset == false && tx.keys[n].read == false {
+			// My write; I hold the lock, so the record is what it
+			// was before it.
+			var base *BRecord
+			if tx.keys[n].br.exists {
+				base = tx.keys[n].br
 			}
-			return tx.dummyRecord, nil
+			return tx.keys[n].record(k, base), nil
 		}
 		if tx.keys[n].br.exists && tx.keys[n].noset == false && tx.keys[n].read == true {
 			return tx.keys[n].br, nil
@@ -658,23 +967,30 @@ func (tx *LTransaction) Read(k Key) (*BRecord, error) {
 		dlog.Printf("Returning ENOKEY for key %v supposedly at slot %v. %v\n", k, n, tx.keys[n])
 		return nil, ENOKEY
 	}
+	if !tx.room() {
+		return nil, ETOOBIG
+	}
 	br, err := tx.s.getKey(k, tx.w.ld)
-	if *CountKeys {
+	if tx.s.cfg.CountKeys {
 		p, r := UndoCKey(k)
 		if r == 'm' {
 			tx.w.NKeyAccesses[p]++
 		}
 	}
 	n := len(tx.keys)
-	tx.keys = tx.keys[0 : n+1]
+	tx.keys = append(tx.keys, Rec{})
 	tx.keys[n].read = true
 	tx.keys[n].noset = false
 	tx.keys[n].key = k
 	tx.keys[n].br = br
 	if err == nil {
 		br.SRLock()
+		if br.deleted {
+			return nil, ENOKEY
+		}
 		return br, nil
 	}
+	tx.inserting(k)
 	if br, err = tx.s.CreateMuLockedKey(k, WRITE); err == nil {
 		tx.keys[n].noset = true
 		tx.keys[n].read = false
@@ -686,30 +1002,40 @@ func (tx *LTransaction) Read(k Key) (*BRecord, error) {
 	if br, err = tx.s.getKey(k, tx.w.ld); err == nil {
 		br.SRLock()
 		tx.keys[n].br = br
+		if br.deleted {
+			return nil, ENOKEY
+		}
 		return br, nil
 	}
-	log.Fatalf("Can't create key %v and it's not there now\n", k)
-	return br, nil
+	tx.keys = tx.keys[:n]
+	return nil, internalError(k, "can't create key and it's not there now")
 }
 
 // This is when I am reading a key and I might write it later; acquire
 // the write lock *before* the read.
 func (tx *LTransaction) MaybeWrite(k Key) {
 	if exists, _ := tx.already_exists(k); exists {
-		log.Fatalf("Shouldn't already have a lock on this\n")
+		// Too late; a write after a read fails with ErrMisuse
+		return
+	}
+	if !tx.room() {
+		// The Read() or write which follows fails
+		return
 	}
 	br, err := tx.s.getKey(k, tx.w.ld)
-	if *CountKeys {
+	if tx.s.cfg.CountKeys {
 		p, r := UndoCKey(k)
 		if r == 'm' {
 			tx.w.NKeyAccesses[p]++
 		}
 	}
 	if br == nil || err != nil {
+		tx.inserting(k)
 		if br, err = tx.s.CreateMuLockedKey(k, WRITE); err != nil {
 			// Perhaps someone snuck in and created this key already.
 			if br, err = tx.s.getKey(k, tx.w.ld); err != nil {
-				log.Fatalf("Can't create key %v and it's not there now\n", k)
+				// The Read() or write which follows tries again
+				return
 			}
 			br.SLock()
 		} // Created and Locked
@@ -718,7 +1044,7 @@ func (tx *LTransaction) MaybeWrite(k Key) {
 		br.SLock()
 	}
 	n := len(tx.keys)
-	tx.keys = tx.keys[0 : n+1]
+	tx.keys = append(tx.keys, Rec{})
 	tx.keys[n].br = br
 	tx.keys[n].read = false
 	tx.keys[n].noset = true
@@ -732,16 +1058,26 @@ func (tx *LTransaction) already_exists(k Key) (bool, int) {
 			return true, i
 		}
 	}
-	if len(tx.keys) == cap(tx.keys) {
-		// TODO: extend
-		log.Fatalf("Ran out of room\n")
-	}
 	return false, n
 }
 
-func (tx *LTransaction) make_or_get_key(k Key, op KeyType) *BRecord {
+// Whether I can lock another key.  A transaction which can't is too
+// big to commit.
+func (tx *LTransaction) room() bool {
+	if len(tx.keys) >= tx.s.cfg.MaxKeys {
+		tx.full = true
+		return false
+	}
+	return true
+}
+
+func (tx *LTransaction) tooBig() bool {
+	return tx.full
+}
+
+func (tx *LTransaction) make_or_get_key(k Key, op KeyType) (*BRecord, error) {
 	br, err := tx.s.getKey(k, tx.w.ld)
-	if *CountKeys {
+	if tx.s.cfg.CountKeys {
 		p, r := UndoCKey(k)
 		if r == 'm' {
 			tx.w.NKeyAccesses[p]++
@@ -749,11 +1085,12 @@ func (tx *LTransaction) make_or_get_key(k Key, op KeyType) *BRecord {
 	}
 	if br != nil && err == nil {
 		br.SLock()
-		return br
+		return br, nil
 	}
 	var err2 error
+	tx.inserting(k)
 	br, err2 = tx.s.CreateMuLockedKey(k, op)
-	if *CountKeys {
+	if tx.s.cfg.CountKeys {
 		p, r := UndoCKey(k)
 		if r == 'm' {
 			tx.w.NKeyAccesses[p]++
@@ -762,116 +1099,124 @@ func (tx *LTransaction) make_or_get_key(k Key, op KeyType) *BRecord {
 	if br == nil || err2 != nil {
 		br, err = tx.s.getKey(k, tx.w.ld)
 		if err != nil {
-			log.Fatalf("Should exist\n")
+			return nil, internalError(k, "can't create key and it's not there now")
 		}
 		br.SLock()
 	}
-	return br
+	return br, nil
 }
 
 func (tx *LTransaction) WriteInt32(k Key, a int32, op KeyType) error {
-	exists, n := tx.already_exists(k)
-	if exists {
-		if tx.keys[n].read == true {
-			log.Fatalf("Already have read lock on this key; cannot upgrade %v\n", k)
-		}
-		// Already locked.  TODO: aggregate
-		tx.keys[n].vint32 = a
-		tx.keys[n].op = op
-		tx.keys[n].noset = false
-		tx.keys[n].key = k
-		return nil
+	return tx.write(k, op, a, nil, Entry{})
+}
+
+func (tx *LTransaction) WriteInt64(k Key, a int64, op KeyType) error {
+	return tx.write(k, op, 0, a, Entry{})
+}
+
+func (tx *LTransaction) WriteFloat64(k Key, a float64, op KeyType) error {
+	return tx.write(k, op, 0, a, Entry{})
+}
+
+// Write-lock k and add a write to it, coalescing it with any I
+// already have.
+func (tx *LTransaction) write(k Key, op KeyType, a int32, v Value, e Entry) error {
+	n, err := tx.lockForWrite(k, op)
+	if err != nil {
+		return err
 	}
-	br := tx.make_or_get_key(k, op)
-	tx.keys = tx.keys[0 : n+1]
-	tx.keys[n].br = br
-	tx.keys[n].read = false
-	tx.keys[n].vint32 = a
-	tx.keys[n].op = op
-	tx.keys[n].noset = false
-	tx.keys[n].key = k
+	r := &tx.keys[n]
+	if err := r.write(k, r.noset, r.br, op, a, v, e); err != nil {
+		return err
+	}
+	r.noset = false
 	return nil
 }
 
-func (tx *LTransaction) Write(k Key, v Value, op KeyType) {
-	if op == SUM || op == MAX {
-		tx.WriteInt32(k, v.(int32), op)
-		return
-	}
+// Write-lock k, if I haven't already, and return its position in
+// tx.keys.  Sets nothing until the caller says so.
+func (tx *LTransaction) lockForWrite(k Key, op KeyType) (int, error) {
 	exists, n := tx.already_exists(k)
 	if exists {
 		if tx.keys[n].read == true {
-			log.Fatalf("Already have read lock on this key; cannot upgrade %v\n", k)
+			return n, misuse(k, "can't upgrade a read lock; use MaybeWrite()")
 		}
 		// Already locked.
-		tx.keys[n].v = v
-		tx.keys[n].op = op
-		tx.keys[n].key = k
-		return
+		return n, nil
 	}
-	br := tx.make_or_get_key(k, op)
-	tx.keys = tx.keys[0 : n+1]
+	if !tx.room() {
+		return n, ETOOBIG
+	}
+	br, err := tx.make_or_get_key(k, op)
+	if err != nil {
+		return n, err
+	}
+	tx.keys = append(tx.keys, Rec{})
 	tx.keys[n].br = br
 	tx.keys[n].read = false
-	tx.keys[n].v = v
-	tx.keys[n].op = op
-	tx.keys[n].noset = false
+	tx.keys[n].set(op, 0, nil, Entry{})
+	tx.keys[n].noset = true
 	tx.keys[n].key = k
+	return n, nil
+}
+
+func (tx *LTransaction) Write(k Key, v Value, op KeyType) error {
+	if op == SUM || op == MAX {
+		return tx.WriteInt32(k, v.(int32), op)
+	}
+	return tx.write(k, op, 0, v, Entry{})
 }
 
 func (tx *LTransaction) WriteList(k Key, l Entry, op KeyType) error {
 	if op != LIST {
-		log.Fatalf("Not a list\n")
-	}
-	exists, n := tx.already_exists(k)
-	if exists {
-		if tx.keys[n].read == true {
-			log.Fatalf("Already have read lock on this key; cannot upgrade %v\n", k)
-		}
-		// Already locked.  TODO: append
-		tx.keys[n].ve = l
-		tx.keys[n].op = op
-		tx.keys[n].key = k
-		return nil
+		return typeMismatch(k, op, LIST)
 	}
-	br := tx.make_or_get_key(k, op)
-	tx.keys = tx.keys[0 : n+1]
-	tx.keys[n].br = br
-	tx.keys[n].read = false
-	tx.keys[n].ve = l
-	tx.keys[n].op = op
-	tx.keys[n].noset = false
-	tx.keys[n].key = k
-	return nil
+	return tx.write(k, op, 0, nil, l)
 }
 
 func (tx *LTransaction) WriteOO(k Key, a int32, v Value, op KeyType) error {
 	if op != OOWRITE {
-		log.Fatalf("Not overwrite \n")
+		return typeMismatch(k, op, OOWRITE)
 	}
+	return tx.write(k, op, a, v, Entry{})
+}
+
+func (tx *LTransaction) Delete(k Key) error {
 	exists, n := tx.already_exists(k)
 	if exists {
 		if tx.keys[n].read == true {
-			log.Fatalf("Already have read lock on this key; cannot upgrade %v\n", k)
+			return misuse(k, "can't upgrade a read lock; use MaybeWrite()")
 		}
-		// Already locked.
-		if a > tx.keys[n].vint32 {
-			tx.keys[n].v = v
-			tx.keys[n].vint32 = a
-			tx.keys[n].op = op
-			tx.keys[n].key = k
+		br := tx.keys[n].br
+		if tx.keys[n].noset && (!br.exists || br.deleted) {
+			return ENOKEY
+		}
+		if tx.keys[n].op == DELETE && tx.keys[n].noset == false {
+			return ENOKEY
 		}
+		tx.keys[n].set(DELETE, 0, nil, Entry{})
+		tx.keys[n].noset = false
 		return nil
 	}
-	br := tx.make_or_get_key(k, op)
-	tx.keys = tx.keys[0 : n+1]
+	if !tx.room() {
+		return ETOOBIG
+	}
+	br, err := tx.s.getKey(k, tx.w.ld)
+	if err != nil {
+		// Lock the fact that it doesn't exist
+		tx.Read(k)
+		return ENOKEY
+	}
+	br.SLock()
+	tx.keys = append(tx.keys, Rec{})
 	tx.keys[n].br = br
 	tx.keys[n].read = false
-	tx.keys[n].vint32 = a
-	tx.keys[n].v = v
-	tx.keys[n].op = op
-	tx.keys[n].noset = false
+	tx.keys[n].set(DELETE, 0, nil, Entry{})
+	tx.keys[n].noset = br.deleted || !br.exists
 	tx.keys[n].key = k
+	if tx.keys[n].noset {
+		return ENOKEY
+	}
 	return nil
 }
 
@@ -899,11 +1244,24 @@ func (tx *LTransaction) Abort() TID {
 			tx.keys[i].br.SUnlock()
 		}
 	}
+	tx.unlockRanges()
+	tx.keys = tx.keys[:0]
 	return 0
 }
 
 func (tx *LTransaction) Commit() TID {
+	if tx.full {
+		return tx.Abort()
+	}
+	if len(tx.ranges) > 0 && !tx.verifyRanges() {
+		tx.w.Ncounters[NFAIL_VERIFY]++
+		return tx.Abort()
+	}
 	tid := tx.w.commitTID()
+	if tx.w.wal != nil {
+		tid = tx.logTID(tid)
+		tx.log(tid)
+	}
 	for i := len(tx.keys) - 1; i >= 0; i-- {
 		// Apply and unlock
 		if tx.keys[i].read == false {
@@ -913,17 +1271,9 @@ func (tx *LTransaction) Commit() TID {
 				tx.keys[i].br.SUnlock()
 				continue
 			}
-			switch tx.keys[i].op {
-			case SUM:
-				tx.s.SetInt32(tx.keys[i].br, tx.keys[i].vint32, tx.keys[i].op)
-			case MAX:
-				tx.s.SetInt32(tx.keys[i].br, tx.keys[i].vint32, tx.keys[i].op)
-			case LIST:
-				tx.s.SetList(tx.keys[i].br, tx.keys[i].ve, tx.keys[i].op)
-			case OOWRITE:
-				tx.s.SetOO(tx.keys[i].br, tx.keys[i].vint32, tx.keys[i].v, tx.keys[i].op)
-			default:
-				tx.s.Set(tx.keys[i].br, tx.keys[i].v, tx.keys[i].op)
+			tx.keys[i].commit(tx.s, tx.keys[i].br)
+			if tx.keys[i].op == DELETE {
+				tx.w.deleted = append(tx.w.deleted, tx.keys[i].key)
 			}
 			tx.keys[i].br.SUnlock()
 		} else {
@@ -931,9 +1281,51 @@ func (tx *LTransaction) Commit() TID {
 			tx.keys[i].br.SRUnlock()
 		}
 	}
+	tx.unlockRanges()
+	tx.keys = tx.keys[:0]
 	return tid
 }
 
+// 2PL doesn't otherwise use the records' TIDs, but the redo log is
+// replayed in TID order, so the TID has to be bigger than that of the
+// last transaction to write any of these keys.  All of them are
+// exclusively locked, so nobody else is touching the TIDs.
+func (tx *LTransaction) logTID(tid TID) TID {
+	var max uint64
+	for i := range tx.keys {
+		if tx.keys[i].read || tx.keys[i].noset {
+			continue
+		}
+		if last := tx.keys[i].br.last.Read(); last > max {
+			max = last
+		}
+	}
+	if uint64(tid) <= max {
+		tx.w.resetTID(max)
+		tid = tx.w.commitTID()
+	}
+	for i := range tx.keys {
+		if tx.keys[i].read || tx.keys[i].noset {
+			continue
+		}
+		tx.keys[i].br.Lock()
+		tx.keys[i].br.Unlock(tid)
+	}
+	return tid
+}
+
+func (tx *LTransaction) log(tid TID) {
+	writes := make([]logWrite, 0, len(tx.keys))
+	for i := range tx.keys {
+		k := &tx.keys[i]
+		if k.read || k.noset {
+			continue
+		}
+		writes = k.log(k.key, writes)
+	}
+	tx.w.wal.Append(tid, writes, false)
+}
+
 func (tx *LTransaction) NoCount() {
 	// noop
 }
@@ -7,7 +7,7 @@ import (
 )
 
 func TestConcurrentGotomic(t *testing.T) {
-	s := NewStore()
+	s := NewStore(FlagConfig())
 	sp := 5
 	for i := 0; i < 10; i++ {
 		k := ProductKey(i)
@@ -0,0 +1,294 @@
+package ddtxn
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+)
+
+// An Index keeps the keys that start with a prefix in order, so
+// transactions can Scan() ranges of them.  Keys are compared
+// bytewise; use OKey() to get keys that sort by number.
+//
+// Keys are kept in a list of sorted leaves.  Every insert into a leaf
+// (and every split of one) bumps its version.  OCC scans remember the
+// versions of the leaves they covered and abort at commit if any
+// changed, which catches phantoms.  2PL scans read-lock the leaves
+// covering the range until commit; inserts write-lock the leaf they
+// go into.  Like the 2PL record locks these don't detect deadlock.
+//
+// Deleted keys stay until the tombstones are collected.  A scan can
+// return a key which was deleted, or created but never written (by an
+// aborted transaction, or a 2PL read of a missing key); reading it
+// returns ENOKEY.
+
+const LEAF_SIZE = 64
+
+var ENOINDEX = errors.New("doppel: no index on key")
+
+type leaf struct {
+	sync.RWMutex       // 2PL range lock
+	min          Key   // Smallest key routed here; unused for the first leaf
+	keys         []Key // Sorted
+	version      uint64
+}
+
+type Index struct {
+	mu     sync.RWMutex
+	prefix []byte
+	leaves []*leaf
+	n      int
+}
+
+func newIndex(prefix []byte) *Index {
+	x := &Index{
+		prefix: append([]byte(nil), prefix...),
+		leaves: []*leaf{{keys: make([]Key, 0, LEAF_SIZE+1)}},
+	}
+	return x
+}
+
+// Long keys compare by the bytes they were made from, not the
+// interned form.
+func keyLess(a, b Key) bool {
+	if !a.IsLong() && !b.IsLong() {
+		return bytes.Compare(a[:], b[:]) < 0
+	}
+	return bytes.Compare(a.Bytes(), b.Bytes()) < 0
+}
+
+func (x *Index) covers(k Key) bool {
+	if !k.IsLong() {
+		return bytes.HasPrefix(k[:], x.prefix)
+	}
+	return bytes.HasPrefix(k.Bytes(), x.prefix)
+}
+
+func (x *Index) Prefix() []byte {
+	return x.prefix
+}
+
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.n
+}
+
+// Position of the leaf k belongs in.  Caller holds x.mu.
+func (x *Index) route(k Key) int {
+	return sort.Search(len(x.leaves)-1, func(i int) bool {
+		return keyLess(k, x.leaves[i+1].min)
+	})
+}
+
+// Caller holds x.mu exclusively.
+func (x *Index) add(i int, k Key) {
+	l := x.leaves[i]
+	j := sort.Search(len(l.keys), func(j int) bool {
+		return !keyLess(l.keys[j], k)
+	})
+	if j < len(l.keys) && l.keys[j] == k {
+		return
+	}
+	l.keys = append(l.keys, Key{})
+	copy(l.keys[j+1:], l.keys[j:])
+	l.keys[j] = k
+	l.version++
+	x.n++
+	if len(l.keys) <= LEAF_SIZE {
+		return
+	}
+	half := len(l.keys) / 2
+	r := &leaf{min: l.keys[half], keys: make([]Key, len(l.keys)-half, LEAF_SIZE+1)}
+	copy(r.keys, l.keys[half:])
+	l.keys = l.keys[:half]
+	x.leaves = append(x.leaves, nil)
+	copy(x.leaves[i+2:], x.leaves[i+1:])
+	x.leaves[i+1] = r
+}
+
+// Add k, which just went into the store.  2PL scanners hold read
+// locks on leaves, so if locking, wait for the leaf's write lock.
+func (x *Index) insert(k Key, locking bool) {
+	if !locking {
+		x.mu.Lock()
+		x.add(x.route(k), k)
+		x.mu.Unlock()
+		return
+	}
+	// Can't wait for the leaf lock while holding x.mu; a 2PL scanner
+	// holding the leaf might need x.mu to finish.
+	for {
+		x.mu.RLock()
+		l := x.leaves[x.route(k)]
+		x.mu.RUnlock()
+		l.Lock()
+		x.mu.Lock()
+		i := x.route(k)
+		if x.leaves[i] == l {
+			x.add(i, k)
+			x.mu.Unlock()
+			l.Unlock()
+			return
+		}
+		// Split while I was waiting
+		x.mu.Unlock()
+		l.Unlock()
+	}
+}
+
+// Only called when no transactions are running.
+func (x *Index) remove(k Key) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	l := x.leaves[x.route(k)]
+	j := sort.Search(len(l.keys), func(j int) bool {
+		return !keyLess(l.keys[j], k)
+	})
+	if j == len(l.keys) || l.keys[j] != k {
+		return
+	}
+	l.keys = append(l.keys[:j], l.keys[j+1:]...)
+	l.version++
+	x.n--
+}
+
+// Leaves which might hold keys in [start, end).  Caller holds x.mu.
+func (x *Index) span(start, end Key) []*leaf {
+	var ls []*leaf
+	for i := x.route(start); i < len(x.leaves); i++ {
+		if i > 0 && len(ls) > 0 && !keyLess(x.leaves[i].min, end) {
+			break
+		}
+		ls = append(ls, x.leaves[i])
+	}
+	return ls
+}
+
+// Keys in [start, end), at most limit of them if limit > 0.  Calls
+// f(l) with x.mu held on every leaf it looks at.
+func (x *Index) scan(start, end Key, limit int, f func(*leaf)) []Key {
+	var keys []Key
+	if !keyLess(start, end) {
+		return keys
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for _, l := range x.span(start, end) {
+		if f != nil {
+			f(l)
+		}
+		j := sort.Search(len(l.keys), func(j int) bool {
+			return !keyLess(l.keys[j], start)
+		})
+		for ; j < len(l.keys); j++ {
+			if !keyLess(l.keys[j], end) {
+				return keys
+			}
+			keys = append(keys, l.keys[j])
+			if limit > 0 && len(keys) == limit {
+				return keys
+			}
+		}
+	}
+	return keys
+}
+
+// Read-lock the leaves covering [start, end), skipping ones held()
+// says are already locked.  Calls f(l) with x.mu held on every leaf
+// it newly locks.  Once they're locked nothing can split them, so the
+// set of leaves covering the range can't change until they're
+// unlocked.
+func (x *Index) lockRange(start, end Key, held func(*leaf) bool, f func(*leaf)) {
+	if !keyLess(start, end) {
+		return
+	}
+	for {
+		x.mu.RLock()
+		ls := x.span(start, end)
+		x.mu.RUnlock()
+		locked := make([]*leaf, 0, len(ls))
+		for _, l := range ls {
+			if !held(l) {
+				l.RLock()
+				locked = append(locked, l)
+			}
+		}
+		x.mu.RLock()
+		now := x.span(start, end)
+		same := len(now) == len(ls)
+		for i := 0; same && i < len(ls); i++ {
+			same = now[i] == ls[i]
+		}
+		if same {
+			for _, l := range locked {
+				f(l)
+			}
+			x.mu.RUnlock()
+			return
+		}
+		x.mu.RUnlock()
+		for _, l := range locked {
+			l.RUnlock()
+		}
+	}
+}
+
+// Whether k's leaf is l.
+func (x *Index) routesTo(k Key, l *leaf) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.covers(k) && x.leaves[x.route(k)] == l
+}
+
+func (x *Index) leafOf(k Key) *leaf {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.leaves[x.route(k)]
+}
+
+func (x *Index) versionOf(l *leaf) uint64 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return l.version
+}
+
+// Keep an ordered index of the keys starting with prefix, including
+// ones already in the store.  Call before starting transactions;
+// indexes can't overlap.
+func (s *Store) CreateIndex(prefix []byte) *Index {
+	for _, x := range s.indexes {
+		if bytes.HasPrefix(x.prefix, prefix) || bytes.HasPrefix(prefix, x.prefix) {
+			log.Fatalf("Index on %v overlaps index on %v\n", prefix, x.prefix)
+		}
+	}
+	x := newIndex(prefix)
+	s.forEach(func(br *BRecord) {
+		if x.covers(br.key) {
+			x.add(x.route(br.key), br.key)
+		}
+	})
+	s.indexes = append(s.indexes, x)
+	return x
+}
+
+func (s *Store) Index(k Key) *Index {
+	for _, x := range s.indexes {
+		if x.covers(k) {
+			return x
+		}
+	}
+	return nil
+}
+
+// Called after a new key goes into the store.
+func (s *Store) indexKey(k Key) {
+	if len(s.indexes) == 0 {
+		return
+	}
+	if x := s.Index(k); x != nil {
+		x.insert(k, s.cfg.SysType == LOCKING)
+	}
+}
@@ -0,0 +1,141 @@
+package ddtxn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIndexScan(t *testing.T) {
+	s := NewStore(FlagConfig())
+	for i := 0; i < 500; i += 2 {
+		s.CreateKey(OKey('i', uint64(i)), int32(i), SUM)
+	}
+	s.CreateKey(OKey('j', 1), int32(0), SUM)
+	x := s.CreateIndex([]byte{'i'})
+	for i := 1; i < 500; i += 2 {
+		s.CreateKey(OKey('i', uint64(i)), int32(i), SUM)
+	}
+	if x.Len() != 500 {
+		t.Fatalf("Wrong number of keys in index %v\n", x.Len())
+	}
+	keys := x.scan(OKey('i', 100), OKey('i', 300), 0, nil)
+	if len(keys) != 200 {
+		t.Fatalf("Wrong number of keys %v\n", len(keys))
+	}
+	for i, k := range keys {
+		if ch, n := UndoOKey(k); ch != 'i' || n != uint64(100+i) {
+			t.Fatalf("Wrong key %v %v at %v\n", ch, n, i)
+		}
+	}
+	keys = x.scan(OKey('i', 490), OKey('j', 0), 5, nil)
+	if len(keys) != 5 || keys[4] != OKey('i', 494) {
+		t.Errorf("Wrong keys with limit %v\n", keys)
+	}
+	keys = x.scan(OKey('i', 300), OKey('i', 100), 0, nil)
+	if len(keys) != 0 {
+		t.Errorf("Expected no keys %v\n", keys)
+	}
+	if s.Index(OKey('j', 1)) != nil {
+		t.Errorf("Index on wrong prefix\n")
+	}
+}
+
+func TestScanPhantom(t *testing.T) {
+	s := NewStore(FlagConfig())
+	s.CreateIndex([]byte{'i'})
+	for i := 0; i < 10; i++ {
+		s.CreateKey(OKey('i', uint64(i*10)), int32(i), SUM)
+	}
+	c := NewCoordinator(2, s, BuiltinRegistry())
+	defer c.Finish()
+	tx1 := StartOTransaction(c.Workers[0])
+	tx2 := StartOTransaction(c.Workers[1])
+
+	tx1.Reset()
+	keys, err := tx1.Scan(OKey('i', 20), OKey('i', 50), 0)
+	if err != nil || len(keys) != 3 {
+		t.Fatalf("Scan %v %v\n", keys, err)
+	}
+	tx1.WriteInt32(OKey('i', 20), 1, SUM)
+	tx2.Reset()
+	tx2.WriteInt32(OKey('i', 35), 1, SUM)
+	if tx2.Commit() == 0 {
+		t.Fatalf("Insert aborted\n")
+	}
+	if tx1.Commit() != 0 {
+		t.Errorf("Committed despite phantom\n")
+	}
+
+	// My own inserts aren't phantoms
+	tx1.Reset()
+	keys, _ = tx1.Scan(OKey('i', 20), OKey('i', 50), 0)
+	if len(keys) != 4 {
+		t.Fatalf("Wrong keys %v\n", keys)
+	}
+	tx1.WriteInt32(OKey('i', 45), 1, SUM)
+	if tx1.Commit() == 0 {
+		t.Errorf("Aborted on own insert\n")
+	}
+	// Inserts outside the range don't matter
+	tx1.Reset()
+	tx1.Scan(OKey('i', 0), OKey('i', 20), 0)
+	tx2.Reset()
+	tx2.WriteInt32(OKey('j', 30), 1, SUM)
+	tx2.Commit()
+	if tx1.Commit() == 0 {
+		t.Errorf("Aborted on insert outside the index\n")
+	}
+	tx1.Reset()
+	if _, err := tx1.Scan(OKey('j', 0), OKey('j', 50), 0); err != ENOINDEX {
+		t.Errorf("Expected ENOINDEX, got %v\n", err)
+	}
+}
+
+func TestScanLocking(t *testing.T) {
+	cfg := FlagConfig()
+	cfg.SysType = LOCKING
+	s := NewStore(cfg)
+	s.CreateIndex([]byte{'i'})
+	for i := 0; i < 10; i++ {
+		s.CreateKey(OKey('i', uint64(i*10)), int32(i), SUM)
+	}
+	c := NewCoordinator(2, s, BuiltinRegistry())
+	defer c.Finish()
+	tx1 := StartLTransaction(c.Workers[0])
+	tx2 := StartLTransaction(c.Workers[1])
+
+	tx1.Reset()
+	keys, err := tx1.Scan(OKey('i', 20), OKey('i', 50), 0)
+	if err != nil || len(keys) != 3 {
+		t.Fatalf("Scan %v %v\n", keys, err)
+	}
+	done := make(chan TID)
+	go func() {
+		tx2.Reset()
+		tx2.WriteInt32(OKey('i', 35), 1, SUM)
+		done <- tx2.Commit()
+	}()
+	select {
+	case <-done:
+		t.Fatalf("Inserted into a locked range\n")
+	case <-time.After(10 * time.Millisecond):
+	}
+	// Insert into my own range
+	tx1.WriteInt32(OKey('i', 45), 1, SUM)
+	if tx1.Commit() != 0 {
+		t.Errorf("Committed though someone inserted after I unlocked the leaf\n")
+	}
+	if <-done == 0 {
+		t.Errorf("Insert aborted\n")
+	}
+
+	tx1.Reset()
+	keys, _ = tx1.Scan(OKey('i', 20), OKey('i', 50), 0)
+	if len(keys) != 5 {
+		t.Fatalf("Wrong keys %v\n", keys)
+	}
+	tx1.WriteInt32(OKey('i', 47), 1, SUM)
+	if tx1.Commit() == 0 {
+		t.Errorf("Aborted on own insert\n")
+	}
+}
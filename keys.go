@@ -1,10 +1,142 @@
 package ddtxn
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"strconv"
+	"sync"
 )
 
+// BytesKey() stores up to 15 bytes inline in the Key, zero padded,
+// with their length in the last byte.  Longer ones are interned: the
+// Key holds a hash of the bytes, an ID, and LONG_KEY in its last
+// byte.  Interned keys are never freed.
+const (
+	INLINE_KEY = 15
+	LONG_KEY   = 0xff
+)
+
+type keyTable struct {
+	sync.RWMutex
+	ids   map[string]uint64
+	names []string // names[id-1]
+}
+
+var longKeys = &keyTable{ids: make(map[string]uint64)}
+
+func longKey(s string) Key {
+	longKeys.RLock()
+	id, ok := longKeys.ids[s]
+	longKeys.RUnlock()
+	if !ok {
+		longKeys.Lock()
+		id, ok = longKeys.ids[s]
+		if !ok {
+			longKeys.names = append(longKeys.names, s)
+			id = uint64(len(longKeys.names))
+			longKeys.ids[s] = id
+		}
+		longKeys.Unlock()
+	}
+	var b [16]byte
+	binary.LittleEndian.PutUint32(b[0:4], crc32.ChecksumIEEE([]byte(s)))
+	binary.LittleEndian.PutUint64(b[4:12], id)
+	b[15] = LONG_KEY
+	return Key(b)
+}
+
+// A key for arbitrary bytes.  Different bytes make different keys.
+func BytesKey(b []byte) Key {
+	if len(b) <= INLINE_KEY {
+		var k Key
+		copy(k[:], b)
+		k[15] = byte(len(b))
+		return k
+	}
+	return longKey(string(b))
+}
+
+// A key made of several strings, e.g. a table name and an email
+// address.  Each part is length-prefixed, so different lists of
+// parts never make the same key.
+func CompositeKey(parts ...string) Key {
+	n := 0
+	for _, p := range parts {
+		n += binary.MaxVarintLen64 + len(p)
+	}
+	b := make([]byte, 0, n)
+	var l [binary.MaxVarintLen64]byte
+	for _, p := range parts {
+		b = append(b, l[:binary.PutUvarint(l[:], uint64(len(p)))]...)
+		b = append(b, p...)
+	}
+	return BytesKey(b)
+}
+
+func (k Key) IsLong() bool {
+	return k[15] == LONG_KEY
+}
+
+// The bytes an interned key was made from.  Keys built some other
+// way can end in LONG_KEY too; they aren't interned.
+func (k Key) name() (string, bool) {
+	if !k.IsLong() {
+		return "", false
+	}
+	id := binary.LittleEndian.Uint64(k[4:12])
+	longKeys.RLock()
+	defer longKeys.RUnlock()
+	if id == 0 || id > uint64(len(longKeys.names)) {
+		return "", false
+	}
+	s := longKeys.names[id-1]
+	if binary.LittleEndian.Uint32(k[0:4]) != crc32.ChecksumIEEE([]byte(s)) || k[12] != 0 || k[13] != 0 || k[14] != 0 {
+		return "", false
+	}
+	return s, true
+}
+
+// The bytes k was made from by BytesKey().  Keys made some other way,
+// like CKey(), come back as all 16 bytes.
+func (k Key) Bytes() []byte {
+	if s, ok := k.name(); ok {
+		return []byte(s)
+	}
+	if n := int(k[15]); n <= INLINE_KEY {
+		inline := true
+		for i := n; i < 15; i++ {
+			inline = inline && k[i] == 0
+		}
+		if inline {
+			return append([]byte(nil), k[:n]...)
+		}
+	}
+	return append([]byte(nil), k[:]...)
+}
+
+// Interned IDs only mean something in this process, so keys are
+// encoded (in the redo log, checkpoints and RPCs) as their 16 bytes,
+// or LONG_KEY followed by the bytes they were interned from.
+func (k Key) GobEncode() ([]byte, error) {
+	if s, ok := k.name(); ok {
+		return append([]byte{LONG_KEY}, s...), nil
+	}
+	return append([]byte(nil), k[:]...), nil
+}
+
+func (k *Key) GobDecode(b []byte) error {
+	switch {
+	case len(b) == 16:
+		copy(k[:], b)
+	case len(b) > 16 && b[0] == LONG_KEY:
+		*k = longKey(string(b[1:]))
+	default:
+		return fmt.Errorf("doppel: bad key encoding %v", b)
+	}
+	return nil
+}
+
 type KeyGenFunc func(uint64) Key
 
 func CKey(x uint64, ch rune) Key {
@@ -29,10 +161,34 @@ func UndoCKey(k Key) (uint64, rune) {
 }
 
 func (k Key) String() string {
+	if s, ok := k.name(); ok {
+		return strconv.Quote(s)
+	}
 	x, y := UndoCKey(k)
 	return fmt.Sprintf("[%v%v]", strconv.QuoteRuneToASCII(y), x)
 }
 
+// Keys with the same ch sort by x, so they can be scanned in order
+// from an Index on []byte{byte(ch)}.
+func OKey(ch rune, x uint64) Key {
+	var b [16]byte
+	var i uint64
+	b[0] = byte(ch)
+	for i = 0; i < 8; i++ {
+		b[8-i] = byte((x >> (i * 8)))
+	}
+	return Key(b)
+}
+
+func UndoOKey(k Key) (rune, uint64) {
+	b := [16]byte(k)
+	var x uint64
+	for i := 1; i <= 8; i++ {
+		x = x<<8 | uint64(b[i])
+	}
+	return rune(b[0]), x
+}
+
 func TKey(x uint64, y uint64) Key {
 	var b [16]byte
 	var i uint64
@@ -46,15 +202,7 @@ func TKey(x uint64, y uint64) Key {
 }
 
 func SKey(s string) Key {
-	var b [16]byte
-	end := 16
-	if len(s) < 16 {
-		end = len(s)
-	}
-	for i := 0; i < end; i++ {
-		b[i] = s[i]
-	}
-	return Key(b)
+	return BytesKey([]byte(s))
 }
 
 func UserKey(bidder uint64) Key {
@@ -0,0 +1,108 @@
+package ddtxn
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"testing"
+)
+
+func TestLongKeys(t *testing.T) {
+	a := SKey("alice@example.com")
+	b := SKey("alice@example.org")
+	if a == b || !a.IsLong() || !b.IsLong() {
+		t.Fatalf("Long keys collided %v %v\n", a, b)
+	}
+	if a != SKey("alice@example.com") {
+		t.Errorf("Same string, different keys\n")
+	}
+	if string(a.Bytes()) != "alice@example.com" {
+		t.Errorf("Wrong bytes %q\n", a.Bytes())
+	}
+	if SKey("bob").IsLong() || SKey("bob") != BytesKey([]byte("bob")) {
+		t.Errorf("Short key not inline\n")
+	}
+	if CompositeKey("ab", "c") == CompositeKey("a", "bc") {
+		t.Errorf("Composite keys collided\n")
+	}
+
+	s := NewStore(FlagConfig())
+	s.CreateKey(a, "a", WRITE)
+	s.CreateKey(b, "b", WRITE)
+	br, err := s.Get(a)
+	if err != nil || br.Value().(string) != "a" {
+		t.Errorf("Wrong value %v %v\n", br, err)
+	}
+
+	var buf bytes.Buffer
+	q := MicroQuery(0, a, UserKey(4), 0)
+	if err := gob.NewEncoder(&buf).Encode(&q); err != nil {
+		t.Fatalf("Encode %v\n", err)
+	}
+	var q2 Query
+	if err := gob.NewDecoder(&buf).Decode(&q2); err != nil {
+		t.Fatalf("Decode %v\n", err)
+	}
+	if q2.Key(MICRO_K1) != a || q2.Key(MICRO_K2) != UserKey(4) {
+		t.Errorf("Wrong keys after decode %v\n", q2.Args)
+	}
+}
+
+func TestKeyCollisions(t *testing.T) {
+	if CompositeKey("a") == CompositeKey("a", "") {
+		t.Errorf("Composite keys collided\n")
+	}
+	if BytesKey([]byte("a")) == BytesKey([]byte("a\x00")) {
+		t.Errorf("Trailing zero collided\n")
+	}
+	b := []byte{}
+	for i := 0; i < 20; i++ {
+		k := BytesKey(b)
+		if k.IsLong() != (i > INLINE_KEY) || !bytes.Equal(k.Bytes(), b) {
+			t.Errorf("%v: Wrong bytes %q %v\n", i, k.Bytes(), k.IsLong())
+		}
+		b = append(b, 0)
+	}
+
+	// Built by hand; not interned
+	var k Key
+	k[4] = 100
+	k[15] = LONG_KEY
+	if !bytes.Equal(k.Bytes(), k[:]) || k.String() == "" {
+		t.Errorf("Wrong bytes %v\n", k.Bytes())
+	}
+	for _, k := range []Key{k, UserKey(3), BytesKey([]byte("a")), SKey("alice@example.com")} {
+		var buf bytes.Buffer
+		var k2 Key
+		if err := gob.NewEncoder(&buf).Encode(k); err != nil {
+			t.Fatalf("Encode %v\n", err)
+		}
+		if err := gob.NewDecoder(&buf).Decode(&k2); err != nil || k2 != k {
+			t.Errorf("Decoded %v as %v %v\n", k, k2, err)
+		}
+	}
+}
+
+func TestChunkSpread(t *testing.T) {
+	cfg := FlagConfig()
+	cfg.NChunks = 16
+	s := NewStore(cfg)
+	for i := 0; i < 1600; i++ {
+		s.CreateKey(SKey(fmt.Sprintf("user%d", i)), int32(0), SUM)
+	}
+	for i, c := range s.store {
+		if len(c.rows) < 50 || len(c.rows) > 150 {
+			t.Errorf("Chunk %v has %v keys\n", i, len(c.rows))
+		}
+	}
+}
+
+// Looking a key up is on every transaction's path; it mustn't allocate.
+func TestGetKeyAllocs(t *testing.T) {
+	s := NewStore(FlagConfig())
+	k := ProductKey(1)
+	s.CreateKey(k, int32(0), SUM)
+	if n := testing.AllocsPerRun(100, func() { s.getKey(k, nil) }); n != 0 {
+		t.Errorf("getKey allocated %v times\n", n)
+	}
+}
@@ -0,0 +1,104 @@
+package ddtxn
+
+import "testing"
+
+func orders(lst []Entry) []int {
+	x := make([]int, len(lst))
+	for i := range lst {
+		x[i] = lst[i].order
+	}
+	return x
+}
+
+func sameOrders(lst []Entry, want ...int) bool {
+	if len(lst) != len(want) {
+		return false
+	}
+	for i := range lst {
+		if lst[i].order != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestListOptions(t *testing.T) {
+	var lst []Entry
+	for _, x := range []int{5, 1, 9, 3, 7} {
+		lst = AddOneToList(lst, NewEntry(x, UserKey(uint64(x)), nil))
+	}
+	if !sameOrders(lst, 9, 7, 5, 3, 1) {
+		t.Errorf("Not descending %v\n", orders(lst))
+	}
+
+	o := &ListOptions{Size: 3, Ascending: true}
+	lst = nil
+	for _, x := range []int{5, 1, 9, 3, 7} {
+		lst = addToList(lst, NewEntry(x, UserKey(uint64(x)), nil), o)
+	}
+	if !sameOrders(lst, 1, 3, 5) {
+		t.Errorf("Wrong ascending top 3 %v\n", orders(lst))
+	}
+
+	// Only the best entry for each key survives
+	o = &ListOptions{Size: 20, Unique: true}
+	lst = nil
+	for i, x := range []int{4, 8, 6, 2} {
+		lst = addToList(lst, NewEntry(x, UserKey(uint64(i%2)), x*10), o)
+	}
+	if !sameOrders(lst, 8, 6) || lst[0].Key() != UserKey(1) || lst[1].Payload() != 60 {
+		t.Errorf("Bad dedup %v\n", lst)
+	}
+}
+
+func TestListRecordOptions(t *testing.T) {
+	s := NewStore(noGC())
+	o := ListOptions{Size: 15, Unique: true}
+	s.CreateList(SKey("l"), o)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+	tx := StartOTransaction(c.Workers[0])
+	for i := 0; i < 20; i++ {
+		tx.Reset()
+		tx.WriteList(SKey("l"), NewEntry(i, UserKey(uint64(i%17)), i), LIST)
+		if tx.Commit() == 0 {
+			t.Fatalf("Aborted\n")
+		}
+	}
+	br, _ := s.Get(SKey("l"))
+	lst := br.Value().([]Entry)
+	if len(lst) != 15 || lst[0].Order() != 19 || lst[14].Order() != 5 || lst[0].Payload() != 19 {
+		t.Errorf("Wrong list %v\n", lst)
+	}
+
+	if *SysType != DOPPEL {
+		return
+	}
+	// Split, the worker keeps a sorted, deduplicated list until the
+	// merge
+	s.cfg.AlwaysSplit = true
+	tx.SetPhase(SPLIT)
+	for _, x := range []int{30, 25, 40, 20} {
+		tx.Reset()
+		tx.WriteList(SKey("l"), NewEntry(x, UserKey(uint64(x%2)), x), LIST)
+		if tx.Commit() == 0 {
+			t.Fatalf("Aborted\n")
+		}
+	}
+	if l := tx.ls.lists[SKey("l")]; !sameOrders(l, 40, 25) {
+		t.Errorf("Bad local list %v\n", orders(l))
+	}
+	tx.ls.Merge()
+	br, _ = s.Get(SKey("l"))
+	lst = br.Value().([]Entry)
+	if len(lst) != 15 || lst[0].Order() != 40 || lst[1].Order() != 25 || lst[2].Order() != 19 {
+		t.Errorf("Wrong list after merge %v\n", orders(lst))
+	}
+	for i := range lst {
+		for j := i + 1; j < len(lst); j++ {
+			if lst[i].Key() == lst[j].Key() {
+				t.Errorf("Duplicate key %v\n", orders(lst))
+			}
+		}
+	}
+}
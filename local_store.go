@@ -13,9 +13,15 @@ type LocalStore struct {
 	padding0   [128]byte
 	sums       map[Key]int32
 	max        map[Key]int32
+	sums64     map[Key]int64
+	max64      map[Key]int64
+	fsums      map[Key]float64
+	fmax       map[Key]float64
 	bw         map[Key]Value
 	lists      map[Key][]Entry
+	lopts      map[Key]*ListOptions
 	oos        map[Key]Overwrite
+	merged     map[Key]localOp // Registered MergeOps
 	s          *Store
 	Ncopy      int64
 	candidates *Candidates
@@ -23,16 +29,20 @@ type LocalStore struct {
 }
 
 func NewLocalStore(s *Store) *LocalStore {
-	x := make([]*OneStat, 0)
-	sh := StatsHeap(x)
 	ls := &LocalStore{
 		sums:       make(map[Key]int32),
 		max:        make(map[Key]int32),
+		sums64:     make(map[Key]int64),
+		max64:      make(map[Key]int64),
+		fsums:      make(map[Key]float64),
+		fmax:       make(map[Key]float64),
 		bw:         make(map[Key]Value),
 		lists:      make(map[Key][]Entry),
+		lopts:      make(map[Key]*ListOptions),
 		oos:        make(map[Key]Overwrite),
+		merged:     make(map[Key]localOp),
 		s:          s,
-		candidates: &Candidates{make(map[Key]*OneStat), &sh},
+		candidates: newCandidates(&s.cfg),
 	}
 	return ls
 }
@@ -41,11 +51,13 @@ func (ls *LocalStore) ApplyList(key Key, entry Entry) {
 	l, ok := ls.lists[key]
 	if !ok {
 		l = make([]Entry, 0, 300)
-		ls.lists[key] = l
+		if br, err := ls.s.getKey(key, nil); err == nil {
+			ls.lopts[key] = br.lopts
+		}
 	}
-	// TODO: Use listApply or add one to list to keep them sorted;
-	// handle duplicates
-	ls.lists[key] = append(l, entry)
+	// Kept sorted and bounded like the record, so the merge only
+	// has to insert the survivors
+	ls.lists[key] = addToList(l, entry, ls.lopts[key])
 }
 
 func (ls *LocalStore) ApplyOO(key Key, a int32, v Value) {
@@ -67,11 +79,32 @@ func (ls *LocalStore) ApplyInt32(key Key, key_type KeyType, a int32, op KeyType)
 	}
 	switch op {
 	case SUM:
-		ls.sums[key] += a
+		ls.sums[key] = saturate32(ls.sums[key], a)
 	case MAX:
-		delta := a
-		if ls.max[key] < delta {
-			ls.max[key] = delta
+		if x, ok := ls.max[key]; !ok || x < a {
+			ls.max[key] = a
+		}
+	}
+}
+
+func (ls *LocalStore) ApplyInt64(key Key, a int64, op KeyType) {
+	switch op {
+	case SUM64:
+		ls.sums64[key] = saturate64(ls.sums64[key], a)
+	case MAX64:
+		if x, ok := ls.max64[key]; !ok || x < a {
+			ls.max64[key] = a
+		}
+	}
+}
+
+func (ls *LocalStore) ApplyFloat64(key Key, a float64, op KeyType) {
+	switch op {
+	case FSUM:
+		ls.fsums[key] += a
+	case FMAX:
+		if x, ok := ls.fmax[key]; !ok || x < a {
+			ls.fmax[key] = a
 		}
 	}
 }
@@ -83,11 +116,10 @@ func (ls *LocalStore) Apply(key Key, key_type KeyType, v Value, op KeyType) {
 	}
 	switch op {
 	case SUM:
-		ls.sums[key] += v.(int32)
+		ls.sums[key] = saturate32(ls.sums[key], v.(int32))
 	case MAX:
-		delta := v.(int32)
-		if ls.max[key] < delta {
-			ls.max[key] = delta
+		if x, ok := ls.max[key]; !ok || x < v.(int32) {
+			ls.max[key] = v.(int32)
 		}
 	case WRITE:
 		ls.bw[key] = v
@@ -96,12 +128,25 @@ func (ls *LocalStore) Apply(key Key, key_type KeyType, v Value, op KeyType) {
 		ls.ApplyOO(key, x.i, x.v)
 	case LIST:
 		ls.ApplyList(key, v.(Entry))
+	case SUM64, MAX64:
+		ls.ApplyInt64(key, v.(int64), op)
+	case FSUM, FMAX:
+		ls.ApplyFloat64(key, v.(float64), op)
+	default:
+		if m := mergeOp(op); m != nil {
+			x, ok := ls.merged[key]
+			if !ok {
+				x = localOp{op, m.Identity()}
+			}
+			x.v = m.Apply(x.v, v)
+			ls.merged[key] = x
+		}
 	}
 }
 
 func (ls *LocalStore) Merge() {
 	for k, v := range ls.sums {
-		if *SysType == OCC {
+		if ls.s.cfg.SysType == OCC {
 			debug.PrintStack()
 			log.Fatalf("Why is there derived data %v %v\n", k, v)
 		}
@@ -112,38 +157,40 @@ func (ls *LocalStore) Merge() {
 			continue
 		}
 		d := ls.s.getOrCreateTypedKey(k, int32(0), SUM)
+		ls.s.save(d)
 		d.Apply(v)
 		ls.sums[k] = 0
 		ls.Ncopy++
 	}
 
 	for k, v := range ls.max {
-		if *SysType == OCC {
+		if ls.s.cfg.SysType == OCC {
 			debug.PrintStack()
 			log.Fatalf("Why is there derived data %v %v\n", k, v)
 		}
 
-		if v == 0 {
-			continue
-		}
 		d := ls.s.getOrCreateTypedKey(k, int32(0), MAX)
+		ls.s.save(d)
 		d.Apply(v)
+		delete(ls.max, k)
 		ls.Ncopy++
 	}
 
 	for k, v := range ls.bw {
-		if *SysType == OCC {
+		if ls.s.cfg.SysType == OCC {
 			debug.PrintStack()
 			log.Fatalf("Why is there derived data %v %v\n", k, v)
 		}
 
 		d := ls.s.getOrCreateTypedKey(k, "", WRITE)
+		ls.s.save(d)
 		d.Apply(v)
+		delete(ls.bw, k)
 		ls.Ncopy++
 	}
 
 	for k, v := range ls.lists {
-		if *SysType == OCC {
+		if ls.s.cfg.SysType == OCC {
 			debug.PrintStack()
 			log.Fatalf("Why is there derived data %v %v\n", k, v)
 		}
@@ -152,19 +199,72 @@ func (ls *LocalStore) Merge() {
 		}
 
 		d := ls.s.getOrCreateTypedKey(k, nil, LIST)
+		ls.s.save(d)
 		d.Apply(v)
 		delete(ls.lists, k)
+		delete(ls.lopts, k)
 		ls.Ncopy++
 	}
 
 	for k, v := range ls.oos {
-		if *SysType == OCC {
+		if ls.s.cfg.SysType == OCC {
 			debug.PrintStack()
 			log.Fatalf("Why is there derived data %v %v\n", k, v)
 		}
 		d := ls.s.getOrCreateTypedKey(k, nil, OOWRITE)
+		ls.s.save(d)
 		d.Apply(v)
 		delete(ls.oos, k)
 		ls.Ncopy++
 	}
+
+	for k, v := range ls.sums64 {
+		if v == 0 {
+			continue
+		}
+		d := ls.s.getOrCreateTypedKey(k, int64(0), SUM64)
+		ls.s.save(d)
+		d.Apply(v)
+		ls.sums64[k] = 0
+		ls.Ncopy++
+	}
+
+	for k, v := range ls.max64 {
+		d := ls.s.getOrCreateTypedKey(k, v, MAX64)
+		ls.s.save(d)
+		d.Apply(v)
+		delete(ls.max64, k)
+		ls.Ncopy++
+	}
+
+	for k, v := range ls.fsums {
+		if v == 0 {
+			continue
+		}
+		d := ls.s.getOrCreateTypedKey(k, float64(0), FSUM)
+		ls.s.save(d)
+		d.Apply(v)
+		ls.fsums[k] = 0
+		ls.Ncopy++
+	}
+
+	for k, v := range ls.fmax {
+		d := ls.s.getOrCreateTypedKey(k, v, FMAX)
+		ls.s.save(d)
+		d.Apply(v)
+		delete(ls.fmax, k)
+		ls.Ncopy++
+	}
+
+	for k, x := range ls.merged {
+		if ls.s.cfg.SysType == OCC {
+			debug.PrintStack()
+			log.Fatalf("Why is there derived data %v %v\n", k, x.v)
+		}
+		d := ls.s.getOrCreateTypedKey(k, nil, x.kt)
+		ls.s.save(d)
+		d.Apply(x.v)
+		delete(ls.merged, k)
+		ls.Ncopy++
+	}
 }
@@ -0,0 +1,56 @@
+package ddtxn
+
+import (
+	"log"
+)
+
+// A MergeOp is a commutative operation applications can register as a
+// new KeyType, so records of that type can be split like SUM or MAX.
+// A record's value starts as Identity().  A write's value is a delta:
+// unsplit records get Apply(value, delta); split ones accumulate
+// deltas per core with Apply(local, delta), starting from Identity(),
+// and fold them into the record at the merge with Merge(global, local).
+// Writes to split records can happen in any order on any core, so
+// these must be commutative and associative.
+//
+// Records keep the values these return, and snapshots and SSI
+// versions share them, so they must not modify their arguments.
+type MergeOp interface {
+	Identity() Value
+	Apply(local, delta Value) Value
+	Merge(global, local Value) Value
+}
+
+var mergeOps []MergeOp
+
+// Make op a new KeyType.  Register before creating keys of the type,
+// and in the same order every run, since logs and checkpoints record
+// the KeyType.  Not threadsafe.
+func RegisterMergeOp(op MergeOp) KeyType {
+	if op == nil {
+		log.Fatalf("Registering a nil MergeOp\n")
+	}
+	mergeOps = append(mergeOps, op)
+	return NKEYTYPES + KeyType(len(mergeOps)-1)
+}
+
+// The MergeOp for kt, or nil if it is a builtin type.
+func mergeOp(kt KeyType) MergeOp {
+	if kt < NKEYTYPES || int(kt-NKEYTYPES) >= len(mergeOps) {
+		return nil
+	}
+	return mergeOps[kt-NKEYTYPES]
+}
+
+// Tombstones and records created without a value have nil values.
+func (br *BRecord) mergeValue(op MergeOp) Value {
+	if br.value == nil {
+		return op.Identity()
+	}
+	return br.value
+}
+
+type localOp struct {
+	kt KeyType
+	v  Value
+}
@@ -0,0 +1,77 @@
+package ddtxn
+
+import (
+	"math"
+	"testing"
+)
+
+type minOp struct{}
+
+func (minOp) Identity() Value { return int32(math.MaxInt32) }
+
+func (minOp) Apply(local, delta Value) Value {
+	if delta.(int32) < local.(int32) {
+		return delta
+	}
+	return local
+}
+
+func (m minOp) Merge(global, local Value) Value { return m.Apply(global, local) }
+
+type orOp struct{}
+
+func (orOp) Identity() Value                 { return uint64(0) }
+func (orOp) Apply(local, delta Value) Value  { return local.(uint64) | delta.(uint64) }
+func (orOp) Merge(global, local Value) Value { return global.(uint64) | local.(uint64) }
+
+func TestMergeOp(t *testing.T) {
+	MIN := RegisterMergeOp(minOp{})
+	OR := RegisterMergeOp(orOp{})
+	if mergeOp(SUM) != nil || mergeOp(MIN) == nil || MIN == OR {
+		t.Fatalf("Bad KeyTypes %v %v\n", MIN, OR)
+	}
+
+	s := NewStore(noGC())
+	s.CreateKey(ProductKey(1), nil, MIN)
+	s.CreateKey(ProductKey(2), uint64(1), OR)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+	tx := StartOTransaction(c.Workers[0])
+	tx.Reset()
+	tx.Write(ProductKey(1), int32(7), MIN)
+	tx.Write(ProductKey(2), uint64(4), OR)
+	tx.Write(ProductKey(3), uint64(2), OR)
+	if tx.Commit() == 0 {
+		t.Fatalf("Aborted\n")
+	}
+	for k, want := range map[Key]Value{ProductKey(1): int32(7), ProductKey(2): uint64(5), ProductKey(3): uint64(2)} {
+		if br, err := s.Get(k); err != nil || br.Value() != want {
+			t.Errorf("Wrong value for %v: %v %v\n", k, br, err)
+		}
+	}
+
+	if *SysType != DOPPEL {
+		return
+	}
+	// Split, the writes accumulate locally until the merge
+	s.cfg.AlwaysSplit = true
+	tx.SetPhase(SPLIT)
+	for _, x := range []int32{9, 3, 5} {
+		tx.Reset()
+		tx.Write(ProductKey(1), x, MIN)
+		tx.Write(ProductKey(2), uint64(x), OR)
+		if tx.Commit() == 0 {
+			t.Fatalf("Aborted\n")
+		}
+	}
+	if br, _ := s.Get(ProductKey(1)); br.Value() != int32(7) {
+		t.Errorf("Split write went to the store %v\n", br.Value())
+	}
+	tx.ls.Merge()
+	if br, _ := s.Get(ProductKey(1)); br.Value() != int32(3) {
+		t.Errorf("Wrong value after merge %v\n", br.Value())
+	}
+	if br, _ := s.Get(ProductKey(2)); br.Value() != uint64(15) {
+		t.Errorf("Wrong value after merge %v\n", br.Value())
+	}
+}
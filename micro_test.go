@@ -17,7 +17,7 @@ func BenchmarkMany(b *testing.B) {
 	nb := 10000
 	np := 100
 	n := 8
-	s := NewStore()
+	s := NewStore(FlagConfig())
 	// Load
 	for i := 0; i < np; i++ {
 		s.CreateKey(ProductKey(i), int32(0), SUM)
@@ -25,7 +25,7 @@ func BenchmarkMany(b *testing.B) {
 	for i := 0; i < nb; i++ {
 		s.CreateKey(UserKey(uint64(i)), "x", WRITE)
 	}
-	c := NewCoordinator(n, s)
+	c := NewCoordinator(n, s, BuiltinRegistry())
 	val := make([]int32, np)
 
 	var wg sync.WaitGroup
@@ -38,7 +38,7 @@ func BenchmarkMany(b *testing.B) {
 				p := ProductKey(i % np)
 				u := UserKey(uint64(i % nb))
 				amt := int32(rand.Intn(100))
-				tx := Query{TXN: D_BUY, K1: u, A: amt, K2: p, W: nil, T: 0}
+				tx := MicroQuery(D_BUY, u, p, amt)
 				_, err := w.One(tx)
 				if err == nil {
 					atomic.AddInt32(&val[i%np], amt)
@@ -60,7 +60,7 @@ func BenchmarkBuy(b *testing.B) {
 	nb := 10000
 	np := 100
 	n := 8
-	s := NewStore()
+	s := NewStore(FlagConfig())
 	// Load
 	for i := 0; i < np; i++ {
 		s.CreateKey(ProductKey(i), int32(0), MAX)
@@ -68,7 +68,7 @@ func BenchmarkBuy(b *testing.B) {
 	for i := 0; i < nb; i++ {
 		s.CreateKey(UserKey(uint64(i)), "x", WRITE)
 	}
-	c := NewCoordinator(n, s)
+	c := NewCoordinator(n, s, BuiltinRegistry())
 	val := make([]int32, np)
 
 	var wg sync.WaitGroup
@@ -81,7 +81,7 @@ func BenchmarkBuy(b *testing.B) {
 				p := ProductKey(i % np)
 				u := UserKey(uint64(i % nb))
 				amt := int32(rand.Intn(100))
-				tx := Query{TXN: D_BUY, K1: u, A: amt, K2: p, W: nil, T: 0}
+				tx := MicroQuery(D_BUY, u, p, amt)
 				_, err := w.One(tx)
 				if err == nil {
 					atomic.AddInt32(&val[i%np], amt)
@@ -106,7 +106,7 @@ func BenchmarkRead(b *testing.B) {
 	np := 100
 	n := 4
 
-	s := NewStore()
+	s := NewStore(FlagConfig())
 	// Load
 	for i := 0; i < np; i++ {
 		s.CreateKey(ProductKey(i), int32(0), SUM)
@@ -115,7 +115,7 @@ func BenchmarkRead(b *testing.B) {
 		s.CreateKey(UserKey(uint64(i)), "x", WRITE)
 	}
 
-	c := NewCoordinator(n, s)
+	c := NewCoordinator(n, s, BuiltinRegistry())
 	val := make([]int32, np)
 	read_rate := 50
 
@@ -132,16 +132,17 @@ func BenchmarkRead(b *testing.B) {
 				var tx Query
 				rr := rand.Intn(100)
 				if rr >= read_rate {
-					tx = Query{TXN: D_BUY, K1: u, K2: p, A: amt, W: nil, T: 0}
+					tx = MicroQuery(D_BUY, u, p, amt)
 					_, err := w.One(tx)
 					if err == nil {
 						atomic.AddInt32(&val[i%np], amt)
 					}
 				} else {
-					tx = Query{TXN: D_READ_ONE, K1: p, W: make(chan struct {
+					tx = MicroQuery(D_READ_ONE, p, Key{}, 0)
+					tx.W = make(chan struct {
 						R *Result
 						E error
-					}), T: 0}
+					})
 					_, err := w.One(tx)
 					if err == ESTASH {
 						dlog.Printf("client [%v] waiting for %v; epoch %v\n", w.ID, i%np, w.epoch)
@@ -160,7 +161,7 @@ func BenchmarkRead(b *testing.B) {
 }
 
 func BenchmarkList(b *testing.B) {
-	x := Entry{0, SKey("z"), 0}
+	x := Entry{order: 0, key: SKey("z")}
 	lr := MakeBR(SKey("x"), x, LIST)
 	v := make([]Entry, 1)
 	for i := 0; i < b.N; i++ {
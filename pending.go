@@ -0,0 +1,234 @@
+package ddtxn
+
+import (
+	"math"
+)
+
+// A transaction's pending write to one key.  Repeated writes to a key
+// in one transaction coalesce into one: sums add, maxes keep the
+// largest, WRITE keeps the last value and OOWRITE the one with the
+// largest order.  LIST entries and MergeOp deltas can't be folded
+// into one value, so the ones after the first wait in more.  A write
+// after a Delete() of the key replaces the delete, and resets the
+// record before it is applied.
+type pending struct {
+	op     KeyType
+	vint32 int32
+	v      Value
+	ve     Entry
+	more   []Value // Later LIST entries or MergeOp deltas
+	reset  bool    // Deleted before this write
+}
+
+// Make op the first write to the key.
+func (p *pending) set(op KeyType, a int32, v Value, e Entry) {
+	p.op = op
+	p.vint32 = a
+	p.v = v
+	p.ve = e
+	p.more = p.more[:0]
+	p.reset = false
+}
+
+// Fold a later write to k into p.  Returns ErrTypeMismatch if it isn't
+// the same type.
+func (p *pending) coalesce(k Key, op KeyType, a int32, v Value, e Entry) error {
+	if p.op == DELETE {
+		p.set(op, a, v, e)
+		p.reset = true
+		return nil
+	}
+	if op != p.op {
+		return typeMismatch(k, op, p.op)
+	}
+	switch op {
+	case SUM:
+		if addOverflows32(p.vint32, a) {
+			return EOVERFLOW
+		}
+		p.vint32 += a
+	case MAX:
+		if a > p.vint32 {
+			p.vint32 = a
+		}
+	case SUM64:
+		x := p.v.(int64)
+		if addOverflows64(x, v.(int64)) {
+			return EOVERFLOW
+		}
+		p.v = x + v.(int64)
+	case MAX64:
+		if v.(int64) > p.v.(int64) {
+			p.v = v
+		}
+	case FSUM:
+		x := p.v.(float64)
+		if addOverflowsFloat(x, v.(float64)) {
+			return EOVERFLOW
+		}
+		p.v = x + v.(float64)
+	case FMAX:
+		if v.(float64) > p.v.(float64) {
+			p.v = v
+		}
+	case WRITE:
+		p.v = v
+	case OOWRITE:
+		if a > p.vint32 {
+			p.vint32 = a
+			p.v = v
+		}
+	case LIST:
+		p.more = append(p.more, e)
+	default:
+		p.more = append(p.more, v)
+	}
+	return nil
+}
+
+// Add a write to k to p: the first if fresh, else folded into what p
+// already has.  Returns EOVERFLOW, leaving p alone, if a sum would
+// overflow by itself or when applied to base, k's record (nil if
+// there is nothing to check against), and ErrTypeMismatch if the
+// types differ.
+func (p *pending) write(k Key, fresh bool, base *BRecord, op KeyType, a int32, v Value, e Entry) error {
+	x := *p
+	if fresh {
+		x.set(op, a, v, e)
+	} else if err := x.coalesce(k, op, a, v, e); err != nil {
+		return err
+	}
+	if base != nil && !x.reset && base.overflows(x.op, x.value()) {
+		return EOVERFLOW
+	}
+	*p = x
+	return nil
+}
+
+// The value of a numeric write.
+func (p *pending) value() Value {
+	if p.op == SUM || p.op == MAX {
+		return p.vint32
+	}
+	return p.v
+}
+
+// A private copy of base (nil if k doesn't exist) with p applied, for
+// reading my own writes.
+func (p *pending) record(k Key, base *BRecord) *BRecord {
+	br := &BRecord{key: k, key_type: p.op, exists: true}
+	if base != nil {
+		br.lopts = base.lopts
+		if !base.deleted && !p.reset {
+			br.int_value = base.int_value
+			br.i64_value = base.i64_value
+			br.f64_value = base.f64_value
+			br.value = base.value
+			br.entries = append([]Entry(nil), base.entries...)
+		}
+	}
+	switch p.op {
+	case SUM:
+		br.int_value += p.vint32
+	case MAX:
+		if p.vint32 > br.int_value {
+			br.int_value = p.vint32
+		}
+	case SUM64:
+		br.i64_value += p.v.(int64)
+	case MAX64:
+		if x := p.v.(int64); x > br.i64_value {
+			br.i64_value = x
+		}
+	case FSUM:
+		br.f64_value = math.Float64bits(br.Float64() + p.v.(float64))
+	case FMAX:
+		if x := p.v.(float64); x > br.Float64() {
+			br.f64_value = math.Float64bits(x)
+		}
+	case WRITE:
+		br.value = p.v
+	case OOWRITE:
+		if p.v != nil && (p.vint32 > br.int_value || br.value == nil) {
+			br.int_value = p.vint32
+			br.value = p.v
+		}
+	case LIST:
+		br.entries = addToList(br.entries, p.ve, br.lopts)
+		for _, e := range p.more {
+			br.entries = addToList(br.entries, e.(Entry), br.lopts)
+		}
+	default:
+		if m := mergeOp(p.op); m != nil {
+			br.value = m.Apply(br.mergeValue(m), p.v)
+			for _, d := range p.more {
+				br.value = m.Apply(br.value, d)
+			}
+		}
+	}
+	return br
+}
+
+// Apply p to br in the store.  The caller holds br's lock, and has
+// checked with br.overflows() since taking it (or held it since the
+// write, as 2PL does), so the sums can't fail with EOVERFLOW.
+func (p *pending) commit(s *Store, br *BRecord) {
+	if p.reset {
+		s.Set(br, nil, DELETE)
+	}
+	switch p.op {
+	case SUM, MAX:
+		s.SetInt32(br, p.vint32, p.op)
+	case LIST:
+		s.SetList(br, p.ve, p.op)
+	case OOWRITE:
+		s.SetOO(br, p.vint32, p.v, p.op)
+	default:
+		s.Set(br, p.v, p.op)
+	}
+	for _, x := range p.more {
+		if p.op == LIST {
+			s.SetList(br, x.(Entry), p.op)
+		} else {
+			s.Set(br, x, p.op)
+		}
+	}
+}
+
+// Apply p to my per-core copy of the split record k.
+func (p *pending) local(ls *LocalStore, k Key) {
+	switch p.op {
+	case SUM, MAX:
+		ls.ApplyInt32(k, p.op, p.vint32, p.op)
+	case LIST:
+		ls.ApplyList(k, p.ve)
+	case OOWRITE:
+		ls.ApplyOO(k, p.vint32, p.v)
+	default:
+		ls.Apply(k, p.op, p.v, p.op)
+	}
+	for _, x := range p.more {
+		if p.op == LIST {
+			ls.ApplyList(k, x.(Entry))
+		} else {
+			ls.Apply(k, p.op, x, p.op)
+		}
+	}
+}
+
+// Append p, as writes to k, to a log record.
+func (p *pending) log(k Key, writes []logWrite) []logWrite {
+	if p.reset {
+		writes = append(writes, logWrite{K: k, Op: DELETE})
+	}
+	writes = append(writes, logWrite{K: k, Op: p.op, I: p.vint32, V: p.v, Order: p.ve.order, EKey: p.ve.key, Top: p.ve.top, EVal: p.ve.payload})
+	for _, x := range p.more {
+		if p.op == LIST {
+			e := x.(Entry)
+			writes = append(writes, logWrite{K: k, Op: p.op, Order: e.order, EKey: e.key, Top: e.top, EVal: e.payload})
+		} else {
+			writes = append(writes, logWrite{K: k, Op: p.op, V: x})
+		}
+	}
+	return writes
+}
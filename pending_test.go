@@ -0,0 +1,80 @@
+package ddtxn
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRepeatedWrites(t *testing.T) {
+	starts := map[string]func(*Worker) ETransaction{
+		"occ": func(w *Worker) ETransaction { return StartOTransaction(w) },
+		"2pl": func(w *Worker) ETransaction { return StartLTransaction(w) },
+		"ssi": func(w *Worker) ETransaction { return StartSTransaction(w) },
+	}
+	for name, start := range starts {
+		s := NewStore(noGC())
+		s.CreateKey(ProductKey(1), int32(5), SUM)
+		s.CreateKey(ProductKey(2), int32(3), MAX)
+		s.CreateKey(ProductKey(3), int32(math.MaxInt32-2), SUM)
+		s.CreateKey(UserKey(1), "a", WRITE)
+		s.CreateKey(UserKey(2), "b", WRITE)
+		s.CreateList(SKey("l"), ListOptions{Size: 3})
+		c := NewCoordinator(1, s, BuiltinRegistry())
+		tx := start(c.Workers[0])
+		tx.Reset()
+
+		tx.WriteInt32(ProductKey(1), 2, SUM)
+		tx.WriteInt32(ProductKey(1), 3, SUM)
+		br, err := tx.Read(ProductKey(1))
+		if err != nil || br.Value() != int32(10) {
+			t.Errorf("%v: Read my sums %v %v\n", name, br, err)
+		}
+		tx.WriteInt32(ProductKey(1), 1, SUM)
+		if br.Value() != int32(10) {
+			t.Errorf("%v: Record I read changed %v\n", name, br.Value())
+		}
+		tx.WriteInt32(ProductKey(2), 7, MAX)
+		tx.WriteInt32(ProductKey(2), 4, MAX)
+		tx.WriteInt32(ProductKey(3), 1, SUM)
+		if err := tx.WriteInt32(ProductKey(3), 1, SUM); err != nil {
+			t.Errorf("%v: Sum to the max %v\n", name, err)
+		}
+		if err := tx.WriteInt32(ProductKey(3), 1, SUM); err != EOVERFLOW {
+			t.Errorf("%v: Expected EOVERFLOW, got %v\n", name, err)
+		}
+		tx.Write(UserKey(1), "b", WRITE)
+		tx.Write(UserKey(1), "c", WRITE)
+		if err := tx.Delete(UserKey(2)); err != nil {
+			t.Fatalf("%v: Delete %v\n", name, err)
+		}
+		tx.Write(UserKey(2), "z", WRITE)
+		for _, x := range []int{4, 9, 1, 6} {
+			tx.WriteList(SKey("l"), NewEntry(x, UserKey(uint64(x)), nil), LIST)
+		}
+		br, err = tx.Read(SKey("l"))
+		if err != nil || !sameOrders(br.entries, 9, 6, 4) {
+			t.Errorf("%v: Read my list %v %v\n", name, br, err)
+		}
+		for k, want := range map[Key]Value{ProductKey(1): int32(11), ProductKey(2): int32(7), UserKey(1): "c", UserKey(2): "z"} {
+			if br, err := tx.Read(k); err != nil || br.Value() != want {
+				t.Errorf("%v: Read my write to %v %v %v\n", name, k, br, err)
+			}
+		}
+		if ot, ok := tx.(*OTransaction); ok && len(ot.writes) != 6 {
+			t.Errorf("%v: Writes not coalesced %v\n", name, len(ot.writes))
+		}
+		if tx.Commit() == 0 {
+			t.Fatalf("%v: Aborted\n", name)
+		}
+
+		for k, want := range map[Key]Value{ProductKey(1): int32(11), ProductKey(2): int32(7), ProductKey(3): int32(math.MaxInt32), UserKey(1): "c", UserKey(2): "z"} {
+			if br, err := s.Get(k); err != nil || br.Value() != want {
+				t.Errorf("%v: Wrong value for %v %v %v\n", name, k, br, err)
+			}
+		}
+		if br, _ := s.Get(SKey("l")); !sameOrders(br.entries, 9, 6, 4) {
+			t.Errorf("%v: Wrong list %v\n", name, orders(br.entries))
+		}
+		c.Finish()
+	}
+}
@@ -0,0 +1,69 @@
+package ddtxn
+
+import (
+	"flag"
+	"time"
+)
+
+// Flags for FlagConfig()
+var AdaptivePhase = flag.Bool("adaptive", false, "Adapt the phase length to stashed transactions' latency and the cost of merging\n")
+var MinPhase = flag.Int("minphase", 2, "Shortest phase in milliseconds, with -adaptive\n")
+var MaxPhase = flag.Int("maxphase", 200, "Longest phase in milliseconds, with -adaptive\n")
+var StashLatency = flag.Int("stashlat", 50, "Milliseconds a stashed transaction should wait at most, with -adaptive\n")
+var MergeCost = flag.Float64("mergecost", 0.1, "Fraction of the time the workers should spend reconciling, with -adaptive\n")
+
+// What one phase change measured.
+type PhaseStats struct {
+	Length  time.Duration // Of the split phase that ended
+	Stashed int           // Most transactions stashed on one worker
+	Latency time.Duration // From the oldest stash until the stashed transactions ran
+	Cost    time.Duration // Merging, running stashed transactions and restarting the workers
+}
+
+// Picks the length of the next split phase from what the last phase
+// change measured.  Stashed transactions wait out the rest of the
+// phase, so phases get shorter while they wait longer than
+// Config.StashLatency or pile up; otherwise they get longer while
+// reconciling takes more than Config.MergeCost of the time, or while
+// there's latency to spare.
+type phaseController struct {
+	cfg    *Config
+	length time.Duration
+}
+
+func newPhaseController(cfg *Config) *phaseController {
+	p := &phaseController{cfg: cfg}
+	p.length = p.clamp(cfg.PhaseLength)
+	return p
+}
+
+func (p *phaseController) clamp(l time.Duration) time.Duration {
+	if l < p.cfg.MinPhase {
+		return p.cfg.MinPhase
+	}
+	if l > p.cfg.MaxPhase {
+		return p.cfg.MaxPhase
+	}
+	return l
+}
+
+func (p *phaseController) next(st PhaseStats) time.Duration {
+	l := p.length
+	switch {
+	case st.Stashed > 0 && st.Latency > p.cfg.StashLatency:
+		// Shrink by how far over we are, but not below half at once
+		f := float64(p.cfg.StashLatency) / float64(st.Latency)
+		if f < 0.5 {
+			f = 0.5
+		}
+		l = time.Duration(float64(l) * f)
+	case st.Stashed > p.cfg.TriggerCount/2:
+		l = l * 3 / 4
+	case float64(st.Cost) > p.cfg.MergeCost*float64(st.Length+st.Cost):
+		l = l * 2
+	case st.Stashed == 0 || st.Latency < p.cfg.StashLatency/2:
+		l = l * 5 / 4
+	}
+	p.length = p.clamp(l)
+	return p.length
+}
@@ -0,0 +1,209 @@
+package ddtxn
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPhaseController(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PhaseLength = 20 * time.Millisecond
+	cfg.TriggerCount = 100
+	p := newPhaseController(&cfg)
+	ms := time.Millisecond
+
+	// Stashed transactions wait too long
+	if l := p.next(PhaseStats{Length: 20 * ms, Stashed: 10, Latency: 120 * ms, Cost: ms}); l != 10*ms {
+		t.Errorf("Expected to halve, got %v\n", l)
+	}
+	if l := p.next(PhaseStats{Length: 10 * ms, Stashed: 10, Latency: 60 * ms, Cost: ms}); l < 8*ms || l > 9*ms {
+		t.Errorf("Expected to shrink to fit the target, got %v\n", l)
+	}
+	p.length = 20 * ms
+	// Too many stashed
+	if l := p.next(PhaseStats{Length: 20 * ms, Stashed: 60, Latency: 30 * ms, Cost: ms}); l != 15*ms {
+		t.Errorf("Expected to shrink, got %v\n", l)
+	}
+	// Merging costs too much
+	if l := p.next(PhaseStats{Length: 15 * ms, Stashed: 10, Latency: 30 * ms, Cost: 5 * ms}); l != 30*ms {
+		t.Errorf("Expected to double, got %v\n", l)
+	}
+	// Nothing stashed
+	if l := p.next(PhaseStats{Length: 30 * ms, Cost: ms}); l != 37500*time.Microsecond {
+		t.Errorf("Expected to grow, got %v\n", l)
+	}
+	// Within targets
+	if l := p.next(PhaseStats{Length: 37 * ms, Stashed: 10, Latency: 40 * ms, Cost: ms}); l != 37500*time.Microsecond {
+		t.Errorf("Expected to stay, got %v\n", l)
+	}
+
+	for i := 0; i < 20; i++ {
+		p.next(PhaseStats{Length: p.length, Cost: p.length})
+	}
+	if p.length != cfg.MaxPhase {
+		t.Errorf("Expected %v, got %v\n", cfg.MaxPhase, p.length)
+	}
+	for i := 0; i < 20; i++ {
+		p.next(PhaseStats{Length: p.length, Stashed: 1, Latency: time.Second})
+	}
+	if p.length != cfg.MinPhase {
+		t.Errorf("Expected %v, got %v\n", cfg.MinPhase, p.length)
+	}
+}
+
+func TestAdaptivePhase(t *testing.T) {
+	if *SysType != DOPPEL {
+		t.Skip("Phases only happen in Doppel")
+	}
+	cfg := noGC()
+	cfg.AlwaysSplit = true
+	cfg.AdaptivePhase = true
+	cfg.PhaseLength = 2 * time.Millisecond
+	cfg.MaxPhase = 8 * time.Millisecond
+	s := NewStore(cfg)
+	s.CreateKey(ProductKey(4), int32(0), SUM)
+	c := NewCoordinator(2, s, BuiltinRegistry())
+	defer c.Finish()
+
+	// Nothing is stashed, so phases get longer
+	deadline := time.Now().Add(10 * time.Second)
+	for c.PhaseLength() != cfg.MaxPhase && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if c.PhaseLength() != cfg.MaxPhase {
+		t.Errorf("Expected phases to reach %v, got %v\n", cfg.MaxPhase, c.PhaseLength())
+	}
+}
+
+func TestSpinBarrier(t *testing.T) {
+	if *SysType != DOPPEL {
+		t.Skip("Phases only happen in Doppel")
+	}
+	for _, spin := range []bool{false, true} {
+		cfg := noGC()
+		cfg.AlwaysSplit = true
+		cfg.SpinBarrier = spin
+		cfg.PhaseLength = time.Millisecond
+		s := NewStore(cfg)
+		s.CreateKey(ProductKey(4), int32(0), SUM)
+		c := NewCoordinator(4, s, BuiltinRegistry())
+		e := c.GetEpoch()
+		var wg sync.WaitGroup
+		for _, w := range c.Workers {
+			wg.Add(1)
+			go func(w *Worker) {
+				defer wg.Done()
+				s.CreateKey(UserKey(uint64(w.ID)), int32(0), SUM)
+				q := MicroQuery(D_BUY, UserKey(uint64(w.ID)), ProductKey(4), 1)
+				for i := 0; i < 1000; i++ {
+					if _, err := w.One(q); err != nil {
+						t.Errorf("%v: Buy %v\n", spin, err)
+						return
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		for c.GetEpoch() < e+3*EPOCH_INCR {
+			time.Sleep(time.Millisecond)
+		}
+		c.Finish()
+		if br, _ := s.Get(ProductKey(4)); br.Value() != int32(4000) {
+			t.Errorf("%v: Wrong value %v\n", spin, br.Value())
+		}
+		for _, w := range c.Workers {
+			if w.epoch != c.GetEpoch() {
+				t.Errorf("%v: Worker %v at %v, coordinator at %v\n", spin, w.ID, w.epoch, c.GetEpoch())
+			}
+		}
+	}
+}
+
+func TestStraggler(t *testing.T) {
+	if *SysType != DOPPEL {
+		t.Skip("Phases only happen in Doppel")
+	}
+	for _, spin := range []bool{false, true} {
+		cfg := noGC()
+		cfg.AlwaysSplit = true
+		cfg.SpinBarrier = spin
+		cfg.PhaseLength = time.Hour
+		cfg.StragglerTimeout = time.Millisecond
+		s := NewStore(cfg)
+		s.CreateKey(ProductKey(4), int32(0), SUM)
+		s.CreateKey(UserKey(1), int32(0), SUM)
+		c := NewCoordinator(2, s, BuiltinRegistry())
+		w0, w := c.Workers[0], c.Workers[1]
+		q := MicroQuery(D_BUY, UserKey(1), ProductKey(4), 5)
+		if _, err := w.One(q); err != nil {
+			t.Fatalf("%v: Buy %v\n", spin, err)
+		}
+		read := MicroQuery(D_READ_ONE, ProductKey(4), Key{}, 0)
+		read.W = make(chan struct {
+			R *Result
+			E error
+		}, 1)
+		if _, err := w.One(read); err != ESTASH {
+			t.Fatalf("%v: Expected ESTASH, got %v\n", spin, err)
+		}
+
+		// Stop both run()s: worker 1 never notices the phase change,
+		// and worker 0 notices when I say so.
+		w0.done <- true
+		w.done <- true
+		e := c.GetEpoch()
+		// Nobody can merge for worker 0 while I hold its read lock,
+		// or once it's waiting for its lock.
+		w0.RLock()
+		incremented := make(chan bool)
+		go func() {
+			c.IncrementEpoch(true)
+			incremented <- true
+		}()
+		for c.GetEpoch() == e {
+			runtime.Gosched()
+		}
+		go func() {
+			if err := w0.transition(); err != nil {
+				t.Errorf("%v: Transition %v\n", spin, err)
+			}
+		}()
+		for w0.TryRLock() {
+			w0.RUnlock()
+			runtime.Gosched()
+		}
+		w0.RUnlock()
+		<-incremented
+		if w.Ncounters[NPROXIED] != 1 || w.epoch != c.GetEpoch() {
+			t.Errorf("%v: Not merged for; %v %v %v\n", spin, w.Ncounters[NPROXIED], w.epoch, c.GetEpoch())
+		}
+		if c.Workers[0].Ncounters[NPROXIED] != 0 {
+			t.Errorf("%v: Merged for a worker that noticed\n", spin)
+		}
+		select {
+		case x := <-read.W:
+			if x.E != nil || x.R.V.(int32) != 5 {
+				t.Errorf("%v: Stashed read %v %v\n", spin, x.R, x.E)
+			}
+		default:
+			t.Errorf("%v: Stashed read never answered\n", spin)
+		}
+		if h := c.Noticed(); h.GetPercentile(100) < cfg.StragglerTimeout.Nanoseconds()/1000 {
+			t.Errorf("%v: Wrong notice latency %v\n", spin, h.GetPercentile(100))
+		}
+
+		// Works normally again
+		c.wg.Add(2)
+		go w0.run()
+		go w.run()
+		if _, err := w.One(q); err != nil {
+			t.Fatalf("%v: Buy %v\n", spin, err)
+		}
+		c.Finish()
+		if br, _ := s.Get(ProductKey(4)); br.Value() != int32(10) {
+			t.Errorf("%v: Wrong value %v\n", spin, br.Value())
+		}
+	}
+}
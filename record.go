@@ -2,15 +2,18 @@ package ddtxn
 
 import (
 	"flag"
-	"log"
+	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"unsafe"
 
 	"github.com/narula/dlog"
 	"github.com/narula/ddtxn/spinlock"
 	"github.com/narula/wfmutex"
 )
 
+// Flags for FlagConfig()
 var Conflicts = flag.Bool("conflicts", false, "Measure conflicts\n")
 var Spinlock = flag.Bool("spinlock", false, "Use spinlocks for 2PL\n")
 
@@ -22,6 +25,12 @@ const (
 	WRITE
 	LIST
 	OOWRITE
+	DELETE    // Not a record type; the op of a write which deletes its key
+	SUM64     // int64 sum
+	MAX64     // int64 max
+	FSUM      // float64 sum
+	FMAX      // float64 max
+	NKEYTYPES // Registered MergeOps get KeyTypes from here on; see merge.go
 )
 
 type Overwrite struct {
@@ -33,14 +42,23 @@ type BRecord struct {
 	key       Key
 	key_type  KeyType
 	int_value int32
-	dd        bool
+	dd        int32  // 1 if split; see isDD()
+	i64_value int64  // SUM64, MAX64
+	f64_value uint64 // FSUM, FMAX; math.Float64bits()
 	last      wfmutex.WFMutex
 	lock      spinlock.RWSpinlock
 	value     Value
 	entries   []Entry
 	mu        sync.RWMutex
 	conflict  int32 // how many times was the lock already held when someone wanted it
+	spin      bool  // Config.Spinlock
+	measure   bool  // Config.Conflicts; count conflicts
 	exists    bool
+	deleted   bool           // Tombstone; removed from the store by Coordinator.collect()
+	snap      unsafe.Pointer // *version; see snapshot.go
+	versions  unsafe.Pointer // *mvVersion; see ssi.go
+	rts       uint64         // Latest SSI commit which read it
+	lopts     *ListOptions
 	padding1  [128]byte
 }
 
@@ -79,12 +97,27 @@ func MakeBR(k Key, val Value, kt KeyType) *BRecord {
 			b.entries = make([]Entry, 1)
 			b.entries[0] = val.(Entry)
 		}
+	case SUM64, MAX64:
+		if val != nil {
+			b.i64_value = val.(int64)
+		}
+	case FSUM, FMAX:
+		if val != nil {
+			b.f64_value = math.Float64bits(val.(float64))
+		}
+	default:
+		if op := mergeOp(kt); op != nil {
+			if val == nil {
+				val = op.Identity()
+			}
+			b.value = val
+		}
 	}
 	return b
 }
 
 func (br *BRecord) SLock() {
-	if *Spinlock {
+	if br.spin {
 		br.lock.Lock()
 	} else {
 		br.mu.Lock()
@@ -92,7 +125,7 @@ func (br *BRecord) SLock() {
 }
 
 func (br *BRecord) SUnlock() {
-	if *Spinlock {
+	if br.spin {
 		br.lock.Unlock()
 	} else {
 		br.mu.Unlock()
@@ -100,7 +133,7 @@ func (br *BRecord) SUnlock() {
 }
 
 func (br *BRecord) SRLock() {
-	if *Spinlock {
+	if br.spin {
 		br.lock.RLock()
 	} else {
 		br.mu.RLock()
@@ -108,7 +141,7 @@ func (br *BRecord) SRLock() {
 }
 
 func (br *BRecord) SRUnlock() {
-	if *Spinlock {
+	if br.spin {
 		br.lock.RUnlock()
 	} else {
 		br.mu.RUnlock()
@@ -127,16 +160,24 @@ func (br *BRecord) Value() Value {
 		return br.entries
 	case OOWRITE:
 		if br.value == nil {
-			log.Fatalf("How %v\n", br.key)
+			// Nothing written yet
+			return nil
 		}
 		return Overwrite{v: br.value, i: br.int_value}
+	case SUM64, MAX64:
+		return br.i64_value
+	case FSUM, FMAX:
+		return br.Float64()
+	}
+	if op := mergeOp(br.key_type); op != nil {
+		return br.mergeValue(op)
 	}
 	return nil
 }
 
 func (br *BRecord) Lock() (bool, uint64) {
 	x, last := br.last.Lock()
-	if *Conflicts {
+	if br.measure {
 		if !x {
 			atomic.AddInt32(&br.conflict, 1)
 		}
@@ -151,7 +192,7 @@ func (br *BRecord) Unlock(tid TID) {
 func (br *BRecord) IsUnlocked() (bool, uint64) {
 	x := br.last.Read()
 	if x&wfmutex.LOCKED != 0 {
-		if *Conflicts {
+		if br.measure {
 			// warning!  turning a read-only thing into a read/write!
 			atomic.AddInt32(&br.conflict, 1)
 		}
@@ -166,7 +207,7 @@ func (br *BRecord) Verify(last uint64) bool {
 		return false
 	}
 	if uint64(new_last) != last {
-		if *Conflicts {
+		if br.measure {
 			atomic.AddInt32(&br.conflict, 1)
 		}
 		return false
@@ -181,7 +222,7 @@ func (br *BRecord) Own(last uint64) bool {
 		return false
 	}
 	if uint64(new_last) != wfmutex.LOCKED|last {
-		if *Conflicts {
+		if br.measure {
 			atomic.AddInt32(&br.conflict, 1)
 		}
 		return false
@@ -189,107 +230,270 @@ func (br *BRecord) Own(last uint64) bool {
 	return true
 }
 
-// Used during "merge" phase, along with br.mu
+func (br *BRecord) Float64() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&br.f64_value))
+}
+
+// Whether the coordinator split me.  It changes only at phase
+// changes, but workers read it all the time.
+func (br *BRecord) isDD() bool {
+	return atomic.LoadInt32(&br.dd) != 0
+}
+
+func (br *BRecord) setDD(dd bool) {
+	var x int32
+	if dd {
+		x = 1
+	}
+	atomic.StoreInt32(&br.dd, x)
+}
+
+// Whether x+a overflows.
+func addOverflows32(x, a int32) bool {
+	y := x + a
+	return (a > 0 && y < x) || (a < 0 && y > x)
+}
+
+func addOverflows64(x, a int64) bool {
+	y := x + a
+	return (a > 0 && y < x) || (a < 0 && y > x)
+}
+
+func addOverflowsFloat(x, a float64) bool {
+	return math.IsInf(x+a, 0) && !math.IsInf(x, 0) && !math.IsInf(a, 0)
+}
+
+// Whether adding v to br with op would overflow.
+func (br *BRecord) overflows(op KeyType, v Value) bool {
+	if br.deleted {
+		return false
+	}
+	switch op {
+	case SUM:
+		return addOverflows32(br.int_value, v.(int32))
+	case SUM64:
+		return addOverflows64(br.i64_value, v.(int64))
+	case FSUM:
+		return addOverflowsFloat(br.Float64(), v.(float64))
+	}
+	return false
+}
+
+// Merges can't return errors, so clamp instead of wrapping.
+func saturate32(x, a int32) int32 {
+	if !addOverflows32(x, a) {
+		return x + a
+	}
+	if a > 0 {
+		return math.MaxInt32
+	}
+	return math.MinInt32
+}
+
+func saturate64(x, a int64) int64 {
+	if !addOverflows64(x, a) {
+		return x + a
+	}
+	if a > 0 {
+		return math.MaxInt64
+	}
+	return math.MinInt64
+}
+
+// Used during "merge" phase, along with SLock()
+// Turn br into a tombstone.
+func (br *BRecord) remove() {
+	br.deleted = true
+	br.int_value = 0
+	br.i64_value = 0
+	br.f64_value = 0
+	br.value = nil
+	br.entries = make([]Entry, 0)
+}
+
+// Writing to a tombstone starts over from an empty record.  Merges
+// only apply what was written since the last one, so a split key
+// deleted in the join phase only comes back if it's written again.
+func (br *BRecord) revive() {
+	if br.deleted {
+		br.deleted = false
+	}
+}
+
 func (br *BRecord) Apply(val Value) {
 	if br == nil {
 		dlog.Printf("Nil record %v %v\n", val, br)
 	}
+	br.revive()
 	switch br.key_type {
 	case SUM:
 		delta := val.(int32)
-		atomic.AddInt32(&br.int_value, delta)
+		for {
+			x := atomic.LoadInt32(&br.int_value)
+			if atomic.CompareAndSwapInt32(&br.int_value, x, saturate32(x, delta)) {
+				break
+			}
+		}
+	case SUM64:
+		delta := val.(int64)
+		for {
+			x := atomic.LoadInt64(&br.i64_value)
+			if atomic.CompareAndSwapInt64(&br.i64_value, x, saturate64(x, delta)) {
+				break
+			}
+		}
+	case MAX64:
+		delta := val.(int64)
+		for {
+			x := atomic.LoadInt64(&br.i64_value)
+			if x >= delta || atomic.CompareAndSwapInt64(&br.i64_value, x, delta) {
+				break
+			}
+		}
+	case FSUM, FMAX:
+		delta := val.(float64)
+		for {
+			x := atomic.LoadUint64(&br.f64_value)
+			f := math.Float64frombits(x)
+			var y float64
+			if br.key_type == FSUM {
+				y = f + delta
+			} else if f < delta {
+				y = delta
+			} else {
+				break
+			}
+			if atomic.CompareAndSwapUint64(&br.f64_value, x, math.Float64bits(y)) {
+				break
+			}
+		}
 	case MAX:
 		delta := val.(int32)
-		br.mu.Lock()
-		defer br.mu.Unlock()
+		br.SLock()
+		defer br.SUnlock()
 		if br.int_value < delta {
 			br.int_value = delta
 		}
 	case WRITE:
-		br.mu.Lock()
-		defer br.mu.Unlock()
+		br.SLock()
+		defer br.SUnlock()
 		br.value = val
 	case LIST:
-		br.mu.Lock()
-		defer br.mu.Unlock()
+		br.SLock()
+		defer br.SUnlock()
 		entries := val.([]Entry)
 		br.listApply(entries)
 	case OOWRITE:
-		br.mu.Lock()
-		defer br.mu.Unlock()
+		br.SLock()
+		defer br.SUnlock()
 		x := val.(Overwrite)
 		if br.int_value < x.i {
 			br.int_value = x.i
 			br.value = x.v
 		}
+	default:
+		if op := mergeOp(br.key_type); op != nil {
+			br.SLock()
+			defer br.SUnlock()
+			br.value = op.Merge(br.mergeValue(op), val)
+		}
 	}
 }
 
 type Entry struct {
-	order int
-	key   Key
-	top   int
+	order   int
+	key     Key
+	top     int
+	payload Value
+}
+
+func NewEntry(order int, key Key, payload Value) Entry {
+	return Entry{order: order, key: key, payload: payload}
+}
+
+func (e Entry) Order() int {
+	return e.order
+}
+
+func (e Entry) Key() Key {
+	return e.key
+}
+
+func (e Entry) Payload() Value {
+	return e.payload
 }
 
 const (
 	DEFAULT_LIST_SIZE = 10
 )
 
+// How a LIST record keeps its entries; see Store.CreateList().  The
+// zero value is the default: the DEFAULT_LIST_SIZE entries with the
+// largest orders.
+type ListOptions struct {
+	Size      int  // Most entries kept; DEFAULT_LIST_SIZE if 0
+	Ascending bool // Keep the smallest orders instead
+	Unique    bool // At most one entry per Entry key, the best ordered
+}
+
+func (o *ListOptions) size() int {
+	if o == nil || o.Size == 0 {
+		return DEFAULT_LIST_SIZE
+	}
+	return o.Size
+}
+
+// Whether a goes before b.  Equal orders keep the order they arrived
+// in.
+func (o *ListOptions) before(a, b Entry) bool {
+	if o != nil && o.Ascending {
+		return a.order < b.order
+	}
+	return a.order > b.order
+}
+
 func (br *BRecord) AddOneToRecord(e Entry) {
-	br.entries = AddOneToList(br.entries, e)
+	br.entries = addToList(br.entries, e, br.lopts)
 }
 
 func AddOneToList(lst []Entry, e Entry) []Entry {
-	added := false
-	for i := 0; i < len(lst); i++ {
-		if lst[i].order < e.order {
-			lst := append(lst, Entry{})
-			copy(lst[i+1:], lst[i:])
-			lst[i] = e
-			added = true
-			break
+	return addToList(lst, e, nil)
+}
+
+// Insert e into lst, which is sorted according to o.  Modifies lst.
+func addToList(lst []Entry, e Entry, o *ListOptions) []Entry {
+	if o != nil && o.Unique {
+		for i := range lst {
+			if lst[i].key == e.key {
+				if !o.before(e, lst[i]) {
+					return lst
+				}
+				lst = append(lst[:i], lst[i+1:]...)
+				break
+			}
 		}
 	}
-	if added {
-		if len(lst) <= DEFAULT_LIST_SIZE {
-			//
-		} else {
-			lst = lst[:DEFAULT_LIST_SIZE]
-		}
-	} else if len(lst) < DEFAULT_LIST_SIZE {
-		// This goes at the end
-		lst = append(lst, e)
-	} else {
-		lst = lst[:DEFAULT_LIST_SIZE]
+	i := sort.Search(len(lst), func(i int) bool {
+		return o.before(e, lst[i])
+	})
+	if i >= o.size() {
+		return lst
 	}
-	if len(lst) > DEFAULT_LIST_SIZE {
-		log.Fatalf("How did this happen?  %v %v %v\n", e, lst, lst[:DEFAULT_LIST_SIZE])
+	lst = append(lst, Entry{})
+	copy(lst[i+1:], lst[i:])
+	lst[i] = e
+	if len(lst) > o.size() {
+		lst = lst[:o.size()]
 	}
 	return lst
 }
 
-// default desc
+// Merge a worker's sorted entries into br.
 func (br *BRecord) listApply(entries []Entry) {
-	lidx := 0
-	widx := 0
-	i := 0
-	new_entries := make([]Entry, DEFAULT_LIST_SIZE)
-	for i < DEFAULT_LIST_SIZE {
-		if lidx < len(br.entries) && widx < len(entries) && br.entries[lidx].order > entries[widx].order {
-			new_entries[i] = br.entries[lidx]
-			lidx++
-			i++
-		} else if widx < len(entries) {
-			new_entries[i] = entries[widx]
-			widx++
-			i++
-		} else if lidx < len(br.entries) {
-			new_entries[i] = br.entries[lidx]
-			widx++
-			i++
-		} else {
-			break
-		}
+	lst := make([]Entry, len(br.entries), len(br.entries)+len(entries))
+	copy(lst, br.entries)
+	for _, e := range entries {
+		lst = addToList(lst, e, br.lopts)
 	}
-	br.entries = new_entries[:i]
+	br.entries = lst
 }
@@ -0,0 +1,143 @@
+package ddtxn
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+var (
+	// Register() was given a name that's already taken.
+	ErrRegistered = errors.New("doppel: transaction already registered")
+	// A Schema declared an argument twice, or too many of a type.
+	ErrSchema = errors.New("doppel: bad schema")
+)
+
+// A Registry maps transaction names to TransactionFuncs.  Each
+// registered transaction gets an ID, which goes in Query.TXN.  Build
+// the registry at startup and hand it to NewCoordinator(); workers
+// don't see transactions registered after that.
+type Registry struct {
+	names   []string
+	fns     []TransactionFunc
+	schemas []*Schema
+	ids     map[string]int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names:   make([]string, 0),
+		fns:     make([]TransactionFunc, 0),
+		schemas: make([]*Schema, 0),
+		ids:     make(map[string]int),
+	}
+}
+
+// Register fn under name and return its ID.  Returns ErrRegistered if
+// name is taken.
+func (r *Registry) Register(name string, fn TransactionFunc) (int, error) {
+	if _, ok := r.ids[name]; ok {
+		return -1, fmt.Errorf("%w: %v", ErrRegistered, name)
+	}
+	id := len(r.fns)
+	r.names = append(r.names, name)
+	r.fns = append(r.fns, fn)
+	r.schemas = append(r.schemas, nil)
+	r.ids[name] = id
+	return id, nil
+}
+
+// Like Register(), but exits if name is taken; for registries built
+// once at startup.
+func (r *Registry) MustRegister(name string, fn TransactionFunc) int {
+	id, err := r.Register(name, fn)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	return id
+}
+
+// Describe the arguments of transaction id.  Returns s.Err(), without
+// registering s, if s was declared wrong.
+func (r *Registry) RegisterSchema(id int, s *Schema) error {
+	if err := s.Err(); err != nil {
+		return err
+	}
+	r.schemas[id] = s
+	return nil
+}
+
+func (r *Registry) Lookup(name string) (int, bool) {
+	id, ok := r.ids[name]
+	return id, ok
+}
+
+func (r *Registry) Name(id int) string {
+	if id < 0 || id >= len(r.names) {
+		return fmt.Sprintf("txn%v", id)
+	}
+	return r.names[id]
+}
+
+func (r *Registry) Func(id int) TransactionFunc {
+	return r.fns[id]
+}
+
+// The schema of transaction id, or nil.
+func (r *Registry) Schema(id int) *Schema {
+	if id < 0 || id >= len(r.schemas) {
+		return nil
+	}
+	return r.schemas[id]
+}
+
+func (r *Registry) Len() int {
+	return len(r.fns)
+}
+
+// Describe q using its transaction's schema, for debugging.
+func (r *Registry) Format(q *Query) string {
+	s := r.Schema(q.TXN)
+	if s == nil {
+		return fmt.Sprintf("%v(%v)", r.Name(q.TXN), q.Args)
+	}
+	return s.Format(q)
+}
+
+func (r *Registry) mustRegister(id int, name string, fn TransactionFunc, s *Schema) {
+	if x := r.MustRegister(name, fn); x != id {
+		log.Fatalf("Registered %v as %v, expected %v\n", name, x, id)
+	}
+	if s != nil {
+		if err := r.RegisterSchema(id, s); err != nil {
+			log.Fatalf("%v\n", err)
+		}
+	}
+}
+
+// A registry with the microbenchmark, RUBiS and big transactions,
+// with the IDs D_BUY...BIG_RW.
+func BuiltinRegistry() *Registry {
+	r := NewRegistry()
+	r.mustRegister(D_BUY, "buy", BuyTxn, MicroSchema)
+	r.mustRegister(D_BUY_AND_READ, "buy_and_read", BuyAndReadTxn, MicroSchema)
+	r.mustRegister(D_READ_ONE, "read_one", ReadOneTxn, MicroSchema)
+	r.mustRegister(D_READ_TWO, "read_two", ReadTxn, MicroSchema)
+	r.mustRegister(D_INCR_ONE, "incr_one", IncrTxn, MicroSchema)
+	r.mustRegister(D_ATOMIC_INCR_ONE, "atomic_incr_one", AtomicIncr, MicroSchema)
+	r.mustRegister(RUBIS_BID, "rubis_bid", StoreBidTxn, StoreBidSchema)
+	r.mustRegister(RUBIS_VIEWBIDHIST, "rubis_viewbidhist", ViewBidHistoryTxn, ViewBidHistorySchema)
+	r.mustRegister(RUBIS_BUYNOW, "rubis_buynow", StoreBuyNowTxn, StoreBuyNowSchema)
+	r.mustRegister(RUBIS_COMMENT, "rubis_comment", StoreCommentTxn, StoreCommentSchema)
+	r.mustRegister(RUBIS_NEWITEM, "rubis_newitem", NewItemTxn, NewItemSchema)
+	r.mustRegister(RUBIS_PUTBID, "rubis_putbid", PutBidTxn, PutBidSchema)
+	r.mustRegister(RUBIS_PUTCOMMENT, "rubis_putcomment", PutCommentTxn, PutCommentSchema)
+	r.mustRegister(RUBIS_REGISTER, "rubis_register", RegisterUserTxn, RegisterUserSchema)
+	r.mustRegister(RUBIS_SEARCHCAT, "rubis_searchcat", SearchItemsCategTxn, SearchItemsCategSchema)
+	r.mustRegister(RUBIS_SEARCHREG, "rubis_searchreg", SearchItemsRegionTxn, SearchItemsRegionSchema)
+	r.mustRegister(RUBIS_VIEW, "rubis_view", ViewItemTxn, ViewItemSchema)
+	r.mustRegister(RUBIS_VIEWUSER, "rubis_viewuser", ViewUserInfoTxn, ViewUserInfoSchema)
+	r.mustRegister(BIG_INCR, "big_incr", BigIncrTxn, BigSchema)
+	r.mustRegister(BIG_RW, "big_rw", BigRWTxn, BigSchema)
+	return r
+}
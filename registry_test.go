@@ -0,0 +1,84 @@
+package ddtxn
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := BuiltinRegistry()
+	if reg.Len() != BIG_RW+1 {
+		t.Fatalf("Wrong number of builtin transactions %v\n", reg.Len())
+	}
+	if id, ok := reg.Lookup("rubis_bid"); !ok || id != RUBIS_BID {
+		t.Errorf("Bad lookup %v %v\n", id, ok)
+	}
+	if reg.Schema(RUBIS_BID) != StoreBidSchema {
+		t.Errorf("Wrong schema for rubis_bid\n")
+	}
+
+	double := reg.MustRegister("double", func(q Query, tx ETransaction) (*Result, error) {
+		if err := tx.WriteInt32(q.Key(MICRO_K1), 2*int32(q.Num(MICRO_A)), SUM); err != nil {
+			return nil, err
+		}
+		if tx.Commit() == 0 {
+			return nil, EABORT
+		}
+		return nil, nil
+	})
+	if double != BIG_RW+1 || reg.Name(double) != "double" {
+		t.Errorf("Wrong ID %v for %v\n", double, reg.Name(double))
+	}
+
+	s := NewStore(FlagConfig())
+	s.CreateKey(ProductKey(1), int32(0), SUM)
+	c := NewCoordinator(1, s, reg)
+	w := c.Workers[0]
+	for i := 0; i < 3; i++ {
+		if _, err := w.One(MicroQuery(double, ProductKey(1), Key{}, 5)); err != nil {
+			t.Fatalf("double %v\n", err)
+		}
+	}
+	if _, err := w.One(MicroQuery(D_BUY, UserKey(1), ProductKey(1), 5)); err != nil {
+		t.Fatalf("buy %v\n", err)
+	}
+	c.Finish()
+
+	br, _ := s.Get(ProductKey(1))
+	if br.Value().(int32) != 35 {
+		t.Errorf("Wrong value %v\n", br.Value())
+	}
+	txns := CollectTxns(c)
+	if len(txns) != reg.Len() || txns[double] != 3 || txns[D_BUY] != 1 {
+		t.Errorf("Wrong transaction counts %v\n", txns)
+	}
+	if CollectOne(w) != 4 {
+		t.Errorf("Wrong total %v\n", CollectOne(w))
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	reg := BuiltinRegistry()
+	if id, err := reg.Register("buy", BuyTxn); !errors.Is(err, ErrRegistered) {
+		t.Errorf("Expected ErrRegistered, got %v %v\n", id, err)
+	}
+	if reg.Len() != BIG_RW+1 {
+		t.Errorf("Duplicate was registered; %v transactions\n", reg.Len())
+	}
+	if id, _ := reg.Lookup("buy"); id != D_BUY || reg.Func(D_BUY) == nil {
+		t.Errorf("Lost the first buy %v\n", id)
+	}
+
+	s := NewSchema("Bad")
+	s.Num("n")
+	if x := s.Num("n"); x != -1 {
+		t.Errorf("Expected no slot for a duplicate, got %v\n", x)
+	}
+	id := reg.MustRegister("bad", BuyTxn)
+	if err := reg.RegisterSchema(id, s); !errors.Is(err, ErrSchema) {
+		t.Errorf("Expected ErrSchema, got %v\n", err)
+	}
+	if reg.Schema(id) != nil {
+		t.Errorf("Registered a bad schema\n")
+	}
+}
@@ -0,0 +1,69 @@
+package ddtxn
+
+import (
+	"flag"
+	"math/rand"
+	"time"
+)
+
+var StashRetries = flag.Int("stashretries", 10, "Times to retry an aborted stashed transaction in the join phase before giving up\n")
+
+// How Worker.Do() retries aborted transactions.  The wait before
+// retry n is picked at random from [0, Backoff*2^(n-1)], capped at
+// MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts int           // 0 means no limit
+	Backoff     time.Duration // Initial backoff
+	MaxBackoff  time.Duration // 0 means no cap
+	Deadline    time.Duration // Give up after this long; 0 means never
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 10,
+	Backoff:     2 * time.Microsecond,
+	MaxBackoff:  10 * time.Millisecond,
+}
+
+// Run t, retrying on EABORT according to p.  The Result's Attempts
+// says how many times t ran.  Returns EGAVEUP if p ran out; backoffs
+// don't sleep past the deadline.  If t is stashed this returns ESTASH
+// like One(); the outcome arrives on t.W.
+func (w *Worker) Do(t Query, p RetryPolicy) (*Result, error) {
+	var start time.Time
+	if p.Deadline > 0 {
+		start = time.Now()
+	}
+	backoff := p.Backoff
+	for n := 1; ; n++ {
+		r, err := w.One(t)
+		if err != EABORT {
+			if r == nil {
+				r = &Result{}
+			}
+			r.Attempts = n
+			return r, err
+		}
+		if p.MaxAttempts > 0 && n >= p.MaxAttempts {
+			return &Result{Attempts: n}, EGAVEUP
+		}
+		if p.Deadline > 0 && time.Since(start) >= p.Deadline {
+			return &Result{Attempts: n}, EGAVEUP
+		}
+		if backoff > 0 {
+			d := time.Duration(rand.Int63n(int64(backoff) + 1))
+			if left := p.Deadline - time.Since(start); p.Deadline > 0 && d > left {
+				d = left
+			}
+			time.Sleep(d)
+			backoff *= 2
+			if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+				backoff = p.MaxBackoff
+			}
+			// Don't run t again once the deadline has passed
+			if p.Deadline > 0 && time.Since(start) >= p.Deadline {
+				return &Result{Attempts: n}, EGAVEUP
+			}
+		}
+		t.I++
+	}
+}
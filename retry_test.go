@@ -0,0 +1,62 @@
+package ddtxn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	reg := BuiltinRegistry()
+	n := 0
+	flaky := reg.MustRegister("flaky", func(q Query, tx ETransaction) (*Result, error) {
+		n++
+		if n <= int(q.Num(MICRO_A)) {
+			tx.Abort()
+			return nil, EABORT
+		}
+		if tx.Commit() == 0 {
+			return nil, EABORT
+		}
+		return nil, nil
+	})
+	s := NewStore(FlagConfig())
+	c := NewCoordinator(1, s, reg)
+	w := c.Workers[0]
+	defer c.Finish()
+
+	p := RetryPolicy{MaxAttempts: 5, Backoff: time.Microsecond}
+	r, err := w.Do(MicroQuery(flaky, Key{}, Key{}, 2), p)
+	if err != nil {
+		t.Fatalf("Do %v\n", err)
+	}
+	if r.Attempts != 3 {
+		t.Errorf("Wrong number of attempts %v\n", r.Attempts)
+	}
+
+	n = 0
+	p.MaxAttempts = 2
+	r, err = w.Do(MicroQuery(flaky, Key{}, Key{}, 2), p)
+	if err != EGAVEUP || r.Attempts != 2 {
+		t.Errorf("Expected to give up after 2 attempts; %v %v\n", err, r.Attempts)
+	}
+
+	n = 0
+	p = RetryPolicy{Backoff: time.Millisecond, Deadline: 5 * time.Millisecond}
+	r, err = w.Do(MicroQuery(flaky, Key{}, Key{}, 1000000), p)
+	if err != EGAVEUP || r.Attempts < 2 {
+		t.Errorf("Expected to give up at deadline; %v %v\n", err, r.Attempts)
+	}
+
+	// The backoff is much longer than the deadline, so Do should
+	// sleep until the deadline and give up without trying again.
+	n = 0
+	p = RetryPolicy{Backoff: 1000 * time.Hour, Deadline: 20 * time.Millisecond}
+	start := time.Now()
+	r, err = w.Do(MicroQuery(flaky, Key{}, Key{}, 1000000), p)
+	if err != EGAVEUP || r.Attempts != 1 || n != 1 {
+		t.Errorf("Expected to give up after 1 attempt; %v %v %v\n", err, r.Attempts, n)
+	}
+	if d := time.Since(start); d < p.Deadline || d > 10*time.Second {
+		t.Errorf("Slept past the deadline, or not until it: %v\n", d)
+	}
+}
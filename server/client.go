@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"net/rpc"
+	"strings"
+
+	"github.com/narula/ddtxn"
+)
+
+// Errors the server sends back as strings; mapped back to the
+// original values so callers can compare against them.
+var errs = []error{
+	ddtxn.EABORT,
+	ddtxn.ENOKEY,
+	ddtxn.ESTASH,
+	ddtxn.ENORETRY,
+	ddtxn.EGAVEUP,
+	ddtxn.EEXISTS,
+	ddtxn.EOVERFLOW,
+	ddtxn.ETOOBIG,
+	ddtxn.ESHUTDOWN,
+	ENOTXN,
+	ENOWORKER,
+	ERESULT,
+	ECLOSED,
+}
+
+// Kinds of ddtxn.TxnError, which carry more than their kind.
+var kinds = []error{
+	ddtxn.ErrTypeMismatch,
+	ddtxn.ErrMisuse,
+	ddtxn.ErrInternal,
+}
+
+func toError(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, e := range errs {
+		if e.Error() == s {
+			return e
+		}
+	}
+	for _, k := range kinds {
+		if strings.HasPrefix(s, k.Error()+": ") {
+			return &ddtxn.TxnError{Kind: k, Msg: s[len(k.Error())+2:]}
+		}
+	}
+	return errors.New(s)
+}
+
+type Client struct {
+	c *rpc.Client
+	// Worker to send requests to, -1 to let the server pick.
+	Worker int
+}
+
+func Dial(addr string) (*Client, error) {
+	c, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{c: c, Worker: -1}, nil
+}
+
+func (c *Client) Close() error {
+	return c.c.Close()
+}
+
+func (c *Client) run(q ddtxn.Query, nowait bool) (*ddtxn.Result, error) {
+	q.W = nil
+	req := &Request{Worker: c.Worker, Q: q, NoWait: nowait}
+	var resp Response
+	if err := c.c.Call("Doppel.Run", req, &resp); err != nil {
+		if se, ok := err.(rpc.ServerError); ok {
+			return nil, toError(string(se))
+		}
+		return nil, err
+	}
+	if err := toError(resp.Err); err != nil {
+		return nil, err
+	}
+	if resp.V == nil {
+		if resp.Attempts > 0 {
+			return &ddtxn.Result{Attempts: resp.Attempts}, nil
+		}
+		return nil, nil
+	}
+	var v value
+	if err := gob.NewDecoder(bytes.NewReader(resp.V)).Decode(&v); err != nil {
+		return nil, err
+	}
+	return &ddtxn.Result{V: v.V, Attempts: resp.Attempts}, nil
+}
+
+// Run a transaction and wait for its outcome.  A stashed transaction
+// returns once it has run in the join phase.
+func (c *Client) Call(q ddtxn.Query) (*ddtxn.Result, error) {
+	return c.run(q, false)
+}
+
+// Like Call(), but returns ESTASH right away if the transaction was
+// stashed.  The server still runs it; its result is dropped.
+func (c *Client) Submit(q ddtxn.Query) (*ddtxn.Result, error) {
+	return c.run(q, true)
+}
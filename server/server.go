@@ -0,0 +1,222 @@
+// Package server exposes a Doppel coordinator's transactions to
+// remote clients over TCP using net/rpc.
+//
+// A request names a registered transaction number and carries its
+// arguments in a ddtxn.Query.  The server hands it to one of the
+// coordinator's workers and replies with the Result and error.  If
+// the transaction is stashed, the reply is sent once the worker runs
+// it in the join phase.
+//
+// Result values are gob-encoded, so their concrete types have to be
+// registered with gob.Register().
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+
+	"github.com/narula/ddtxn"
+	"github.com/narula/dlog"
+)
+
+var (
+	ENOTXN    = errors.New("server: unknown transaction")
+	ENOWORKER = errors.New("server: no such worker")
+	ERESULT   = errors.New("server: committed, but result could not be encoded")
+	ECLOSED   = errors.New("server: closed")
+)
+
+type Request struct {
+	// Worker to run the transaction on, -1 to let the server pick.
+	Worker int
+	Q      ddtxn.Query
+	// Reply with ESTASH instead of waiting for a stashed transaction
+	// to run.
+	NoWait bool
+}
+
+type Response struct {
+	// gob encoding of the Result's value, nil if there was no Result.
+	V        []byte
+	Err      string
+	Stashed  bool
+	Attempts int // Times a stashed transaction ran in the join phase
+}
+
+// Wrapper so the value is encoded as an interface and decodes to its
+// concrete type.
+type value struct {
+	V ddtxn.Value
+}
+
+type outcome struct {
+	r   *ddtxn.Result
+	err error
+	w   chan struct {
+		R *ddtxn.Result
+		E error
+	}
+}
+
+type call struct {
+	q   ddtxn.Query
+	res chan outcome
+}
+
+type Server struct {
+	c    *ddtxn.Coordinator
+	rpc  *rpc.Server
+	work []chan *call
+	next uint64
+	done chan bool
+
+	mu     sync.Mutex
+	ln     net.Listener
+	closed bool
+}
+
+// Worker.One() isn't safe to call concurrently on the same worker, so
+// each worker gets a goroutine which runs requests one at a time.
+func NewServer(c *ddtxn.Coordinator) *Server {
+	s := &Server{
+		c:    c,
+		rpc:  rpc.NewServer(),
+		work: make([]chan *call, len(c.Workers)),
+		done: make(chan bool),
+	}
+	for i := range c.Workers {
+		s.work[i] = make(chan *call, 100)
+		go s.dispatch(c.Workers[i], s.work[i])
+	}
+	if err := s.rpc.RegisterName("Doppel", &Txn{s}); err != nil {
+		dlog.Printf("Could not register RPC service: %v\n", err)
+	}
+	return s
+}
+
+func (s *Server) dispatch(w *ddtxn.Worker, work chan *call) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case c := <-work:
+			r, err := w.One(c.q)
+			c.res <- outcome{r, err, c.q.W}
+		}
+	}
+}
+
+// Accept connections on ln until Close() is called.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ECLOSED
+	}
+	s.ln = ln
+	s.mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go s.rpc.ServeConn(conn)
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Stop accepting connections and stop the dispatchers.  Does not stop
+// the coordinator.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	if s.ln != nil {
+		return s.ln.Close()
+	}
+	return nil
+}
+
+// The RPC service.
+type Txn struct {
+	s *Server
+}
+
+func (t *Txn) Run(req *Request, resp *Response) error {
+	s := t.s
+	i := req.Worker
+	if i < 0 {
+		i = int(atomic.AddUint64(&s.next, 1) % uint64(len(s.work)))
+	} else if i >= len(s.work) {
+		return ENOWORKER
+	}
+	if !s.c.Workers[i].Registered(req.Q.TXN) {
+		return ENOTXN
+	}
+	c := &call{q: req.Q, res: make(chan outcome, 1)}
+	if !req.NoWait {
+		c.q.W = make(chan struct {
+			R *ddtxn.Result
+			E error
+		}, 1)
+	}
+	select {
+	case s.work[i] <- c:
+	case <-s.done:
+		return ECLOSED
+	}
+	var o outcome
+	select {
+	case o = <-c.res:
+	case <-s.done:
+		return ECLOSED
+	}
+	if o.err == ddtxn.ESTASH && o.w != nil {
+		select {
+		case x := <-o.w:
+			o.r, o.err = x.R, x.E
+		case <-s.done:
+			return ECLOSED
+		}
+		resp.Stashed = true
+		if o.r != nil {
+			resp.Attempts = o.r.Attempts
+		}
+	}
+	if o.err != nil {
+		resp.Err = o.err.Error()
+	}
+	if o.r != nil && o.err == nil {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(&value{o.r.V}); err != nil {
+			dlog.Printf("Could not encode result of %v: %v\n", req.Q.TXN, err)
+			resp.Err = ERESULT.Error()
+			return nil
+		}
+		resp.V = buf.Bytes()
+	}
+	return nil
+}
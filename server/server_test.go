@@ -0,0 +1,116 @@
+package server
+
+import (
+	"math"
+	"net"
+	"testing"
+
+	"github.com/narula/ddtxn"
+)
+
+func start(t *testing.T, n int, s *ddtxn.Store) (*ddtxn.Coordinator, *Server, *Client) {
+	c := ddtxn.NewCoordinator(n, s, ddtxn.BuiltinRegistry())
+	srv := NewServer(c)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen %v\n", err)
+	}
+	go srv.Serve(ln)
+	cl, err := Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial %v\n", err)
+	}
+	return c, srv, cl
+}
+
+func TestRemoteTxn(t *testing.T) {
+	s := ddtxn.NewStore(ddtxn.FlagConfig())
+	s.CreateKey(ddtxn.ProductKey(4), int32(0), ddtxn.SUM)
+	s.CreateKey(ddtxn.UserKey(1), int32(0), ddtxn.SUM)
+	c, srv, cl := start(t, 1, s)
+	defer c.Finish()
+	defer srv.Close()
+	defer cl.Close()
+
+	for i := 0; i < 10; i++ {
+		q := ddtxn.MicroQuery(ddtxn.D_BUY, ddtxn.UserKey(1), ddtxn.ProductKey(4), 5)
+		if _, err := cl.Call(q); err != nil {
+			t.Fatalf("Buy %v\n", err)
+		}
+	}
+	r, err := cl.Call(ddtxn.MicroQuery(ddtxn.D_READ_ONE, ddtxn.ProductKey(4), ddtxn.Key{}, 0))
+	if err != nil {
+		t.Fatalf("Read %v\n", err)
+	}
+	if r.V.(int32) != 50 {
+		t.Errorf("Wrong value %v\n", r.V)
+	}
+
+	_, err = cl.Call(ddtxn.MicroQuery(ddtxn.D_READ_ONE, ddtxn.ProductKey(5), ddtxn.Key{}, 0))
+	if err != ddtxn.ENOKEY {
+		t.Errorf("Expected ENOKEY, got %v\n", err)
+	}
+	s.CreateKey(ddtxn.ProductKey(6), int32(math.MaxInt32-1), ddtxn.SUM)
+	_, err = cl.Call(ddtxn.MicroQuery(ddtxn.D_BUY, ddtxn.UserKey(1), ddtxn.ProductKey(6), 5))
+	if err != ddtxn.EOVERFLOW {
+		t.Errorf("Expected EOVERFLOW, got %v\n", err)
+	}
+	_, err = cl.Call(ddtxn.Query{TXN: 1000})
+	if err != ENOTXN {
+		t.Errorf("Expected ENOTXN, got %v\n", err)
+	}
+	cl.Worker = 3
+	_, err = cl.Call(ddtxn.MicroQuery(ddtxn.D_READ_ONE, ddtxn.ProductKey(4), ddtxn.Key{}, 0))
+	if err != ENOWORKER {
+		t.Errorf("Expected ENOWORKER, got %v\n", err)
+	}
+}
+
+func TestRemoteStash(t *testing.T) {
+	if *ddtxn.SysType != ddtxn.DOPPEL {
+		t.Skip("Stashing only happens in Doppel")
+	}
+	cfg := ddtxn.FlagConfig()
+	cfg.AlwaysSplit = true
+	s := ddtxn.NewStore(cfg)
+	s.CreateKey(ddtxn.ProductKey(4), int32(0), ddtxn.SUM)
+	s.CreateKey(ddtxn.UserKey(1), int32(0), ddtxn.SUM)
+	c, srv, cl := start(t, 2, s)
+	defer c.Finish()
+	defer srv.Close()
+	defer cl.Close()
+
+	q := ddtxn.MicroQuery(ddtxn.D_BUY, ddtxn.UserKey(1), ddtxn.ProductKey(4), 5)
+	if _, err := cl.Call(q); err != nil {
+		t.Fatalf("Buy %v\n", err)
+	}
+	read := ddtxn.MicroQuery(ddtxn.D_READ_ONE, ddtxn.ProductKey(4), ddtxn.Key{}, 0)
+	if _, err := cl.Submit(read); err != ddtxn.ESTASH {
+		t.Errorf("Expected ESTASH, got %v\n", err)
+	}
+	r, err := cl.Call(read)
+	if err != nil {
+		t.Fatalf("Stashed read %v\n", err)
+	}
+	if r.V.(int32) != 5 {
+		t.Errorf("Wrong value %v\n", r.V)
+	}
+}
+
+// Closing the server lets a request waiting for its stashed
+// transaction go, even if the transaction never runs.
+func TestCloseWhileStashed(t *testing.T) {
+	s := ddtxn.NewStore(ddtxn.FlagConfig())
+	c := ddtxn.NewCoordinator(1, s, ddtxn.BuiltinRegistry())
+	defer c.Finish()
+	srv := &Server{c: c, work: []chan *call{make(chan *call)}, done: make(chan bool)}
+	go func() {
+		x := <-srv.work[0]
+		x.res <- outcome{nil, ddtxn.ESTASH, x.q.W}
+		srv.Close()
+	}()
+	req := &Request{Q: ddtxn.MicroQuery(ddtxn.D_READ_ONE, ddtxn.ProductKey(4), ddtxn.Key{}, 0)}
+	if err := (&Txn{srv}).Run(req, &Response{}); err != ECLOSED {
+		t.Errorf("Expected ECLOSED, got %v\n", err)
+	}
+}
@@ -0,0 +1,109 @@
+package ddtxn
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	for _, sys := range []int{DOPPEL, OCC, LOCKING, SSI} {
+		cfg := FlagConfig()
+		cfg.SysType = sys
+		s := NewStore(cfg)
+		s.CreateKey(ProductKey(4), int32(0), SUM)
+		s.CreateKey(UserKey(1), int32(0), SUM)
+		c := NewCoordinator(2, s, BuiltinRegistry())
+		q := MicroQuery(D_BUY, UserKey(1), ProductKey(4), 5)
+		if _, err := c.Workers[0].One(q); err != nil {
+			t.Fatalf("%v: Buy %v\n", sys, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := c.Shutdown(ctx); err != nil {
+					t.Errorf("%v: Shutdown %v\n", sys, err)
+				}
+			}()
+		}
+		wg.Wait()
+		cancel()
+		c.Finish()
+
+		for _, w := range c.Workers {
+			if _, err := w.One(q); err != ESHUTDOWN {
+				t.Errorf("%v: Expected ESHUTDOWN, got %v\n", sys, err)
+			}
+		}
+		if err := c.Checkpoint(&bytes.Buffer{}); err != ESHUTDOWN {
+			t.Errorf("%v: Expected ESHUTDOWN from Checkpoint, got %v\n", sys, err)
+		}
+		if br, _ := s.Get(ProductKey(4)); br.Value() != int32(5) {
+			t.Errorf("%v: Wrong value %v\n", sys, br.Value())
+		}
+	}
+}
+
+func TestShutdownDrains(t *testing.T) {
+	if *SysType != DOPPEL {
+		t.Skip("Stashing only happens in Doppel")
+	}
+	// With one worker nothing but Shutdown() runs the stash
+	for _, n := range []int{1, 2} {
+		cfg := noGC()
+		cfg.AlwaysSplit = true
+		s := NewStore(cfg)
+		s.CreateKey(ProductKey(4), int32(0), SUM)
+		s.CreateKey(UserKey(1), int32(0), SUM)
+		c := NewCoordinator(n, s, BuiltinRegistry())
+		w := c.Workers[0]
+		q := MicroQuery(D_BUY, UserKey(1), ProductKey(4), 5)
+		if _, err := w.One(q); err != nil {
+			t.Fatalf("%v: Buy %v\n", n, err)
+		}
+		read := MicroQuery(D_READ_ONE, ProductKey(4), Key{}, 0)
+		read.W = make(chan struct {
+			R *Result
+			E error
+		}, 1)
+		if _, err := w.One(read); err != ESTASH {
+			t.Fatalf("%v: Expected ESTASH, got %v\n", n, err)
+		}
+		if err := c.Shutdown(context.Background()); err != nil {
+			t.Fatalf("%v: Shutdown %v\n", n, err)
+		}
+		select {
+		case x := <-read.W:
+			if x.E != nil || x.R.V.(int32) != 5 {
+				t.Errorf("%v: Stashed read %v %v\n", n, x.R, x.E)
+			}
+		default:
+			t.Errorf("%v: Stashed read never answered\n", n)
+		}
+		if br, _ := s.Get(ProductKey(4)); br.Value() != int32(5) {
+			t.Errorf("%v: Buy not merged %v\n", n, br.Value())
+		}
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s := NewStore(FlagConfig())
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	// Hold the worker so it can't drain
+	c.Workers[0].Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v\n", err)
+	}
+	c.Workers[0].Unlock()
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown %v\n", err)
+	}
+}
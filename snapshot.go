@@ -0,0 +1,161 @@
+package ddtxn
+
+import (
+	"math"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Read-only snapshots.  Every so often, with no transactions running
+// and nothing waiting to be merged, the coordinator starts a new
+// snapshot generation: at the barrier after every worker merges an
+// epoch, or, when there are no split records, by pausing the workers.
+// The first write to a record after that keeps a copy of the value it
+// had, tagged with the generation.  ReadSnapshot() returns the value
+// as of the start of the current generation: the copy if there is
+// one, otherwise the record itself, since nobody wrote it since.
+//
+// The generation can't change while a worker is running a
+// transaction, so one copy per record is enough.  A checkpoint keeps
+// it from changing at all until the checkpoint is written, so that it
+// can read the snapshot while transactions run.
+//
+// Writers change a record's int_value, i64_value and f64_value with
+// atomics or holding br.SLock(), and its other fields holding
+// br.SLock(), so a reader copies it holding br.SRLock().
+
+type version struct {
+	gen       uint64
+	int_value int32
+	i64_value int64
+	f64_value uint64
+	value     Value
+	entries   []Entry
+	absent    bool // Deleted, or not created yet
+}
+
+func (v *version) Value(kt KeyType) Value {
+	switch kt {
+	case SUM, MAX:
+		return v.int_value
+	case WRITE:
+		return v.value
+	case LIST:
+		return v.entries
+	case OOWRITE:
+		return Overwrite{v: v.value, i: v.int_value}
+	case SUM64, MAX64:
+		return v.i64_value
+	case FSUM, FMAX:
+		return math.Float64frombits(v.f64_value)
+	}
+	if op := mergeOp(kt); op != nil && v.value == nil {
+		return op.Identity()
+	}
+	return v.value
+}
+
+// Called before changing br, to keep its value as of the start of
+// generation gen.  Merges change records concurrently, so only the
+// first copy gets installed.
+func (br *BRecord) save(gen uint64) {
+	old := (*version)(atomic.LoadPointer(&br.snap))
+	if old != nil && old.gen >= gen {
+		return
+	}
+	v := br.copyVersion(gen)
+	atomic.CompareAndSwapPointer(&br.snap, unsafe.Pointer(old), unsafe.Pointer(v))
+}
+
+func (br *BRecord) copyVersion(gen uint64) *version {
+	v := &version{
+		gen:       gen,
+		int_value: atomic.LoadInt32(&br.int_value),
+		i64_value: atomic.LoadInt64(&br.i64_value),
+		f64_value: atomic.LoadUint64(&br.f64_value),
+		value:     br.value,
+		absent:    br.deleted || !br.exists,
+	}
+	if br.key_type == LIST {
+		v.entries = make([]Entry, len(br.entries))
+		copy(v.entries, br.entries)
+	}
+	return v
+}
+
+// Mark a record created by a transaction as not existing in the
+// current snapshot.
+func (s *Store) created(br *BRecord) *BRecord {
+	br.snap = unsafe.Pointer(&version{gen: s.SnapshotGen(), absent: true})
+	return br
+}
+
+func (s *Store) save(br *BRecord) {
+	br.save(s.SnapshotGen())
+}
+
+func (s *Store) SnapshotGen() uint64 {
+	return atomic.LoadUint64(&s.snapgen)
+}
+
+// Only called by the coordinator, with the workers paused.
+func (s *Store) nextSnapshot() {
+	if atomic.LoadInt32(&s.pinned) != 0 {
+		return
+	}
+	atomic.AddUint64(&s.snapgen, 1)
+}
+
+// Keep the current generation until unpinSnapshot(), and return it.
+// Only called by the coordinator, with the workers paused.
+func (s *Store) pinSnapshot() uint64 {
+	atomic.StoreInt32(&s.pinned, 1)
+	return s.SnapshotGen()
+}
+
+func (s *Store) unpinSnapshot() {
+	atomic.StoreInt32(&s.pinned, 0)
+}
+
+func (s *Store) snapshotPinned() bool {
+	return atomic.LoadInt32(&s.pinned) != 0
+}
+
+// The value of k as of the start of generation gen, which must be the
+// current one.  held means I already hold k's SLock() or SRLock(), as
+// a 2PL transaction which read or wrote k does.
+func (s *Store) readSnapshot(k Key, gen uint64, held bool) (Value, error) {
+	br, err := s.getKey(k, nil)
+	if err != nil {
+		return nil, ENOKEY
+	}
+	v := br.snapshot(gen, held)
+	if v.absent {
+		return nil, ENOKEY
+	}
+	return v.Value(br.key_type), nil
+}
+
+// br as of the start of generation gen, which must be the current one.
+// Don't modify it.
+func (br *BRecord) snapshot(gen uint64, held bool) *version {
+	for {
+		p := atomic.LoadPointer(&br.snap)
+		v := (*version)(p)
+		if v != nil && v.gen >= gen {
+			return v
+		}
+		// Nobody has written it this generation, unless someone
+		// starts while I'm copying it; writers save() first.
+		if !held {
+			br.SRLock()
+		}
+		x := br.copyVersion(gen)
+		if !held {
+			br.SRUnlock()
+		}
+		if atomic.LoadPointer(&br.snap) == p {
+			return x
+		}
+	}
+}
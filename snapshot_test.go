@@ -0,0 +1,112 @@
+package ddtxn
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadSnapshot(t *testing.T) {
+	s := NewStore(noGC())
+	s.CreateKey(ProductKey(1), int32(5), SUM)
+	s.CreateKey(ProductKey(2), int32(5), SUM)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+	pause(c)
+
+	tx := StartOTransaction(c.Workers[0])
+	tx.Reset()
+	tx.WriteInt32(ProductKey(1), 1, SUM)
+	tx.WriteInt32(ProductKey(3), 1, SUM)
+	tx.Delete(ProductKey(2))
+	if tx.Commit() == 0 {
+		t.Fatalf("Aborted\n")
+	}
+
+	// Still see the values from before the commit
+	tx.Reset()
+	if v, err := tx.ReadSnapshot(ProductKey(1)); err != nil || v.(int32) != 5 {
+		t.Errorf("Wrong snapshot value %v %v\n", v, err)
+	}
+	if v, err := tx.ReadSnapshot(ProductKey(2)); err != nil || v.(int32) != 5 {
+		t.Errorf("Deleted key not in snapshot %v %v\n", v, err)
+	}
+	if _, err := tx.ReadSnapshot(ProductKey(3)); err != ENOKEY {
+		t.Errorf("New key in snapshot %v\n", err)
+	}
+	// A locked record doesn't abort a snapshot read
+	br, _ := s.getKey(ProductKey(1), nil)
+	br.Lock()
+	if v, err := tx.ReadSnapshot(ProductKey(1)); err != nil || v.(int32) != 5 {
+		t.Errorf("Wrong snapshot value of locked key %v %v\n", v, err)
+	}
+	br.Unlock(0)
+	tx.Commit()
+
+	pause(c)
+	tx.Reset()
+	if v, err := tx.ReadSnapshot(ProductKey(1)); err != nil || v.(int32) != 6 {
+		t.Errorf("Wrong value in next snapshot %v %v\n", v, err)
+	}
+	if _, err := tx.ReadSnapshot(ProductKey(2)); err != ENOKEY {
+		t.Errorf("Deleted key still in next snapshot %v\n", err)
+	}
+	if v, err := tx.ReadSnapshot(ProductKey(3)); err != nil || v.(int32) != 1 {
+		t.Errorf("New key not in next snapshot %v %v\n", v, err)
+	}
+}
+
+// Run with -race
+func TestReadSnapshotWhileWriting(t *testing.T) {
+	for _, sys := range []int{DOPPEL, OCC, LOCKING, SSI} {
+		reg := BuiltinRegistry()
+		set := reg.MustRegister("set", func(q Query, tx ETransaction) (*Result, error) {
+			tx.Write(q.Key(MICRO_K1), strings.Repeat("x", int(q.Num(MICRO_A))), WRITE)
+			if tx.Commit() == 0 {
+				return nil, EABORT
+			}
+			return nil, nil
+		})
+		snap := reg.MustRegister("snap", func(q Query, tx ETransaction) (*Result, error) {
+			v, err := tx.ReadSnapshot(q.Key(MICRO_K1))
+			if err != nil {
+				return nil, err
+			}
+			if tx.Commit() == 0 {
+				return nil, EABORT
+			}
+			return &Result{V: v}, nil
+		})
+		cfg := FlagConfig()
+		cfg.SysType = sys
+		cfg.GCInterval = time.Millisecond
+		s := NewStore(cfg)
+		s.CreateKey(ProductKey(1), "", WRITE)
+		c := NewCoordinator(2, s, reg)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20000; i++ {
+				c.Workers[0].One(MicroQuery(set, ProductKey(1), Key{}, int32(i%10)))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20000; i++ {
+				r, err := c.Workers[1].One(MicroQuery(snap, ProductKey(1), Key{}, 0))
+				if err != nil {
+					continue
+				}
+				if v := r.V.(string); strings.Trim(v, "x") != "" {
+					t.Errorf("%v: Torn value %q\n", sys, v)
+					return
+				}
+			}
+		}()
+		wg.Wait()
+		c.Finish()
+	}
+}
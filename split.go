@@ -0,0 +1,131 @@
+package ddtxn
+
+import (
+	"flag"
+	"sort"
+
+	"github.com/narula/dlog"
+)
+
+// Flags for FlagConfig()
+var SplitInterval = flag.Int("splitinterval", 10, "Times the coordinator checks for a phase change between deciding which keys to split\n")
+
+// Decides which keys are split.  Every Config.SplitInterval times it
+// checks for a phase change, the coordinator merges what the workers
+// sampled since the last time, less keys deleted since, and calls
+// Classify with it and the keys split now.  Keys in split start being
+// split at the next phase change and keys in unsplit stop; the
+// coordinator ignores keys which are already that way or don't
+// exist.  Classify is only called from the coordinator's goroutine,
+// and c is only good until it returns.
+type SplitPolicy interface {
+	Classify(c *Candidates, dd map[Key]bool) (split, unsplit map[Key]bool)
+}
+
+func (o *OneStat) Key() Key           { return o.k }
+func (o *OneStat) Reads() float64     { return o.reads }
+func (o *OneStat) Writes() float64    { return o.writes }
+func (o *OneStat) Conflicts() float64 { return o.conflicts }
+func (o *OneStat) Stashes() float64   { return o.stash }
+func (o *OneStat) Ratio() float64     { return o.ratio() }
+
+// What was sampled about k, or nil.
+func (c *Candidates) Stat(k Key) *OneStat {
+	return c.m[k]
+}
+
+// The keys contended enough to consider splitting, and the split
+// keys that were sampled, highest Ratio() first.
+func (c *Candidates) Interesting() []*OneStat {
+	x := make([]*OneStat, len(*c.h))
+	copy(x, *c.h)
+	sort.SliceStable(x, func(i, j int) bool { return x[i].ratio() > x[j].ratio() })
+	return x
+}
+
+// Splits keys whose Ratio() is over Config.WRRatio and that were
+// written or conflicted on more than once.  The first key to be split
+// needs a ratio 1.33 times that, and more than one write or five
+// conflicts, since splitting it starts phases.  A split key goes back
+// the second time its ratio is under half of Config.WRRatio, or it
+// isn't sampled.
+type ratioPolicy struct {
+	cfg     *Config
+	strikes map[Key]bool // Split keys that looked uncontended once
+}
+
+// The policy the coordinator uses unless Config.SplitPolicy is set.
+func DefaultSplitPolicy(cfg *Config) SplitPolicy {
+	return &ratioPolicy{cfg: cfg, strikes: make(map[Key]bool)}
+}
+
+func (p *ratioPolicy) Classify(c *Candidates, dd map[Key]bool) (map[Key]bool, map[Key]bool) {
+	split := make(map[Key]bool)
+	unsplit := make(map[Key]bool)
+	for _, o := range c.Interesting() {
+		if dd[o.k] {
+			continue
+		}
+		if len(dd) == 0 && len(split) == 0 {
+			// Higher threshold for the first one, since it kicks off phases
+			if o.ratio() > 1.33*p.cfg.WRRatio && (o.writes > 1 || o.conflicts > 5) {
+				split[o.k] = true
+				dlog.Printf("move %v to split1 r:%v w:%v c:%v s:%v ra:%v\n", o.k, o.reads, o.writes, o.conflicts, o.stash, o.ratio())
+			} else {
+				dlog.Printf("%v no move inertia r:%v w:%v c:%v s:%v ra:%v\n", o.k, o.reads, o.writes, o.conflicts, o.stash, o.ratio())
+			}
+			continue
+		}
+		if o.ratio() > p.cfg.WRRatio && (o.writes > 1 || o.conflicts > 1) {
+			split[o.k] = true
+			dlog.Printf("move %v to split2 r:%v w:%v c:%v s:%v ra:%v\n", o.k, o.reads, o.writes, o.conflicts, o.stash, o.ratio())
+		} else {
+			dlog.Printf("too low; no move :%v; r:%v w:%v c:%v s:%v ra:%v; wr: %v\n", o.k, o.reads, o.writes, o.conflicts, o.stash, o.ratio(), p.cfg.WRRatio)
+		}
+	}
+	// Check to see if we need to remove anything from dd
+	for k := range dd {
+		o, ok := c.m[k]
+		if !ok {
+			dlog.Printf("Key %v was split but now is not in store candidates\n", k)
+		} else if o.ratio() < p.cfg.WRRatio/2 {
+			dlog.Printf("move %v from split r:%v w:%v c:%v s:%v ratio:%v\n", k, o.reads, o.writes, o.conflicts, o.stash, o.ratio())
+		} else {
+			continue
+		}
+		if p.strikes[k] {
+			p.strikes[k] = false
+			unsplit[k] = true
+		} else {
+			p.strikes[k] = true
+		}
+	}
+	return split, unsplit
+}
+
+type staticPolicy map[Key]bool
+
+// Splits exactly keys, whatever the workers sample.
+func StaticSplitPolicy(keys ...Key) SplitPolicy {
+	p := make(staticPolicy)
+	for _, k := range keys {
+		p[k] = true
+	}
+	return p
+}
+
+func (p staticPolicy) Classify(c *Candidates, dd map[Key]bool) (map[Key]bool, map[Key]bool) {
+	split := make(map[Key]bool)
+	unsplit := make(map[Key]bool)
+	for k := range p {
+		if !dd[k] {
+			split[k] = true
+		}
+	}
+	for k := range dd {
+		if !p[k] {
+			unsplit[k] = true
+		}
+	}
+	return split, unsplit
+}
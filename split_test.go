@@ -0,0 +1,178 @@
+package ddtxn
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMoveSplit(t *testing.T) {
+	s := NewStore(FlagConfig())
+	for i := 1; i <= 3; i++ {
+		s.CreateKey(ProductKey(i), int32(0), SUM)
+	}
+	before := s.DD()
+	s.moveSplit(map[Key]bool{ProductKey(1): true, ProductKey(2): true}, nil)
+	if len(before) != 0 {
+		t.Errorf("Published split set changed %v\n", before)
+	}
+	s.moveSplit(map[Key]bool{ProductKey(3): true}, map[Key]bool{ProductKey(1): true})
+	for i, dd := range []bool{false, true, true} {
+		k := ProductKey(i + 1)
+		br, _ := s.Get(k)
+		if s.IsDD(k) != dd || br.isDD() != dd {
+			t.Errorf("%v: Expected split %v, got %v %v\n", i+1, dd, s.IsDD(k), br.isDD())
+		}
+	}
+	if len(s.DD()) != 2 {
+		t.Errorf("Wrong split set %v\n", s.DD())
+	}
+}
+
+// Run with -race: the coordinator splits the products while the
+// workers and a reader are using them.  Each worker has its own, so
+// the only thing they share is whether it's split; the conflicts come
+// from holding the records' locks here.
+func TestSplitWhileRunning(t *testing.T) {
+	if *SysType != DOPPEL {
+		t.Skip("Splitting only happens in Doppel")
+	}
+	cfg := noGC()
+	cfg.PhaseLength = time.Millisecond
+	cfg.SampleRate = 1
+	s := NewStore(cfg)
+	c := NewCoordinator(4, s, BuiltinRegistry())
+	defer c.Finish()
+
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for _, w := range c.Workers {
+		s.CreateKey(UserKey(uint64(w.ID)), int32(0), SUM)
+		s.CreateKey(ProductKey(w.ID), int32(0), SUM)
+		wg.Add(1)
+		go func(w *Worker) {
+			defer wg.Done()
+			q := MicroQuery(D_BUY, UserKey(uint64(w.ID)), ProductKey(w.ID), 1)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				w.One(q)
+			}
+		}(w)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !s.IsDD(ProductKey(0)) && time.Now().Before(deadline) {
+		s.DD()
+		for i := range c.Workers {
+			br, _ := s.Get(ProductKey(i))
+			if ok, last := br.Lock(); ok {
+				time.Sleep(10 * time.Microsecond)
+				br.Unlock(TID(last))
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if !s.IsDD(ProductKey(0)) {
+		t.Errorf("Product never split\n")
+	}
+}
+
+func TestDefaultSplitPolicy(t *testing.T) {
+	cfg := DefaultConfig()
+	conflicts := func(n map[Key]int) *Candidates {
+		c := newCandidates(&cfg)
+		for k, x := range n {
+			for i := 0; i < x; i++ {
+				c.Conflict(k, nil, SUM)
+			}
+		}
+		return c
+	}
+	p := DefaultSplitPolicy(&cfg)
+	a, b, d := ProductKey(1), ProductKey(2), ProductKey(3)
+
+	// Not enough to be the first
+	split, _ := p.Classify(conflicts(map[Key]int{b: 3}), map[Key]bool{})
+	if len(split) != 0 {
+		t.Errorf("Split the first key too soon %v\n", split)
+	}
+	// Enough once something is split
+	split, _ = p.Classify(conflicts(map[Key]int{b: 3}), map[Key]bool{d: true})
+	if !split[b] {
+		t.Errorf("Expected to split %v, got %v\n", b, split)
+	}
+	split, _ = p.Classify(conflicts(map[Key]int{a: 10, b: 3}), map[Key]bool{})
+	if len(split) != 2 || !split[a] || !split[b] {
+		t.Errorf("Expected to split %v and %v, got %v\n", a, b, split)
+	}
+
+	// Unsplit the second time it isn't contended
+	for i, expect := range []bool{false, true} {
+		split, unsplit := p.Classify(conflicts(map[Key]int{b: 3}), map[Key]bool{a: true, b: true})
+		if len(split) != 0 || unsplit[a] != expect || unsplit[b] {
+			t.Errorf("%v: Expected to unsplit %v: %v, got %v %v\n", i, a, expect, split, unsplit)
+		}
+	}
+}
+
+func TestStaticSplitPolicy(t *testing.T) {
+	p := StaticSplitPolicy(ProductKey(1), ProductKey(2))
+	split, unsplit := p.Classify(newCandidates(&Config{}), map[Key]bool{ProductKey(2): true, ProductKey(3): true})
+	if len(split) != 1 || !split[ProductKey(1)] || len(unsplit) != 1 || !unsplit[ProductKey(3)] {
+		t.Errorf("Wrong keys %v %v\n", split, unsplit)
+	}
+
+	if *SysType != DOPPEL {
+		return
+	}
+	cfg := noGC()
+	cfg.PhaseLength = time.Millisecond
+	cfg.SplitInterval = 1
+	cfg.SplitPolicy = StaticSplitPolicy(ProductKey(4))
+	s := NewStore(cfg)
+	s.CreateKey(ProductKey(4), int32(0), SUM)
+	s.CreateKey(ProductKey(5), int32(0), SUM)
+	c := NewCoordinator(2, s, BuiltinRegistry())
+	defer c.Finish()
+
+	// Nothing runs, but the policy still picks it
+	deadline := time.Now().Add(10 * time.Second)
+	for !s.IsDD(ProductKey(4)) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !s.IsDD(ProductKey(4)) || s.IsDD(ProductKey(5)) {
+		t.Errorf("Wrong keys split %v\n", s.DD())
+	}
+}
+
+// A deleted key mustn't be the first split and keep a live one from
+// being split.
+func TestSplitPolicyDeletedKey(t *testing.T) {
+	cfg := noGC()
+	s := NewStore(cfg)
+	a, b := ProductKey(1), ProductKey(2)
+	s.CreateKey(a, int32(0), SUM)
+	s.CreateKey(b, int32(0), SUM)
+	c := newCandidates(&cfg)
+	for i := 0; i < 20; i++ {
+		c.Conflict(a, nil, SUM)
+		if i < 10 {
+			c.Conflict(b, nil, SUM)
+		}
+	}
+	br, _ := s.Get(a)
+	s.Set(br, nil, DELETE)
+
+	c.dropMissing(s)
+	if c.Stat(a) != nil || c.Stat(b) == nil {
+		t.Errorf("Wrong candidates %v %v\n", c.Stat(a), c.Stat(b))
+	}
+	split, _ := DefaultSplitPolicy(&cfg).Classify(c, map[Key]bool{})
+	if len(split) != 1 || !split[b] {
+		t.Errorf("Expected to split %v, got %v\n", b, split)
+	}
+}
@@ -0,0 +1,538 @@
+package ddtxn
+
+import (
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Serializable snapshot isolation (-sys=3), a multi-version baseline.
+//
+// Every record written under SSI keeps a short chain of versions,
+// newest first, tagged with the commit timestamp of the transaction
+// that wrote them; the oldest is the value it had before.
+// Timestamps come from a store-wide clock.  A transaction reads the
+// newest version no later than the clock when it started, and its
+// writes are buffered until commit, where the first committer wins
+// any write-write conflict.
+//
+// That alone is snapshot isolation, which allows write skew.  To be
+// serializable a committing transaction T looks for rw-antidependencies
+// (T read something another concurrent transaction wrote, without
+// seeing the write) on either side of it:
+//
+//   out: a version newer than T's snapshot of something T read, from
+//        a transaction which committed first.
+//   in:  something T writes was read by a transaction which committed
+//        after T started.  Each record remembers the latest commit
+//        timestamp of a transaction which read it.
+//
+// T aborts if it has both, or if it read past a version whose writer
+// itself committed with an out edge.  This is the usual conservative
+// check (it aborts some serializable schedules) and relies on a cycle
+// needing the transaction at the end of the pair of edges to commit
+// first.  Reads of missing keys and scans don't have a record to
+// stamp, so they stamp the store instead and any insert counts as an
+// in edge for them; a scanned leaf that changes aborts T, like OCC.
+
+// Longest version chain kept.  Reading something older aborts.
+const MV_DEPTH = 8
+
+type mvVersion struct {
+	version
+	tid  uint64         // Commit timestamp
+	out  bool           // Its writer had an out edge
+	next unsafe.Pointer // *mvVersion, older
+}
+
+func (v *mvVersion) older() *mvVersion {
+	return (*mvVersion)(atomic.LoadPointer(&v.next))
+}
+
+func (br *BRecord) newest() *mvVersion {
+	return (*mvVersion)(atomic.LoadPointer(&br.versions))
+}
+
+// Caller holds br's lock.
+func (br *BRecord) addVersion(v *mvVersion) {
+	v.next = atomic.LoadPointer(&br.versions)
+	atomic.StorePointer(&br.versions, unsafe.Pointer(v))
+	x := v
+	for i := 1; i < MV_DEPTH && x != nil; i++ {
+		x = x.older()
+	}
+	if x != nil {
+		atomic.StorePointer(&x.next, nil)
+	}
+}
+
+func stamp(p *uint64, ts uint64) {
+	for {
+		x := atomic.LoadUint64(p)
+		if x >= ts || atomic.CompareAndSwapUint64(p, x, ts) {
+			return
+		}
+	}
+}
+
+// Not threadsafe.  Tracks execution of an SSI transaction.
+type STransaction struct {
+	padding0 [128]byte
+	read     []ReadKey
+	writes   []WriteKey
+	scans    []ScanLeaf
+	created  []Key // Keys I inserted while committing
+	recs     []BRecord
+	nrecs    int
+	start    uint64 // Clock at my first read
+	begun    bool
+	maxSeen  uint64
+	w        *Worker
+	s        *Store
+	phase    int
+	full     bool  // Ran out of room; can't commit
+	err      error // Why Commit() aborted, if retrying can't help
+	padding  [128]byte
+}
+
+func StartSTransaction(w *Worker) *STransaction {
+	tx := &STransaction{
+		read:   make([]ReadKey, 0, TXN_KEYS),
+		writes: make([]WriteKey, 0, TXN_KEYS),
+		recs:   make([]BRecord, TXN_KEYS),
+		w:      w,
+		s:      w.store,
+	}
+	return tx
+}
+
+func (tx *STransaction) Reset() {
+	tx.full = false
+	tx.err = nil
+	tx.read = tx.read[:0]
+	tx.writes = tx.writes[:0]
+	tx.scans = tx.scans[:0]
+	tx.created = tx.created[:0]
+	tx.nrecs = 0
+	tx.begun = false
+}
+
+// Whether I can add another key to a set with n keys.  A transaction
+// which can't is too big to commit.
+func (tx *STransaction) room(n int) bool {
+	if n >= tx.s.cfg.MaxKeys {
+		tx.full = true
+		return false
+	}
+	return true
+}
+
+func (tx *STransaction) tooBig() bool {
+	return tx.full
+}
+
+func (tx *STransaction) commitError() error {
+	return tx.err
+}
+
+// Take the snapshot at the first read rather than in Reset(); some
+// callers only Reset() after running a transaction.
+func (tx *STransaction) begin() {
+	if !tx.begun {
+		tx.start = atomic.LoadUint64(&tx.s.clock)
+		tx.begun = true
+	}
+}
+
+func (tx *STransaction) UID(f rune) uint64 {
+	return tx.w.NextKey(f)
+}
+
+func (tx *STransaction) RelinquishKey(n uint64, r rune) {
+	tx.w.GiveBack(n, r)
+}
+
+func (tx *STransaction) NoCount() {
+}
+
+func (tx *STransaction) MaybeWrite(k Key) {
+	// no op
+}
+
+func (tx *STransaction) SetPhase(p int) {
+	tx.phase = p
+}
+
+func (tx *STransaction) GetPhase() int {
+	return tx.phase
+}
+
+func (tx *STransaction) Store() *Store {
+	return tx.s
+}
+
+func (tx *STransaction) Worker() *Worker {
+	return tx.w
+}
+
+// Read() returns records, so copy versions into ones I own.
+func (tx *STransaction) record(k Key, kt KeyType) *BRecord {
+	var br *BRecord
+	if tx.nrecs < len(tx.recs) {
+		br = &tx.recs[tx.nrecs]
+		tx.nrecs++
+	} else {
+		br = &BRecord{}
+	}
+	br.key = k
+	br.key_type = kt
+	br.exists = true
+	br.lopts = nil
+	return br
+}
+
+// The version of br in my snapshot.  A writer holds the lock from
+// before it gets its timestamp until its version is installed, so
+// wait for unlocked records.
+func (tx *STransaction) visible(br *BRecord) (*mvVersion, error) {
+	for {
+		if ok, _ := br.IsUnlocked(); !ok {
+			runtime.Gosched()
+			continue
+		}
+		v := br.newest()
+		if v == nil {
+			// Never written under SSI; make its value the oldest
+			// version.
+			if ok, _ := br.Lock(); ok {
+				if br.newest() == nil {
+					br.addVersion(&mvVersion{version: *br.copyVersion(0)})
+				}
+				br.Unlock(0)
+			}
+			continue
+		}
+		for ; v != nil; v = v.older() {
+			if v.tid <= tx.start {
+				return v, nil
+			}
+		}
+		// Pruned
+		tx.w.Ncounters[NFAIL_VERIFY]++
+		return nil, EABORT
+	}
+}
+
+func (tx *STransaction) Read(k Key) (*BRecord, error) {
+	for i := range tx.writes {
+		w := &tx.writes[i]
+		if w.key == k {
+			// Like OCC, return a copy with my write applied to
+			// what I see.
+			if w.op == DELETE {
+				return nil, ENOKEY
+			}
+			base, err := tx.readVisible(k)
+			if err != nil && err != ENOKEY {
+				return nil, err
+			}
+			return w.record(k, base), nil
+		}
+	}
+	return tx.readVisible(k)
+}
+
+func (tx *STransaction) readVisible(k Key) (*BRecord, error) {
+	if !tx.room(len(tx.read)) {
+		return nil, ETOOBIG
+	}
+	tx.begin()
+	br, err := tx.s.getKey(k, tx.w.ld)
+	if err == ENOKEY {
+		tx.read = append(tx.read, ReadKey{key: k})
+		return nil, err
+	}
+	v, err := tx.visible(br)
+	if err != nil {
+		return nil, err
+	}
+	tx.read = append(tx.read, ReadKey{key: k, br: br})
+	if v.absent {
+		return nil, ENOKEY
+	}
+	x := tx.record(k, br.key_type)
+	x.int_value = v.int_value
+	x.i64_value = v.i64_value
+	x.f64_value = v.f64_value
+	x.value = v.value
+	x.entries = v.entries
+	x.lopts = br.lopts
+	return x, nil
+}
+
+func (tx *STransaction) ReadSnapshot(k Key) (Value, error) {
+	return tx.s.readSnapshot(k, tx.s.SnapshotGen(), false)
+}
+
+func (tx *STransaction) Scan(start, end Key, limit int) ([]Key, error) {
+	x := tx.s.Index(start)
+	if x == nil {
+		return nil, ENOINDEX
+	}
+	tx.begin()
+	keys := x.scan(start, end, limit, func(l *leaf) {
+		tx.scans = append(tx.scans, ScanLeaf{x, l, l.version})
+	})
+	return keys, nil
+}
+
+// Same as OTransaction.verifyScans().
+func (tx *STransaction) verifyScans() bool {
+	for i := range tx.scans {
+		sl := &tx.scans[i]
+		expect := sl.version
+		for _, k := range tx.created {
+			if sl.x.routesTo(k, sl.l) {
+				expect++
+			}
+		}
+		if sl.x.versionOf(sl.l) != expect {
+			return false
+		}
+	}
+	return true
+}
+
+// Add a write to k, coalescing it with any I already have.  base is
+// the record to check sums against, or nil.
+func (tx *STransaction) write(k Key, base *BRecord, op KeyType, a int32, v Value, e Entry) error {
+	for i := range tx.writes {
+		if tx.writes[i].key == k {
+			return tx.writes[i].write(k, false, base, op, a, v, e)
+		}
+	}
+	if !tx.room(len(tx.writes)) {
+		return ETOOBIG
+	}
+	n := len(tx.writes)
+	tx.writes = append(tx.writes, WriteKey{key: k})
+	w := &tx.writes[n]
+	if err := w.write(k, true, base, op, a, v, e); err != nil {
+		tx.writes = tx.writes[:n]
+		return err
+	}
+	return nil
+}
+
+// Sums are applied to the value when I commit, which I don't know
+// yet; check the latest one now, and again at commit.
+func (tx *STransaction) latest(k Key) *BRecord {
+	br, err := tx.s.getKey(k, tx.w.ld)
+	if err != nil {
+		return nil
+	}
+	return br
+}
+
+func (tx *STransaction) WriteInt32(k Key, a int32, op KeyType) error {
+	return tx.write(k, tx.latest(k), op, a, nil, Entry{})
+}
+
+func (tx *STransaction) WriteInt64(k Key, a int64, op KeyType) error {
+	return tx.write(k, tx.latest(k), op, 0, a, Entry{})
+}
+
+func (tx *STransaction) WriteFloat64(k Key, a float64, op KeyType) error {
+	return tx.write(k, tx.latest(k), op, 0, a, Entry{})
+}
+
+func (tx *STransaction) Write(k Key, v Value, op KeyType) error {
+	if op == SUM || op == MAX {
+		return tx.WriteInt32(k, v.(int32), op)
+	}
+	return tx.write(k, nil, op, 0, v, Entry{})
+}
+
+func (tx *STransaction) WriteList(k Key, l Entry, op KeyType) error {
+	if op != LIST {
+		return typeMismatch(k, op, LIST)
+	}
+	return tx.write(k, nil, op, 0, nil, l)
+}
+
+func (tx *STransaction) WriteOO(k Key, a int32, v Value, op KeyType) error {
+	if op != OOWRITE {
+		return typeMismatch(k, op, OOWRITE)
+	}
+	return tx.write(k, nil, op, a, v, Entry{})
+}
+
+func (tx *STransaction) Delete(k Key) error {
+	for i := range tx.writes {
+		w := &tx.writes[i]
+		if w.key == k {
+			if w.op == DELETE {
+				return ENOKEY
+			}
+			w.set(DELETE, 0, nil, Entry{})
+			return nil
+		}
+	}
+	if _, err := tx.Read(k); err != nil {
+		return err
+	}
+	return tx.write(k, nil, DELETE, 0, nil, Entry{})
+}
+
+func (tx *STransaction) Abort() TID {
+	for i := range tx.writes {
+		if tx.writes[i].locked {
+			tx.writes[i].br.Unlock(0)
+			tx.writes[i].locked = false
+		}
+	}
+	return 0
+}
+
+func (tx *STransaction) writing(br *BRecord) bool {
+	for i := range tx.writes {
+		if tx.writes[i].br == br && tx.writes[i].locked {
+			return true
+		}
+	}
+	return false
+}
+
+func (tx *STransaction) Commit() TID {
+	if tx.full {
+		return tx.Abort()
+	}
+	tx.begin()
+	in := false
+	for i := range tx.writes {
+		w := &tx.writes[i]
+		br, err := tx.s.getKey(w.key, tx.w.ld)
+		if err == ENOKEY {
+			if w.op == DELETE {
+				// Wrote, then deleted, a new key
+				w.br = nil
+				continue
+			}
+			w.br, err = tx.s.CreateLockedKey(w.key, w.op)
+			if err != nil {
+				tx.w.Ncounters[NFAIL_VERIFY]++
+				return tx.Abort()
+			}
+			w.locked = true
+			// Nobody's snapshot has it yet
+			w.br.addVersion(&mvVersion{version: version{absent: true}})
+			tx.created = append(tx.created, w.key)
+			continue
+		}
+		w.br = br
+		ok, former := br.Lock()
+		if !ok {
+			tx.w.Ncounters[NO_LOCK]++
+			return tx.Abort()
+		}
+		w.locked = true
+		if former > tx.maxSeen {
+			tx.maxSeen = former
+		}
+		if br.newest() == nil {
+			br.addVersion(&mvVersion{version: *br.copyVersion(0)})
+		}
+		if br.newest().tid > tx.start {
+			// First committer wins
+			tx.w.Ncounters[NFAIL_VERIFY]++
+			return tx.Abort()
+		}
+		if !w.reset && br.overflows(w.op, w.value()) {
+			tx.w.Ncounters[NFAIL_VERIFY]++
+			tx.err = EOVERFLOW
+			return tx.Abort()
+		}
+		if atomic.LoadUint64(&br.rts) > tx.start {
+			in = true
+		}
+	}
+	if len(tx.created) > 0 && atomic.LoadUint64(&tx.s.absentrts) > tx.start {
+		in = true
+	}
+
+	// Stamp what I read before looking for writes to it.  A writer
+	// checks the stamps while holding its locks, so either it sees my
+	// stamp or I see its lock or version.
+	ts := atomic.AddUint64(&tx.s.clock, 1)
+	if len(tx.scans) > 0 {
+		stamp(&tx.s.absentrts, ts)
+	}
+	for i := range tx.read {
+		if tx.read[i].br == nil {
+			stamp(&tx.s.absentrts, ts)
+		} else {
+			stamp(&tx.read[i].br.rts, ts)
+		}
+	}
+	out := false
+	for i := range tx.read {
+		br := tx.read[i].br
+		if br == nil {
+			var err error
+			br, err = tx.s.getKey(tx.read[i].key, tx.w.ld)
+			if err == ENOKEY {
+				continue
+			}
+		}
+		if tx.writing(br) {
+			// Checked for newer versions above
+			continue
+		}
+		if ok, _ := br.IsUnlocked(); !ok {
+			tx.w.Ncounters[NLOCKED]++
+			return tx.Abort()
+		}
+		for v := br.newest(); v != nil && v.tid > tx.start; v = v.older() {
+			out = true
+			if v.out {
+				tx.w.Ncounters[NFAIL_VERIFY]++
+				return tx.Abort()
+			}
+		}
+	}
+	if (in && out) || (len(tx.scans) > 0 && !tx.verifyScans()) {
+		tx.w.Ncounters[NFAIL_VERIFY]++
+		return tx.Abort()
+	}
+
+	tid := tx.w.commitTID()
+	if uint64(tid) < tx.maxSeen {
+		tx.w.resetTID(tx.maxSeen)
+		tid = tx.w.commitTID()
+	}
+	for i := range tx.writes {
+		w := &tx.writes[i]
+		if w.br == nil {
+			continue
+		}
+		// Snapshot readers copy records holding SRLock()
+		w.br.SLock()
+		w.commit(tx.s, w.br)
+		w.br.SUnlock()
+		if w.op == DELETE {
+			tx.w.deleted = append(tx.w.deleted, w.key)
+		}
+		w.br.addVersion(&mvVersion{version: *w.br.copyVersion(0), tid: ts, out: out})
+		w.br.Unlock(tid)
+		w.locked = false
+	}
+	if tx.w.wal != nil {
+		writes := make([]logWrite, 0, len(tx.writes))
+		for i := range tx.writes {
+			w := &tx.writes[i]
+			writes = w.log(w.key, writes)
+		}
+		tx.w.wal.Append(tid, writes, false)
+	}
+	return tid
+}
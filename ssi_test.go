@@ -0,0 +1,69 @@
+package ddtxn
+
+import (
+	"testing"
+)
+
+func TestSSI(t *testing.T) {
+	cfg := FlagConfig()
+	cfg.SysType = SSI
+	s := NewStore(cfg)
+	s.CreateKey(ProductKey(1), int32(1), SUM)
+	s.CreateKey(ProductKey(2), int32(1), SUM)
+	c := NewCoordinator(2, s, BuiltinRegistry())
+	defer c.Finish()
+	tx1 := StartSTransaction(c.Workers[0])
+	tx2 := StartSTransaction(c.Workers[1])
+
+	// Reads see the snapshot from the transaction's first read
+	tx1.Reset()
+	tx1.Read(ProductKey(2))
+	tx2.Reset()
+	tx2.WriteInt32(ProductKey(1), 1, SUM)
+	tx2.WriteInt32(ProductKey(3), 1, SUM)
+	if tx2.Commit() == 0 {
+		t.Fatalf("Aborted\n")
+	}
+	if br, err := tx1.Read(ProductKey(1)); err != nil || br.Value().(int32) != 1 {
+		t.Errorf("Wrong snapshot value %v %v\n", br, err)
+	}
+	if _, err := tx1.Read(ProductKey(3)); err != ENOKEY {
+		t.Errorf("Saw a key created after I started %v\n", err)
+	}
+	if tx1.Commit() == 0 {
+		t.Errorf("Read-only transaction aborted\n")
+	}
+	tx1.Reset()
+	if br, err := tx1.Read(ProductKey(1)); err != nil || br.Value().(int32) != 2 {
+		t.Errorf("Wrong value %v %v\n", br, err)
+	}
+
+	// First committer wins
+	tx1.Reset()
+	tx2.Reset()
+	tx1.Read(ProductKey(2))
+	tx1.WriteInt32(ProductKey(2), 1, SUM)
+	tx2.WriteInt32(ProductKey(2), 1, SUM)
+	if tx2.Commit() == 0 {
+		t.Fatalf("Aborted\n")
+	}
+	if tx1.Commit() != 0 {
+		t.Errorf("Committed a lost update\n")
+	}
+
+	// Write skew: each reads both and writes one
+	tx1.Reset()
+	tx2.Reset()
+	for _, tx := range []*STransaction{tx1, tx2} {
+		tx.Read(ProductKey(1))
+		tx.Read(ProductKey(2))
+	}
+	tx1.WriteInt32(ProductKey(1), -1, SUM)
+	tx2.WriteInt32(ProductKey(2), -1, SUM)
+	if tx2.Commit() == 0 {
+		t.Fatalf("Aborted\n")
+	}
+	if tx1.Commit() != 0 {
+		t.Errorf("Committed write skew\n")
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"hash"
-	"hash/crc32"
 	"log"
+	"math"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/narula/gotomic"
@@ -22,8 +22,6 @@ type TID uint64
 type Key gotomic.Key
 type Value interface{}
 
-var hasher hash.Hash32
-
 type Chunk struct {
 	padding1 [128]byte
 	sync.RWMutex
@@ -31,19 +29,25 @@ type Chunk struct {
 	padding2 [128]byte
 }
 
+// Flags for FlagConfig(); a Store goes by its Config.
 var UseRLocks = flag.Bool("rlock", true, "Use Rlocks\n")
 var GStore = flag.Bool("gstore", false, "Use Gotomic Hash Map instead of Go maps\n")
+var NChunks = flag.Int("chunks", CHUNKS, "Number of chunks to split the store's hash map into\n")
 
 var (
-	ENOKEY   = errors.New("doppel: no key")
-	EABORT   = errors.New("doppel: abort")
-	ESTASH   = errors.New("doppel: stash")
-	ENORETRY = errors.New("app error: no retry")
-	EEXISTS  = errors.New("doppel: trying to create key which already exists")
+	ENOKEY    = errors.New("doppel: no key")
+	EABORT    = errors.New("doppel: abort")
+	ESTASH    = errors.New("doppel: stash")
+	ENORETRY  = errors.New("app error: no retry")
+	EGAVEUP   = errors.New("doppel: gave up after too many aborts")
+	EEXISTS   = errors.New("doppel: trying to create key which already exists")
+	EOVERFLOW = errors.New("doppel: sum overflows")
+	ETOOBIG   = errors.New("doppel: transaction touches too many keys")
+	ESHUTDOWN = errors.New("doppel: coordinator shut down")
 )
 
 const (
-	CHUNKS = 256
+	CHUNKS = 256 // Default number of chunks
 )
 
 // Global data
@@ -52,41 +56,112 @@ type Store struct {
 	store           []*Chunk
 	gstore          *gotomic.Hash
 	NChunksAccessed []int64
-	dd              map[Key]bool
+	split           unsafe.Pointer // *map[Key]bool; see splitKeys()
 	hash_codes      map[Key]uint32
-	any_dd          bool
+	cfg             Config
+	any_dd          bool // Coordinator only
 	cand            *Candidates
+	indexes         []*Index
+	snapgen         uint64
+	clock           uint64 // SSI commit timestamps
+	absentrts       uint64 // Latest SSI commit which read a missing key or scanned
+	pinned          int32  // A checkpoint is reading the snapshot; see pinSnapshot()
 	padding2        [128]byte
 }
 
+func (s *Store) Config() Config {
+	return s.cfg
+}
+
+// Turns returning results from transactions on or off, and returns
+// what it was; loaders use it to get back the IDs they created.  Only
+// call it while no transactions are running.
+func (s *Store) SetAllocate(on bool) bool {
+	was := s.cfg.Allocate
+	s.cfg.Allocate = on
+	return was
+}
+
+// The keys which are split.  Don't modify it.
 func (s *Store) DD() map[Key]bool {
-	return s.dd
+	return s.splitKeys()
 }
 
-func NewStore() *Store {
-	hasher = crc32.NewIEEE()
-	x := make([]*OneStat, 0)
-	sh := StatsHeap(x)
+// The split keys as of the last phase change.  The coordinator
+// replaces the map rather than modify it, so anyone can read it.
+func (s *Store) splitKeys() map[Key]bool {
+	return *(*map[Key]bool)(atomic.LoadPointer(&s.split))
+}
+
+// Split the keys in add and stop splitting those in remove.  Only the
+// coordinator calls this, while no worker is in the split phase.
+func (s *Store) moveSplit(add, remove map[Key]bool) {
+	old := s.splitKeys()
+	dd := make(map[Key]bool, len(old)+len(add))
+	for k := range old {
+		if !remove[k] {
+			dd[k] = true
+		}
+	}
+	for k := range add {
+		dd[k] = true
+	}
+	for k := range add {
+		if br, err := s.getKey(k, nil); err == nil {
+			br.setDD(true)
+		}
+	}
+	for k := range remove {
+		if br, err := s.getKey(k, nil); err == nil && !dd[k] {
+			br.setDD(false)
+		}
+	}
+	atomic.StorePointer(&s.split, unsafe.Pointer(&dd))
+}
+
+func NewStore(cfg Config) *Store {
+	if cfg.NChunks < 1 {
+		log.Fatalf("Need at least one chunk, not %v\n", cfg.NChunks)
+	}
 	s := &Store{
-		store:           make([]*Chunk, CHUNKS),
+		store:           make([]*Chunk, cfg.NChunks),
 		gstore:          gotomic.NewHash(),
-		NChunksAccessed: make([]int64, CHUNKS),
-		dd:              make(map[Key]bool),
+		NChunksAccessed: make([]int64, cfg.NChunks),
 		hash_codes:      make(map[Key]uint32),
-		cand:            &Candidates{make(map[Key]*OneStat), &sh},
+		cfg:             cfg,
 	}
-	var bb byte
-
-	for i := 0; i < CHUNKS; i++ {
-		chunk := &Chunk{
+	s.cand = newCandidates(&s.cfg)
+	dd := make(map[Key]bool)
+	s.split = unsafe.Pointer(&dd)
+	for i := range s.store {
+		s.store[i] = &Chunk{
 			rows: make(map[Key]*BRecord),
 		}
-		bb = byte(i)
-		s.store[bb] = chunk
 	}
 	return s
 }
 
+// The chunk holding k.  Most keys differ in only a few bytes (the low
+// bytes of an int, the end of a string), so hash all of them.
+func (s *Store) chunk(k Key) *Chunk {
+	i := keyHash(k) % uint32(len(s.store))
+	if s.cfg.CountKeys {
+		atomic.AddInt64(&s.NChunksAccessed[i], 1)
+	}
+	return s.store[i]
+}
+
+// FNV-1a.  Unlike crc32.ChecksumIEEE(k[:]), it doesn't make k escape,
+// which would cost an allocation on every lookup.
+func keyHash(k Key) uint32 {
+	h := uint32(2166136261)
+	for _, b := range k {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return h
+}
+
 func (s *Store) PrecomputeHashCode(k Key) {
 	s.hash_codes[k] = gotomic.Key(k).HashCode()
 }
@@ -94,12 +169,14 @@ func (s *Store) PrecomputeHashCode(k Key) {
 func (s *Store) getOrCreateTypedKey(k Key, v Value, kt KeyType) *BRecord {
 	br, err := s.getKey(k, nil)
 	if err == ENOKEY {
-		if *GStore {
+		if s.cfg.GStore {
 			thing, ok := s.gstore.Get(gotomic.Key(k))
 			if !ok {
-				br = MakeBR(k, v, kt)
+				br = s.created(s.makeBR(k, v, kt))
 				did := s.gstore.PutIfMissing(gotomic.Key(k), unsafe.Pointer(br))
-				if !did {
+				if did {
+					s.indexKey(k)
+				} else {
 					thing, ok = s.gstore.Get(gotomic.Key(k))
 					if !ok {
 						log.Fatalf("Cannot put new key, but Get() says it isn't there %v\n", k)
@@ -108,38 +185,63 @@ func (s *Store) getOrCreateTypedKey(k Key, v Value, kt KeyType) *BRecord {
 			}
 			br = (*BRecord)(thing)
 		} else {
-			if !*UseRLocks {
+			if !s.cfg.UseRLocks {
 				log.Fatalf("Should have preallocated keys if not locking chunks\n")
 			}
 			// Create key
-			chunk := s.store[k[0]]
+			chunk := s.chunk(k)
 			var ok bool
 			chunk.Lock()
 			br, ok = chunk.rows[k]
 			if !ok {
-				br = MakeBR(k, v, kt)
+				br = s.created(s.makeBR(k, v, kt))
 				chunk.rows[k] = br
 			}
 			chunk.Unlock()
+			if !ok {
+				s.indexKey(k)
+			}
 		}
 	}
 	return br
 }
 
-func (s *Store) CreateKey(k Key, v Value, kt KeyType) *BRecord {
+// A new record for k, locking and counting conflicts the way my
+// Config says.
+func (s *Store) makeBR(k Key, v Value, kt KeyType) *BRecord {
 	br := MakeBR(k, v, kt)
-	if *GStore {
+	br.spin = s.cfg.Spinlock
+	br.measure = s.cfg.Conflicts
+	return br
+}
+
+func (s *Store) CreateKey(k Key, v Value, kt KeyType) *BRecord {
+	return s.put(s.makeBR(k, v, kt))
+}
+
+// Create an empty LIST record which keeps its entries according to o.
+// Lists created any other way use the default options.
+func (s *Store) CreateList(k Key, o ListOptions) *BRecord {
+	br := s.makeBR(k, nil, LIST)
+	br.lopts = &o
+	return s.put(br)
+}
+
+func (s *Store) put(br *BRecord) *BRecord {
+	k := br.key
+	if s.cfg.GStore {
 		x, ok := s.gstore.Put(gotomic.Key(k), unsafe.Pointer(br))
 		if ok {
 			fmt.Printf("Overwrote %v; already there? %v\n", k, x)
 		}
 		s.PrecomputeHashCode(k)
 	} else {
-		chunk := s.store[k[0]]
+		chunk := s.chunk(k)
 		chunk.Lock()
 		chunk.rows[k] = br
 		chunk.Unlock()
 	}
+	s.indexKey(k)
 	return br
 }
 
@@ -147,9 +249,9 @@ func (s *Store) CreateKey(k Key, v Value, kt KeyType) *BRecord {
 // record is locked and inserted while holding the lock on the chunk.
 
 func (s *Store) CreateLockedKey(k Key, kt KeyType) (*BRecord, error) {
-	br := MakeBR(k, nil, kt)
+	br := s.created(s.makeBR(k, nil, kt))
 	br.Lock()
-	if *GStore {
+	if s.cfg.GStore {
 		ok := s.gstore.PutIfMissing(gotomic.Key(k), unsafe.Pointer(br))
 		if !ok {
 			debug.PrintStack()
@@ -157,7 +259,7 @@ func (s *Store) CreateLockedKey(k Key, kt KeyType) (*BRecord, error) {
 			return nil, EEXISTS
 		}
 	} else {
-		chunk := s.store[k[0]]
+		chunk := s.chunk(k)
 		chunk.Lock()
 		_, ok := chunk.rows[k]
 		if ok {
@@ -168,20 +270,21 @@ func (s *Store) CreateLockedKey(k Key, kt KeyType) (*BRecord, error) {
 		chunk.rows[k] = br
 		chunk.Unlock()
 	}
+	s.indexKey(k)
 	return br, nil
 }
 
 func (s *Store) CreateMuLockedKey(k Key, kt KeyType) (*BRecord, error) {
-	br := MakeBR(k, nil, kt)
+	br := s.created(s.makeBR(k, nil, kt))
 	br.SLock()
-	if *GStore {
+	if s.cfg.GStore {
 		ok := s.gstore.PutIfMissing(gotomic.Key(k), unsafe.Pointer(br))
 		if !ok {
 			dlog.Printf("Key already exists %v\n", k)
 			return nil, EEXISTS
 		}
 	} else {
-		chunk := s.store[k[0]]
+		chunk := s.chunk(k)
 		chunk.Lock()
 		_, ok := chunk.rows[k]
 		if ok {
@@ -192,20 +295,21 @@ func (s *Store) CreateMuLockedKey(k Key, kt KeyType) (*BRecord, error) {
 		chunk.rows[k] = br
 		chunk.Unlock()
 	}
+	s.indexKey(k)
 	return br, nil
 }
 
 func (s *Store) CreateMuRLockedKey(k Key, kt KeyType) (*BRecord, error) {
-	br := MakeBR(k, nil, kt)
+	br := s.created(s.makeBR(k, nil, kt))
 	br.SRLock()
-	if *GStore {
+	if s.cfg.GStore {
 		ok := s.gstore.PutIfMissing(gotomic.Key(k), unsafe.Pointer(br))
 		if !ok {
 			dlog.Printf("Key already exists %v\n", k)
 			return nil, EEXISTS
 		}
 	} else {
-		chunk := s.store[k[0]]
+		chunk := s.chunk(k)
 		chunk.Lock()
 		_, ok := chunk.rows[k]
 		if ok {
@@ -216,10 +320,17 @@ func (s *Store) CreateMuRLockedKey(k Key, kt KeyType) (*BRecord, error) {
 		chunk.rows[k] = br
 		chunk.Unlock()
 	}
+	s.indexKey(k)
 	return br, nil
 }
 
-func (s *Store) SetInt32(br *BRecord, v int32, op KeyType) {
+// Returns EOVERFLOW, leaving br alone, if a sum would overflow.
+func (s *Store) SetInt32(br *BRecord, v int32, op KeyType) error {
+	if op == SUM && !br.deleted && addOverflows32(br.int_value, v) {
+		return EOVERFLOW
+	}
+	s.save(br)
+	br.revive()
 	switch op {
 	case SUM:
 		br.int_value += v
@@ -228,13 +339,53 @@ func (s *Store) SetInt32(br *BRecord, v int32, op KeyType) {
 			br.int_value = v
 		}
 	}
+	return nil
+}
+
+func (s *Store) SetInt64(br *BRecord, v int64, op KeyType) error {
+	if op == SUM64 && !br.deleted && addOverflows64(br.i64_value, v) {
+		return EOVERFLOW
+	}
+	s.save(br)
+	br.revive()
+	switch op {
+	case SUM64:
+		br.i64_value += v
+	case MAX64:
+		if v > br.i64_value {
+			br.i64_value = v
+		}
+	}
+	return nil
+}
+
+func (s *Store) SetFloat64(br *BRecord, v float64, op KeyType) error {
+	x := br.Float64()
+	if op == FSUM && !br.deleted && addOverflowsFloat(x, v) {
+		return EOVERFLOW
+	}
+	s.save(br)
+	br.revive()
+	switch op {
+	case FSUM:
+		br.f64_value = math.Float64bits(x + v)
+	case FMAX:
+		if v > x {
+			br.f64_value = math.Float64bits(v)
+		}
+	}
+	return nil
 }
 
 func (s *Store) SetList(br *BRecord, ve Entry, op KeyType) {
+	s.save(br)
+	br.revive()
 	br.AddOneToRecord(ve)
 }
 
 func (s *Store) SetOO(br *BRecord, a int32, v Value, op KeyType) {
+	s.save(br)
+	br.revive()
 	if v != nil {
 		if a > br.int_value || br.value == nil {
 			br.int_value = a
@@ -243,15 +394,22 @@ func (s *Store) SetOO(br *BRecord, a int32, v Value, op KeyType) {
 	}
 }
 
-func (s *Store) Set(br *BRecord, v Value, op KeyType) {
+func (s *Store) Set(br *BRecord, v Value, op KeyType) error {
+	switch op {
+	case SUM, MAX:
+		return s.SetInt32(br, v.(int32), op)
+	case SUM64, MAX64:
+		return s.SetInt64(br, v.(int64), op)
+	case FSUM, FMAX:
+		return s.SetFloat64(br, v.(float64), op)
+	}
+	s.save(br)
+	if op == DELETE {
+		br.remove()
+		return nil
+	}
+	br.revive()
 	switch op {
-	case SUM:
-		br.int_value += v.(int32)
-	case MAX:
-		x := v.(int32)
-		if x > br.int_value {
-			br.int_value = v.(int32)
-		}
 	case WRITE:
 		br.value = v
 	case LIST:
@@ -263,19 +421,64 @@ func (s *Store) Set(br *BRecord, v Value, op KeyType) {
 			x := v.(Overwrite)
 			s.SetOO(br, x.i, x.v, OOWRITE)
 		}
+	default:
+		if m := mergeOp(op); m != nil {
+			br.value = m.Apply(br.mergeValue(m), v)
+		}
 	}
+	return nil
 }
 
+// Returns ENOKEY for deleted keys.
 func (s *Store) Get(k Key) (*BRecord, error) {
-	return s.getKey(k, nil)
+	br, err := s.getKey(k, nil)
+	if err == nil && br.deleted {
+		return nil, ENOKEY
+	}
+	return br, err
 }
 
-func (s *Store) getKey(k Key, ld *gotomic.LocalData) (*BRecord, error) {
-	if len(k) == 0 {
-		debug.PrintStack()
-		log.Fatalf("[store] getKey(): Empty key\n")
+// Take deleted keys out of the store.  Nothing may be running
+// transactions, since they might be holding the records.  Returns the
+// tombstones which have to stay for now, reusing keys.
+func (s *Store) removeDeleted(keys []Key) []Key {
+	keep := keys[:0]
+	for _, k := range keys {
+		br, err := s.getKey(k, nil)
+		if err != nil || !br.deleted {
+			// Already removed, or written since
+			continue
+		}
+		if s.splitKeys()[k] {
+			keep = append(keep, k)
+			continue
+		}
+		if v := (*version)(atomic.LoadPointer(&br.snap)); v != nil && v.gen >= s.SnapshotGen() && !v.absent {
+			// Still in the current snapshot
+			keep = append(keep, k)
+			continue
+		}
+		if s.cfg.GStore {
+			s.gstore.Delete(gotomic.Key(k))
+			delete(s.hash_codes, k)
+		} else {
+			chunk := s.chunk(k)
+			chunk.Lock()
+			delete(chunk.rows, k)
+			chunk.Unlock()
+		}
+		if x := s.Index(k); x != nil {
+			x.remove(k)
+		}
 	}
-	if *GStore {
+	return keep
+}
+
+// Keys are 16 bytes, inline or interned (see keys.go), and chunk()
+// maps every one to a chunk made in NewStore(), so there is no empty
+// key or missing chunk to check for.
+func (s *Store) getKey(k Key, ld *gotomic.LocalData) (*BRecord, error) {
+	if s.cfg.GStore {
 		var x interface{}
 		var ok bool
 		hc, present := s.hash_codes[k]
@@ -291,14 +494,11 @@ func (s *Store) getKey(k Key, ld *gotomic.LocalData) (*BRecord, error) {
 			return x.(*BRecord), nil
 		}
 	}
-	if !*UseRLocks {
+	if !s.cfg.UseRLocks {
 		x, err := s.getKeyStatic(k)
 		return x, err
 	}
-	chunk := s.store[k[0]]
-	if chunk == nil {
-		log.Fatalf("[store] Didn't initialize chunk for key %v byte %v\n", k, k[0])
-	}
+	chunk := s.chunk(k)
 	chunk.RLock()
 	vr, ok := chunk.rows[k]
 	if !ok || vr == nil {
@@ -310,13 +510,7 @@ func (s *Store) getKey(k Key, ld *gotomic.LocalData) (*BRecord, error) {
 }
 
 func (s *Store) getKeyStatic(k Key) (*BRecord, error) {
-	if len(k) == 0 {
-		log.Fatalf("[store] getKey(): Empty key\n")
-	}
-	chunk := s.store[k[0]]
-	if chunk == nil {
-		log.Fatalf("[store] Didn't initialize chunk for key %v byte %v\n", k, k[0])
-	}
+	chunk := s.chunk(k)
 	vr, ok := chunk.rows[k]
 	if !ok || vr == nil {
 		return vr, ENOKEY
@@ -325,9 +519,5 @@ func (s *Store) getKeyStatic(k Key) (*BRecord, error) {
 }
 
 func (s *Store) IsDD(k Key) bool {
-	if !s.any_dd {
-		return false
-	}
-	x, ok := s.dd[k]
-	return x && ok
+	return s.splitKeys()[k]
 }
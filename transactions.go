@@ -8,37 +8,47 @@ import (
 )
 
 // I tried keeping a slice of interfaces; the reflection was costly.
-// Hard code in random parameter types to re-use for now.
+// Arguments live in a fixed-size arena of typed slots; see args.go.
 type Query struct {
 	TXN int
 	W   chan struct {
 		R *Result
 		E error
 	}
-	T  TID
-	K1 Key
-	K2 Key
-	A  int32
-	U1 uint64
-	U2 uint64
-	U3 uint64
-	U4 uint64
-	U5 uint64
-	U6 uint64
-	U7 uint64
-	S1 string
-	S2 string
-	I  int
-	TS time.Time
-	S  time.Time
+	T    TID
+	Args Args
+	I    int // Number of times a client has retried this query
+	TS   time.Time
+	S    time.Time
 }
 
 type Result struct {
-	V Value
+	V        Value
+	Attempts int // Set by Worker.Do() and for stashed transactions
 }
 
+// Flag for FlagConfig(); transactions go by their Store's Config.
 var Allocate = flag.Bool("allocate", true, "Allocate results")
 
+// Arguments of the micro-benchmark transactions, D_BUY through
+// D_ATOMIC_INCR_ONE: usually a user and a product, and an amount.
+var (
+	MicroSchema = NewSchema("Micro")
+	MICRO_K1    = MicroSchema.Key("k1")
+	MICRO_K2    = MicroSchema.Key("k2")
+	MICRO_A     = MicroSchema.Num("a")
+)
+
+// A query for one of the micro-benchmark transactions.  The reads and
+// increments only use k1.
+func MicroQuery(txn int, k1, k2 Key, a int32) Query {
+	q := Query{TXN: txn}
+	q.SetKey(MICRO_K1, k1)
+	q.SetKey(MICRO_K2, k2)
+	q.SetNum(MICRO_A, uint64(a))
+	return q
+}
+
 func IsRead(t int) bool {
 	if t == D_READ_ONE || t == D_READ_TWO {
 		return true
@@ -48,11 +58,11 @@ func IsRead(t int) bool {
 
 func BuyTxn(t Query, tx ETransaction) (*Result, error) {
 	var r *Result = nil
-	err := tx.WriteInt32(t.K1, 1, SUM)
+	err := tx.WriteInt32(t.Key(MICRO_K1), 1, SUM)
 	if err != nil {
 		return nil, err
 	}
-	err = tx.WriteInt32(t.K2, t.A, SUM)
+	err = tx.WriteInt32(t.Key(MICRO_K2), int32(t.Num(MICRO_A)), SUM)
 	if err != nil {
 		return nil, err
 	}
@@ -65,34 +75,34 @@ func BuyTxn(t Query, tx ETransaction) (*Result, error) {
 func BuyAndReadTxn(t Query, tx ETransaction) (*Result, error) {
 	tx.NoCount()
 	var r *Result = nil
-	err := tx.WriteInt32(t.K1, 1, SUM)
+	err := tx.WriteInt32(t.Key(MICRO_K1), 1, SUM)
 	if err != nil {
 		return nil, err
 	}
-	err = tx.WriteInt32(t.K2, t.A, SUM)
+	err = tx.WriteInt32(t.Key(MICRO_K2), int32(t.Num(MICRO_A)), SUM)
 	if err != nil {
 		return nil, err
 	}
-	br, err2 := tx.Read(t.K2)
+	br, err2 := tx.Read(t.Key(MICRO_K2))
 	if err2 != nil {
 		return r, err2
 	}
 	x := br.int_value
-	if br.dd == true && tx.GetPhase() == SPLIT {
-		log.Fatalf("should not happen %v\n", t.K2)
+	if br.isDD() && tx.GetPhase() == SPLIT {
+		log.Fatalf("should not happen %v\n", t.Key(MICRO_K2))
 	}
 	if tx.Commit() == 0 {
 		return r, EABORT
 	}
-	if *Allocate {
-		r = &Result{x}
+	if tx.Store().cfg.Allocate {
+		r = &Result{V: x}
 	}
 	return r, nil
 }
 
 func ReadOneTxn(t Query, tx ETransaction) (*Result, error) {
 	var r *Result = nil
-	v1, err := tx.Read(t.K1)
+	v1, err := tx.Read(t.Key(MICRO_K1))
 	if err != nil {
 		return r, err
 	}
@@ -101,22 +111,22 @@ func ReadOneTxn(t Query, tx ETransaction) (*Result, error) {
 	if txid := tx.Commit(); txid == 0 {
 		return r, EABORT
 	}
-	if *Allocate {
-		r = &Result{x}
+	if tx.Store().cfg.Allocate {
+		r = &Result{V: x}
 	}
 	return r, nil
 }
 
 func ReadTxn(t Query, tx ETransaction) (*Result, error) {
 	var r *Result = nil
-	v1, err := tx.Read(t.K1)
+	v1, err := tx.Read(t.Key(MICRO_K1))
 	if err != nil {
 		return r, err
 	}
 	x := v1.int_value
 	_ = x
 
-	v1, err = tx.Read(t.K2)
+	v1, err = tx.Read(t.Key(MICRO_K2))
 	if err != nil {
 		return r, err
 	}
@@ -126,8 +136,8 @@ func ReadTxn(t Query, tx ETransaction) (*Result, error) {
 	if txid := tx.Commit(); txid == 0 {
 		return r, EABORT
 	}
-	if *Allocate {
-		r = &Result{&struct {
+	if tx.Store().cfg.Allocate {
+		r = &Result{V: &struct {
 			val1 int32
 			val2 int32
 		}{x, y}}
@@ -141,7 +151,7 @@ func ReadTxn(t Query, tx ETransaction) (*Result, error) {
 // split phase or not.  This shouldn't be run in a mix with any other
 // transaction types.
 func AtomicIncr(t Query, tx ETransaction) (*Result, error) {
-	br, err := tx.Store().getKey(t.K1, tx.Worker().ld)
+	br, err := tx.Store().getKey(t.Key(MICRO_K1), tx.Worker().ld)
 	if err != nil || br == nil {
 		log.Fatalf("Why no key?")
 	}
@@ -150,7 +160,7 @@ func AtomicIncr(t Query, tx ETransaction) (*Result, error) {
 }
 
 func IncrTxn(t Query, tx ETransaction) (*Result, error) {
-	err := tx.WriteInt32(t.K1, 1, SUM)
+	err := tx.WriteInt32(t.Key(MICRO_K1), 1, SUM)
 	if err != nil {
 		return nil, err
 	}
@@ -160,15 +170,22 @@ func IncrTxn(t Query, tx ETransaction) (*Result, error) {
 	return nil, nil
 }
 
+// Arguments of BIG_INCR and BIG_RW: six bids and a product.
+var (
+	BigSchema = NewSchema("Big")
+	BIG_BIDS  = [6]NumArg{
+		BigSchema.Num("bid1"), BigSchema.Num("bid2"), BigSchema.Num("bid3"),
+		BigSchema.Num("bid4"), BigSchema.Num("bid5"), BigSchema.Num("bid6"),
+	}
+	BIG_PRODUCT = BigSchema.Num("product")
+)
+
 func BigIncrTxn(t Query, tx ETransaction) (*Result, error) {
 	var r *Result = nil
 	key := [6]Key{}
-	key[0] = BidKey(t.U1)
-	key[1] = BidKey(t.U2)
-	key[2] = BidKey(t.U3)
-	key[3] = BidKey(t.U4)
-	key[4] = BidKey(t.U5)
-	key[5] = BidKey(t.U6)
+	for i := 0; i < 6; i++ {
+		key[i] = BidKey(t.Num(BIG_BIDS[i]))
+	}
 
 	for z := 0; z < 10; z++ {
 		for i := 0; i < 6; i++ {
@@ -189,7 +206,7 @@ func BigIncrTxn(t Query, tx ETransaction) (*Result, error) {
 		}
 	}
 
-	if err := tx.WriteInt32(ProductKey(int(t.U7)), 1, SUM); err != nil {
+	if err := tx.WriteInt32(ProductKey(int(t.Num(BIG_PRODUCT))), 1, SUM); err != nil {
 		return nil, err
 	}
 	if tx.Commit() == 0 {
@@ -204,13 +221,10 @@ func BigRWTxn(t Query, tx ETransaction) (*Result, error) {
 	var r *Result = nil
 
 	key := [7]Key{}
-	key[0] = BidKey(t.U1)
-	key[1] = BidKey(t.U2)
-	key[2] = BidKey(t.U3)
-	key[3] = BidKey(t.U4)
-	key[4] = BidKey(t.U5)
-	key[5] = BidKey(t.U6)
-	key[6] = ProductKey(int(t.U7))
+	for i := 0; i < 6; i++ {
+		key[i] = BidKey(t.Num(BIG_BIDS[i]))
+	}
+	key[6] = ProductKey(int(t.Num(BIG_PRODUCT)))
 
 	for z := 0; z < 10; z++ {
 		for i := 0; i < 6; i++ {
@@ -0,0 +1,93 @@
+package ddtxn
+
+import (
+	"testing"
+)
+
+func TestTxnSize(t *testing.T) {
+	starts := map[string]func(*Worker) ETransaction{
+		"occ": func(w *Worker) ETransaction { return StartOTransaction(w) },
+		"2pl": func(w *Worker) ETransaction { return StartLTransaction(w) },
+		"ssi": func(w *Worker) ETransaction { return StartSTransaction(w) },
+	}
+	for name, start := range starts {
+		s := NewStore(noGC())
+		for i := 0; i < 3*TXN_KEYS; i++ {
+			s.CreateKey(ProductKey(i), int32(0), SUM)
+		}
+		c := NewCoordinator(1, s, BuiltinRegistry())
+		tx := start(c.Workers[0])
+
+		// Sets grow past their initial size
+		tx.Reset()
+		for i := 0; i < 3*TXN_KEYS; i++ {
+			if err := tx.WriteInt32(ProductKey(i), 1, SUM); err != nil {
+				t.Fatalf("%v: Write %v %v\n", name, i, err)
+			}
+		}
+		if tx.Commit() == 0 {
+			t.Fatalf("%v: Big transaction aborted\n", name)
+		}
+		if br, _ := s.Get(ProductKey(3*TXN_KEYS - 1)); br.Value() != int32(1) {
+			t.Errorf("%v: Wrong value %v\n", name, br.Value())
+		}
+
+		// Past the limit
+		s.cfg.MaxKeys = 5
+		tx.Reset()
+		var err error
+		for i := 0; i < 6 && err == nil; i++ {
+			err = tx.WriteInt32(ProductKey(i), 1, SUM)
+		}
+		if err != ETOOBIG {
+			t.Errorf("%v: Expected ETOOBIG, got %v\n", name, err)
+		}
+		if tx.Commit() != 0 {
+			t.Errorf("%v: Committed a transaction which was too big\n", name)
+		}
+		if br, _ := s.Get(ProductKey(0)); br.Value() != int32(1) {
+			t.Errorf("%v: Wrote part of a transaction which was too big %v\n", name, br.Value())
+		}
+		c.Finish()
+	}
+
+	// Through a worker, it isn't retried whatever the transaction
+	// makes of the error
+	cfg := noGC()
+	cfg.MaxKeys = 3
+	s := NewStore(cfg)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+	var q Query
+	q.TXN = BIG_RW
+	for i := range BIG_BIDS {
+		q.SetNum(BIG_BIDS[i], uint64(i))
+	}
+	q.SetNum(BIG_PRODUCT, 1)
+	if _, err := c.Workers[0].One(q); err != ETOOBIG {
+		t.Errorf("Expected ETOOBIG from the worker, got %v\n", err)
+	}
+}
+
+func TestTxnSetsReused(t *testing.T) {
+	s := NewStore(noGC())
+	for i := 0; i < 2*TXN_KEYS; i++ {
+		s.CreateKey(ProductKey(i), int32(0), SUM)
+	}
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	defer c.Finish()
+	tx := StartOTransaction(c.Workers[0])
+	big := func() {
+		tx.Reset()
+		for i := 0; i < 2*TXN_KEYS; i++ {
+			tx.WriteInt32(ProductKey(i), 1, SUM)
+		}
+		tx.Abort()
+	}
+	big()
+	r, w := &tx.read[0], &tx.writes[0]
+	big()
+	if r != &tx.read[0] || w != &tx.writes[0] {
+		t.Errorf("Grew the sets again\n")
+	}
+}
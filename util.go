@@ -94,7 +94,7 @@ func Validate(c *Coordinator, s *Store, nkeys int, nproducts int, val []int32, n
 }
 
 func PrintLockCounts(s *Store) {
-	if *Conflicts == false {
+	if !s.cfg.Conflicts {
 		fmt.Println("Didn't measure conflicts!")
 	}
 	for i, chunk := range s.store {
@@ -197,16 +197,17 @@ func PrintStats(out string, stats []int64, f *os.File, coord *Coordinator, s *St
 		}
 		f.WriteString("\n")
 	}
-	for i := 0; i < LAST_TXN; i++ {
-		if stats[i] != 0 {
-			f.WriteString(fmt.Sprintf("txn%v: %v\n", i, stats[i]))
+	txns := CollectTxns(coord)
+	for i := range txns {
+		if txns[i] != 0 {
+			f.WriteString(fmt.Sprintf("%v: %v\n", coord.reg.Name(i), txns[i]))
 		}
 	}
 	WriteChunkStats(s, f)
-	if *CountKeys {
+	if s.cfg.CountKeys {
 		WriteCountKeyStats(coord, nb, f)
 	}
-	if *Conflicts {
+	if s.cfg.Conflicts {
 		PrintLockCounts(s)
 	}
 }
@@ -262,12 +263,23 @@ func WriteCountKeyStats(coord *Coordinator, nb int, f *os.File) {
 
 func CollectOne(w *Worker) int64 {
 	var nitr int64
-	for j := 0; j < LAST_TXN; j++ {
+	for j := 0; j < len(w.Nstats); j++ {
 		nitr = nitr + w.Nstats[j]
 	}
 	return nitr
 }
 
+// Commits of each transaction type, summed over all workers.
+func CollectTxns(coord *Coordinator) []int64 {
+	txns := make([]int64, coord.reg.Len())
+	for i := 0; i < len(coord.Workers); i++ {
+		for j := range txns {
+			txns[j] = txns[j] + coord.Workers[i].Nstats[j]
+		}
+	}
+	return txns
+}
+
 func CollectCounts(coord *Coordinator, stats []int64) (int64, time.Duration, time.Duration, time.Duration, time.Duration, time.Duration, time.Duration) {
 	var nitr int64
 	var nwait time.Duration
@@ -278,11 +290,9 @@ func CollectCounts(coord *Coordinator, stats []int64) (int64, time.Duration, tim
 	var nmergewait time.Duration
 	for i := 0; i < len(coord.Workers); i++ {
 		for j := 0; j < LAST_STAT; j++ {
-			stats[j] = stats[j] + coord.Workers[i].Nstats[j]
-			if j < LAST_TXN {
-				nitr = nitr + coord.Workers[i].Nstats[j]
-			}
+			stats[j] = stats[j] + coord.Workers[i].Ncounters[j]
 		}
+		nitr = nitr + CollectOne(coord.Workers[i])
 		nwait = nwait + coord.Workers[i].Nwait
 		nnoticed = nnoticed + coord.Workers[i].Nnoticed
 		nmerge = nmerge + coord.Workers[i].Nmerge
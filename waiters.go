@@ -1,23 +1,34 @@
 package ddtxn
 
-import "flag"
+import (
+	"flag"
+	"time"
+)
 
+// Flag for FlagConfig()
 var TriggerCount = flag.Int("trigger", 100000, "How long the queue can get before triggering a phase change\n")
 
 type TStore struct {
-	t []Query
-	n int
+	t       []Query
+	n       int
+	trigger int
+	first   time.Time // When t[0] was stashed
 }
 
-func TSInit(n int) *TStore {
-	ts := &TStore{t: make([]Query, 0, n)}
+// Add() says to trigger a phase change once trigger transactions are
+// waiting.
+func TSInit(n int, trigger int) *TStore {
+	ts := &TStore{t: make([]Query, 0, n), trigger: trigger}
 	return ts
 }
 
 func (ts *TStore) Add(t Query) bool {
+	if ts.n == 0 {
+		ts.first = time.Now()
+	}
 	ts.t = append(ts.t, t)
 	ts.n += 1
-	if ts.n == *TriggerCount {
+	if ts.n == ts.trigger {
 		return true
 	}
 	return false
@@ -0,0 +1,323 @@
+package ddtxn
+
+import (
+	"bufio"
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/narula/dlog"
+)
+
+var LogDir = flag.String("logdir", "", "Directory for per-worker redo logs.  Empty means no logging\n")
+
+// Redo logging.  Every worker appends the write set of each
+// transaction it commits to its own log file.  Records are buffered
+// in memory and written out together when an epoch closes (group
+// commit), followed by a marker for the next epoch: everything the
+// worker committed before it is on disk.  Epochs close while no
+// worker is running a transaction, so the same marker in every log
+// ends a consistent prefix of the history.  Recovery replays the
+// groups up to the last marker that every worker's log reached.
+//
+// Values written with WRITE, OOWRITE or a registered MergeOp, and
+// the payloads of LIST entries, are gob-encoded, so their concrete
+// types have to be registered with gob.Register().
+
+const (
+	LOG_TXN = iota
+	LOG_EPOCH
+)
+
+type logWrite struct {
+	K     Key
+	Op    KeyType
+	I     int32
+	V     Value
+	Order int
+	EKey  Key
+	Top   int
+	EVal  Value
+}
+
+type logRecord struct {
+	Kind   int
+	TID    TID
+	Writes []logWrite
+}
+
+type WAL struct {
+	dir string
+	id  int
+	seg int
+	f   *os.File
+	buf *bufio.Writer
+	enc *gob.Encoder
+	// The last epoch marker written
+	marked TID
+	// Committed transactions waiting for the next group commit.
+	batch []logRecord
+	// Transactions which wrote split data.  Their writes are sitting
+	// in the worker's LocalStore, so they aren't durable until the
+	// LocalStore is merged at the end of the epoch.
+	pending []logRecord
+}
+
+// Each time a worker starts it opens a new log segment, since a gob
+// stream can't be appended to by a second encoder.
+func LogFile(dir string, id int, seg int) string {
+	return filepath.Join(dir, fmt.Sprintf("worker-%d-%d.log", id, seg))
+}
+
+func OpenWAL(dir string, id int) (*WAL, error) {
+	segs, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("worker-%d-*.log", id)))
+	if err != nil {
+		return nil, err
+	}
+	l := &WAL{
+		dir:     dir,
+		id:      id,
+		seg:     len(segs),
+		batch:   make([]logRecord, 0, 1000),
+		pending: make([]logRecord, 0, 1000),
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *WAL) open() error {
+	f, err := os.OpenFile(LogFile(l.dir, l.id, l.seg), os.O_EXCL|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	l.buf = bufio.NewWriter(f)
+	l.enc = gob.NewEncoder(l.buf)
+	return nil
+}
+
+// Finish the current segment and start a new one.  Returns the number
+// of the new segment; everything logged from now on is in it or a
+// later one.  Used when taking a checkpoint.
+func (l *WAL) Rotate(e TID) (int, error) {
+	if err := l.Close(e); err != nil {
+		return 0, err
+	}
+	l.seg++
+	if err := l.open(); err != nil {
+		return 0, err
+	}
+	return l.seg, nil
+}
+
+// Append a committed transaction.  split means some of its writes
+// were applied to the worker's LocalStore instead of the global
+// store.
+func (l *WAL) Append(tid TID, writes []logWrite, split bool) {
+	r := logRecord{Kind: LOG_TXN, TID: tid, Writes: writes}
+	if split {
+		l.pending = append(l.pending, r)
+	} else {
+		l.batch = append(l.batch, r)
+	}
+}
+
+// Called after the worker merged its LocalStore; split writes from
+// this epoch can now go to disk.
+func (l *WAL) Merged() {
+	l.batch = append(l.batch, l.pending...)
+	l.pending = l.pending[:0]
+}
+
+// Group commit, once everything before epoch e is committed.  Write
+// out the batch followed by a marker for e, and sync.  The marker is
+// written even if the batch is empty, since recovery can't go past
+// the oldest marker.
+func (l *WAL) Flush(e TID) error {
+	if e <= l.marked {
+		return nil
+	}
+	for i := range l.batch {
+		if err := l.enc.Encode(&l.batch[i]); err != nil {
+			return err
+		}
+	}
+	if err := l.enc.Encode(&logRecord{Kind: LOG_EPOCH, TID: e}); err != nil {
+		return err
+	}
+	if err := l.buf.Flush(); err != nil {
+		return err
+	}
+	l.batch = l.batch[:0]
+	l.marked = e
+	return l.f.Sync()
+}
+
+func (l *WAL) Close(e TID) error {
+	l.Merged()
+	if err := l.Flush(e); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+type byTID []logRecord
+
+func (r byTID) Len() int           { return len(r) }
+func (r byTID) Less(i, j int) bool { return r[i].TID < r[j].TID }
+func (r byTID) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// The transactions a worker committed before epoch marker E.
+type logGroup struct {
+	E    TID
+	Txns []logRecord
+}
+
+// Reads one worker's log and returns every complete group.  A torn
+// group at the end of the log is ignored.
+func readLog(fn string) ([]logGroup, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	durable := make([]logGroup, 0)
+	group := make([]logRecord, 0)
+	for {
+		var r logRecord
+		err := dec.Decode(&r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if r.Kind == LOG_EPOCH {
+			durable = append(durable, logGroup{E: r.TID, Txns: group})
+			group = make([]logRecord, 0)
+			continue
+		}
+		group = append(group, r)
+	}
+	if len(group) > 0 {
+		dlog.Printf("Dropping %v transactions without an epoch marker from %v\n", len(group), fn)
+	}
+	return durable, nil
+}
+
+func (s *Store) replay(r *logRecord) {
+	for i := range r.Writes {
+		w := &r.Writes[i]
+		if w.Op == DELETE {
+			br, err := s.getKey(w.K, nil)
+			if err == nil {
+				s.Set(br, nil, DELETE)
+				br.Lock()
+				br.Unlock(r.TID)
+			}
+			continue
+		}
+		br := s.getOrCreateTypedKey(w.K, nil, w.Op)
+		switch w.Op {
+		case SUM:
+			s.SetInt32(br, w.I, w.Op)
+		case MAX:
+			s.SetInt32(br, w.I, w.Op)
+		case LIST:
+			s.SetList(br, Entry{order: w.Order, key: w.EKey, top: w.Top, payload: w.EVal}, w.Op)
+		case OOWRITE:
+			s.SetOO(br, w.I, w.V, w.Op)
+		default:
+			s.Set(br, w.V, w.Op)
+		}
+		br.Lock()
+		br.Unlock(r.TID)
+	}
+}
+
+// Apply the logs in dir to s in TID order.  If from is not nil,
+// worker i's segments before from[i] are skipped.  Only groups up to
+// the oldest of the workers' last epoch markers are applied; past
+// that a transaction could depend on one that another worker never
+// wrote out.
+func (s *Store) replayLogs(dir string, from []int) error {
+	files, err := filepath.Glob(filepath.Join(dir, "worker-*.log"))
+	if err != nil {
+		return err
+	}
+	groups := make([]logGroup, 0)
+	last := make(map[int]TID)
+	n := 0
+	for _, fn := range files {
+		var id, seg int
+		if _, err := fmt.Sscanf(filepath.Base(fn), "worker-%d-%d.log", &id, &seg); err != nil {
+			return fmt.Errorf("doppel: bad log file name %v", fn)
+		}
+		if from != nil && id < len(from) && seg < from[id] {
+			continue
+		}
+		g, err := readLog(fn)
+		if err != nil {
+			return fmt.Errorf("doppel: reading log %v: %v", fn, err)
+		}
+		if _, ok := last[id]; !ok {
+			last[id] = 0
+		}
+		for i := range g {
+			if g[i].E > last[id] {
+				last[id] = g[i].E
+			}
+		}
+		groups = append(groups, g...)
+		n++
+	}
+	durable := ^TID(0)
+	for _, e := range last {
+		if e < durable {
+			durable = e
+		}
+	}
+	all := make([]logRecord, 0)
+	for _, g := range groups {
+		if g.E <= durable {
+			all = append(all, g.Txns...)
+		} else {
+			dlog.Printf("Dropping %v transactions before epoch %v, past %v\n", len(g.Txns), g.E, durable)
+		}
+	}
+	sort.Sort(byTID(all))
+	var deleted []Key
+	for i := range all {
+		s.replay(&all[i])
+		for _, w := range all[i].Writes {
+			if w.Op == DELETE {
+				deleted = append(deleted, w.K)
+			}
+		}
+	}
+	// Nobody could have read the replayed tombstones
+	s.nextSnapshot()
+	s.removeDeleted(deleted)
+	dlog.Printf("Replayed %v transactions from %v logs\n", len(all), n)
+	return nil
+}
+
+// Rebuild a store configured by cfg from the redo logs in dir
+// (usually cfg.LogDir), applying transactions in TID order.  Keys
+// which were loaded directly with CreateKey() and never written by a
+// transaction are not in the logs; use a checkpoint and
+// RecoverCheckpoint() for those.
+func Recover(dir string, cfg Config) (*Store, error) {
+	s := NewStore(cfg)
+	if err := s.replayLogs(dir, nil); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
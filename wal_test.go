@@ -0,0 +1,105 @@
+package ddtxn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecover(t *testing.T) {
+	cfg := FlagConfig()
+	cfg.LogDir = t.TempDir()
+	s := NewStore(cfg)
+	c := NewCoordinator(1, s, BuiltinRegistry())
+	w := c.Workers[0]
+	s.CreateKey(ProductKey(4), int32(0), SUM)
+	s.CreateKey(UserKey(1), int32(0), SUM)
+	for i := 0; i < 10; i++ {
+		tx := MicroQuery(D_BUY, UserKey(1), ProductKey(4), 5)
+		if _, err := w.One(tx); err != nil {
+			t.Fatalf("Buy %v\n", err)
+		}
+	}
+	c.Finish()
+
+	s2, err := Recover(cfg.LogDir, cfg)
+	if err != nil {
+		t.Fatalf("Recover %v\n", err)
+	}
+	br, err := s2.Get(ProductKey(4))
+	if err != nil {
+		t.Fatalf("No product after recovery %v\n", err)
+	}
+	if br.Value().(int32) != 50 {
+		t.Errorf("Wrong product value after recovery %v\n", br.Value())
+	}
+	br, err = s2.Get(UserKey(1))
+	if err != nil {
+		t.Fatalf("No user after recovery %v\n", err)
+	}
+	if br.Value().(int32) != 10 {
+		t.Errorf("Wrong user value after recovery %v\n", br.Value())
+	}
+}
+
+// Worker 1 crashed before writing out its second epoch, so worker 0's
+// second epoch can't be recovered either.
+func TestRecoverOldestEpoch(t *testing.T) {
+	cfg := FlagConfig()
+	dir := t.TempDir()
+	buy := func(l *WAL, e TID, n int32) {
+		l.Append(e|1, []logWrite{{K: ProductKey(4), Op: SUM, I: n}}, false)
+	}
+	w0, err := OpenWAL(dir, 0)
+	if err != nil {
+		t.Fatalf("OpenWAL %v\n", err)
+	}
+	w1, err := OpenWAL(dir, 1)
+	if err != nil {
+		t.Fatalf("OpenWAL %v\n", err)
+	}
+	buy(w0, EPOCH_INCR, 1)
+	buy(w1, EPOCH_INCR, 2)
+	w0.Flush(2 * EPOCH_INCR)
+	w1.Flush(2 * EPOCH_INCR)
+	buy(w0, 2*EPOCH_INCR, 4)
+	w0.Flush(3 * EPOCH_INCR)
+	buy(w1, 2*EPOCH_INCR, 8)
+	w0.f.Close()
+	w1.f.Close()
+
+	s, err := Recover(dir, cfg)
+	if err != nil {
+		t.Fatalf("Recover %v\n", err)
+	}
+	br, err := s.Get(ProductKey(4))
+	if err != nil {
+		t.Fatalf("No product after recovery %v\n", err)
+	}
+	if br.Value().(int32) != 3 {
+		t.Errorf("Wrong product value after recovery %v\n", br.Value())
+	}
+
+	// An idle worker still marks the end of each epoch
+	cfg.LogDir = t.TempDir()
+	cfg.PhaseLength = time.Millisecond
+	s = NewStore(cfg)
+	c := NewCoordinator(2, s, BuiltinRegistry())
+	s.CreateKey(ProductKey(4), int32(0), SUM)
+	s.CreateKey(UserKey(1), int32(0), SUM)
+	tx := MicroQuery(D_BUY, UserKey(1), ProductKey(4), 5)
+	if _, err := c.Workers[0].One(tx); err != nil {
+		t.Fatalf("Buy %v\n", err)
+	}
+	e := c.GetEpoch()
+	for c.GetEpoch() < e+2*EPOCH_INCR {
+		time.Sleep(time.Millisecond)
+	}
+	s2, err := Recover(cfg.LogDir, cfg)
+	if err != nil {
+		t.Fatalf("Recover %v\n", err)
+	}
+	if br, err := s2.Get(ProductKey(4)); err != nil || br.Value().(int32) != 5 {
+		t.Errorf("Buy not durable before Finish %v %v\n", br, err)
+	}
+	c.Finish()
+}
@@ -2,6 +2,7 @@ package ddtxn
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"runtime/debug"
 	"strconv"
@@ -9,6 +10,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/narula/ddtxn/stats"
 	"github.com/narula/dlog"
 	"github.com/narula/gotomic"
 )
@@ -17,8 +19,10 @@ const (
 	DOPPEL = iota
 	OCC
 	LOCKING
+	SSI
 )
 
+// Flags for FlagConfig(); a Worker goes by its Store's Config.
 var SysType = flag.Int("sys", DOPPEL, "Type of system to run\n")
 var CountKeys = flag.Bool("ck", false, "Count keys accessed")
 var Latency = flag.Bool("latency", false, "Measure latency")
@@ -28,14 +32,21 @@ type TransactionFunc func(Query, ETransaction) (*Result, error)
 
 const (
 	BUFFER     = 100000
-	START_SIZE = 1000000
+	START_SIZE = 4096 // Room for stashed transactions; grows if needed
 	TIMES      = 10
 
 //	TIMES = 10000000
 )
 
+// Worker.Noticed has NOTICE_BUCKETS buckets of NOTICE_US microseconds.
+const (
+	NOTICE_US      = 10
+	NOTICE_BUCKETS = 2000
+)
+
+// Transactions in BuiltinRegistry().  Applications can register more
+// with a Registry; they get the IDs after BIG_RW.
 const (
-	// Transactions
 	D_BUY = iota
 	D_BUY_AND_READ
 	D_READ_ONE
@@ -58,10 +69,11 @@ const (
 
 	BIG_INCR
 	BIG_RW
-	LAST_TXN
+)
 
-	// Stats
-	NABORTS
+// Counters in Worker.Ncounters
+const (
+	NABORTS = iota
 	NENOKEY
 	NSTASHED
 	NENORETRY
@@ -73,6 +85,9 @@ const (
 	NLOCKED
 	NDIDSTASHED
 	NREADABORTS
+	NGAVEUP
+	NINTERNAL
+	NPROXIED // Phase changes the coordinator merged for me
 	LAST_STAT
 )
 
@@ -81,6 +96,7 @@ type Worker struct {
 	padding     [128]byte
 	ID          int
 	store       *Store
+	cfg         *Config // The Store's
 	coordinator *Coordinator
 	local_store *LocalStore
 	next        TID
@@ -89,17 +105,21 @@ type Worker struct {
 	waiters     *TStore
 	E           ETransaction
 	txns        []TransactionFunc
+	wal         *WAL
+	deleted     []Key // Tombstones waiting for Coordinator.collect()
 
 	ld *gotomic.LocalData
 
 	// Stats
-	Nstats       []int64
+	Nstats       []int64 // Commits per transaction type
+	Ncounters    []int64
 	Nwait        time.Duration
 	Nmerge       time.Duration
 	Nmergewait   time.Duration
 	Njoin        time.Duration
 	Njoinwait    time.Duration
 	Nnoticed     time.Duration
+	Noticed      *stats.LatencyHist // Of each Nnoticed, in microseconds
 	NKeyAccesses []int64
 	tickle       chan TID
 
@@ -114,55 +134,49 @@ type Worker struct {
 	tooLong [4]int64
 }
 
-func (w *Worker) Register(fn int, transaction TransactionFunc) {
-	w.txns[fn] = transaction
+// Whether transaction number fn has been registered with this worker.
+func (w *Worker) Registered(fn int) bool {
+	return fn >= 0 && fn < len(w.txns) && w.txns[fn] != nil
 }
 
 func NewWorker(id int, s *Store, c *Coordinator) *Worker {
 	w := &Worker{
 		ID:           id,
 		store:        s,
+		cfg:          &s.cfg,
 		local_store:  NewLocalStore(s),
 		coordinator:  c,
-		Nstats:       make([]int64, LAST_STAT),
+		Nstats:       make([]int64, c.reg.Len()),
+		Ncounters:    make([]int64, LAST_STAT),
 		epoch:        TID(c.epochTID),
 		done:         make(chan bool),
-		txns:         make([]TransactionFunc, LAST_TXN),
+		txns:         c.reg.fns,
 		tickle:       make(chan TID),
 		PreAllocated: false,
 		ld:           gotomic.InitLocalData(),
+		Noticed:      stats.MakeLatencyHistogram(NOTICE_US, NOTICE_BUCKETS),
 	}
-	if *SysType == DOPPEL {
-		w.waiters = TSInit(START_SIZE)
+	if s.cfg.SysType == DOPPEL {
+		w.waiters = TSInit(START_SIZE, s.cfg.TriggerCount)
 	} else {
-		w.waiters = TSInit(1)
+		w.waiters = TSInit(1, s.cfg.TriggerCount)
 	}
-	if *SysType == LOCKING {
+	if w.cfg.SysType == LOCKING {
 		w.E = StartLTransaction(w)
+	} else if w.cfg.SysType == SSI {
+		w.E = StartSTransaction(w)
 	} else {
 		w.E = StartOTransaction(w)
 	}
 	w.E.SetPhase(SPLIT)
-	w.Register(D_BUY, BuyTxn)
-	w.Register(D_BUY_AND_READ, BuyAndReadTxn)
-	w.Register(D_READ_ONE, ReadOneTxn)
-	w.Register(D_READ_TWO, ReadTxn)
-	w.Register(D_INCR_ONE, IncrTxn)
-	w.Register(D_ATOMIC_INCR_ONE, AtomicIncr)
-	w.Register(RUBIS_BID, StoreBidTxn)
-	w.Register(RUBIS_VIEWBIDHIST, ViewBidHistoryTxn)
-	w.Register(RUBIS_BUYNOW, StoreBuyNowTxn)
-	w.Register(RUBIS_COMMENT, StoreCommentTxn)
-	w.Register(RUBIS_NEWITEM, NewItemTxn)
-	w.Register(RUBIS_PUTBID, PutBidTxn)
-	w.Register(RUBIS_PUTCOMMENT, PutCommentTxn)
-	w.Register(RUBIS_REGISTER, RegisterUserTxn)
-	w.Register(RUBIS_SEARCHCAT, SearchItemsCategTxn)
-	w.Register(RUBIS_SEARCHREG, SearchItemsRegionTxn)
-	w.Register(RUBIS_VIEW, ViewItemTxn)
-	w.Register(RUBIS_VIEWUSER, ViewUserInfoTxn)
-	w.Register(BIG_INCR, BigIncrTxn)
-	w.Register(BIG_RW, BigRWTxn)
+	if s.cfg.LogDir != "" {
+		var err error
+		w.wal, err = OpenWAL(s.cfg.LogDir, id)
+		if err != nil {
+			log.Fatalf("Worker %v could not open log in %v: %v\n", id, s.cfg.LogDir, err)
+		}
+	}
+	c.wg.Add(1)
 	go w.run()
 	return w
 }
@@ -173,23 +187,59 @@ func (w *Worker) stashTxn(t Query) {
 	}
 }
 
-func (w *Worker) doTxn(t Query) (*Result, error) {
-	if t.TXN >= LAST_TXN {
-		debug.PrintStack()
-		log.Fatalf("Unknown transaction number %v\n", t.TXN)
+// A transaction which ran out of room fails with ETOOBIG, whatever it
+// made of the error, and one whose commit would have overflowed a sum
+// with EOVERFLOW instead of EABORT; retrying either won't help.
+func (w *Worker) sizeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := w.E.(interface {
+		tooBig() bool
+	}); ok && e.tooBig() {
+		return ETOOBIG
+	}
+	if e, ok := w.E.(interface {
+		commitError() error
+	}); ok && err == EABORT && e.commitError() != nil {
+		return e.commitError()
+	}
+	return err
+}
+
+// Run t's TransactionFunc.  One that panics, or that I don't know,
+// fails with ErrInternal or ErrMisuse instead of taking the process
+// down; whatever it left locked is released.
+func (w *Worker) call(t Query) (x *Result, err error) {
+	if !w.Registered(t.TXN) {
+		return nil, misuse(Key{}, fmt.Sprintf("unknown transaction number %v", t.TXN))
 	}
+	defer func() {
+		if r := recover(); r != nil {
+			dlog.Printf("%v transaction %v panicked: %v\n%s", w.ID, t.TXN, r, debug.Stack())
+			w.E.Abort()
+			w.Ncounters[NINTERNAL]++
+			x, err = nil, internalError(Key{}, "transaction %v panicked: %v", t.TXN, r)
+		}
+	}()
 	w.E.Reset()
-	x, err := w.txns[t.TXN](t, w.E)
+	x, err = w.txns[t.TXN](t, w.E)
+	return x, w.sizeError(err)
+}
+
+func (w *Worker) doTxn(t Query) (*Result, error) {
+	x, err := w.call(t)
 	if err == ESTASH {
 		if w.E.GetPhase() != SPLIT {
-			log.Fatalf("Cannot stash a transaction outside of split phase")
+			w.Ncounters[NINTERNAL]++
+			return nil, internalError(Key{}, "transaction %v stashed outside of split phase", t.TXN)
 		}
-		w.Nstats[NSTASHED]++
+		w.Ncounters[NSTASHED]++
 		w.stashTxn(t)
 		return nil, err
 	} else if err == nil {
 		w.Nstats[t.TXN]++
-		if *Latency {
+		if w.cfg.Latency {
 			x := time.Since(t.S)
 			if t.TXN < 4 {
 				y := x.Nanoseconds() / 1000 // microseconds
@@ -201,9 +251,9 @@ func (w *Worker) doTxn(t Query) (*Result, error) {
 			}
 		}
 	} else if err == EABORT {
-		if *Latency {
+		if w.cfg.Latency {
 			if t.TXN == D_READ_TWO {
-				w.Nstats[NREADABORTS]++
+				w.Ncounters[NREADABORTS]++
 			}
 			x := time.Since(t.S)
 			if t.TXN < 4 {
@@ -213,27 +263,23 @@ func (w *Worker) doTxn(t Query) (*Result, error) {
 				}
 			}
 		}
-		w.Nstats[NABORTS]++
+		w.Ncounters[NABORTS]++
 	} else if err == ENOKEY {
-		w.Nstats[NENOKEY]++
+		w.Ncounters[NENOKEY]++
 	} else if err == ENORETRY {
-		w.Nstats[NENORETRY]++
+		w.Ncounters[NENORETRY]++
 	}
 	return x, err
 }
 
 func (w *Worker) doTxn2(t Query) (*Result, error) {
-	if t.TXN >= LAST_TXN {
-		debug.PrintStack()
-		log.Fatalf("Unknown transaction number %v\n", t.TXN)
-	}
-	w.E.Reset()
-	x, err := w.txns[t.TXN](t, w.E)
+	x, err := w.call(t)
 	if err == ESTASH {
-		log.Fatalf("Should not be in stashing stage right now\n")
+		w.Ncounters[NINTERNAL]++
+		return nil, internalError(Key{}, "transaction %v stashed in the join phase", t.TXN)
 	} else if err == nil {
 		w.Nstats[t.TXN]++
-		if *Latency {
+		if w.cfg.Latency {
 			x := time.Since(t.S)
 			if t.TXN < 4 {
 				y := x.Nanoseconds() / 1000
@@ -245,66 +291,90 @@ func (w *Worker) doTxn2(t Query) (*Result, error) {
 			}
 		}
 	} else if err == EABORT {
-		if *Latency && t.TXN == D_READ_TWO {
-			w.Nstats[NREADABORTS]++
+		if w.cfg.Latency && t.TXN == D_READ_TWO {
+			w.Ncounters[NREADABORTS]++
 		}
-		w.Nstats[NABORTS]++
+		w.Ncounters[NABORTS]++
 	} else if err == ENOKEY {
-		w.Nstats[NENOKEY]++
+		w.Ncounters[NENOKEY]++
 	} else if err == ENORETRY {
-		w.Nstats[NENORETRY]++
+		w.Ncounters[NENORETRY]++
 	}
 	return x, err
 }
 
 func (w *Worker) joinPhase() {
 	for i := 0; i < len(w.waiters.t); i++ {
+		t := &w.waiters.t[i]
 		committed := false
-		// TODO: On abort this transaction really should be
-		// reissued by the client, but in our benchmarks the
-		// client doesn't wait, so here we go.
+		var r *Result
+		var err error
+		// Retry aborts right away; waiting here would hold up the
+		// phase change for everyone.
 		n := 0
-		for !committed && n < 10 {
-			r, err := w.doTxn2(w.waiters.t[i])
-			if err == EABORT {
-				n++
-				committed = false
-			} else {
+		for !committed && n < w.cfg.StashRetries {
+			n++
+			r, err = w.doTxn2(*t)
+			if err != EABORT {
 				committed = true
-				if w.waiters.t[i].W != nil {
-					w.waiters.t[i].W <- struct {
-						R *Result
-						E error
-					}{r, err}
-				}
 			}
 		}
+		if !committed {
+			// Don't drop it silently; tell the waiter.
+			w.Ncounters[NGAVEUP]++
+			r, err = nil, EGAVEUP
+		}
+		if t.W != nil {
+			if r == nil {
+				r = &Result{}
+			}
+			r.Attempts = n
+			t.W <- struct {
+				R *Result
+				E error
+			}{r, err}
+		}
 	}
 	w.waiters.clear()
 }
 
-func (w *Worker) transition() {
-	if *SysType == DOPPEL {
+// Move to the coordinator's epoch, merging and running stashed
+// transactions.  Returns ErrInternal, once the phase change is over,
+// if I was told about some other epoch; see misaligned().
+func (w *Worker) transition() error {
+	if w.cfg.SysType == DOPPEL {
 		w.Lock()
 		defer w.Unlock()
 		e := w.coordinator.GetEpoch()
 		if e <= w.epoch {
-			return
+			return nil
 		}
 		start := time.Now()
 		tt := time.Since(w.coordinator.StartTime)
 		w.Nnoticed += tt
+		w.Noticed.AddOne(tt.Nanoseconds() / 1000)
 		//dlog.Printf("%v %v Starting transition %v noticed after %v\n", time.Now().UnixNano(), w.ID, e, tt)
 		w.E.SetPhase(MERGE)
 		w.local_store.Merge()
-		w.coordinator.wepoch[w.ID] <- e
+		if w.wal != nil {
+			w.wal.Merged()
+			w.flushLog(e)
+		}
+		c := w.coordinator
+		if w.cfg.SpinBarrier {
+			atomic.AddUint64(&c.wcepoch, 1)
+		} else {
+			c.wepoch[w.ID] <- e
+		}
 		tt = time.Since(start)
 		w.Nmerge += tt
 		//dlog.Printf("%v %v Done merge %v, waiting; took %v\n", time.Now().UnixNano(), w.ID, e, tt)
 		ts := time.Now()
-		x := <-w.coordinator.wsafe[w.ID]
-		if x != e {
-			log.Fatalf("Worker %v out of alignment; acked %v, got safe for %v\n", w.ID, e, x)
+		var err error
+		if w.cfg.SpinBarrier {
+			spinUntil(&c.gojoin, uint64(e))
+		} else if x := <-c.wsafe[w.ID]; x != e {
+			err = w.misaligned(c.wsafe[w.ID], e, fmt.Sprintf("acked %v, got safe for %v", e, x))
 		}
 		tt = time.Since(ts)
 		w.Nmergewait += tt
@@ -316,11 +386,16 @@ func (w *Worker) transition() {
 		w.Njoin += tt
 
 		w.E.SetPhase(SPLIT)
-		w.coordinator.wdone[w.ID] <- e
+		if w.cfg.SpinBarrier {
+			atomic.AddUint64(&c.wcdone, 1)
+		} else {
+			c.wdone[w.ID] <- e
+		}
 		ts = time.Now()
-		x = <-w.coordinator.wgo[w.ID]
-		if x != e {
-			log.Fatalf("Worker %v out of alignment; said done for %v, got go for %v\n", w.ID, e, x)
+		if w.cfg.SpinBarrier {
+			spinUntil(&c.gosplit, uint64(e))
+		} else if x := <-c.wgo[w.ID]; x != e {
+			err = w.misaligned(c.wgo[w.ID], e, fmt.Sprintf("said done for %v, got go for %v", e, x))
 		}
 		tt = time.Since(ts)
 		w.Njoinwait += tt
@@ -328,15 +403,49 @@ func (w *Worker) transition() {
 		end := time.Since(start)
 		w.Nwait += end
 		w.epoch = e
+		return err
+	}
+	return nil
+}
+
+// Something besides the coordinator sent on ch.  The coordinator
+// checked I'm at epoch e, so its own e is still coming; wait for it,
+// or the coordinator and every other worker wait for me forever.
+func (w *Worker) misaligned(ch chan TID, e TID, msg string) error {
+	w.Ncounters[NINTERNAL]++
+	for x := range ch {
+		if x == e {
+			break
+		}
 	}
+	return internalError(Key{}, "worker %v out of alignment; %v", w.ID, msg)
+}
+
+// Merge and run whatever is still stashed, for Shutdown().  With one
+// worker there are no phase changes to do it.
+func (w *Worker) drain() {
+	if w.cfg.SysType != DOPPEL {
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	w.E.SetPhase(MERGE)
+	w.local_store.Merge()
+	if w.wal != nil {
+		w.wal.Merged()
+	}
+	w.E.SetPhase(JOIN)
+	w.joinPhase()
+	w.E.SetPhase(SPLIT)
 }
 
 // Periodically check if the epoch changed.  This is important because
 // I might not always be receiving calls to One()
 func (w *Worker) run() {
-	duration := time.Duration(*PhaseLength) * time.Millisecond
-	tm := time.NewTicker(duration).C
-	_ = tm
+	defer w.coordinator.wg.Done()
+	ticker := time.NewTicker(w.cfg.shortestPhase())
+	defer ticker.Stop()
+	tm := ticker.C
 	for {
 		select {
 		case <-w.done:
@@ -344,39 +453,74 @@ func (w *Worker) run() {
 		case <-tm:
 			// This is necessary if all worker threads are blocked
 			// waiting for stashed reads.
-			if *SysType == DOPPEL {
+			if w.cfg.SysType == DOPPEL {
 				w.RLock()
 				e := w.coordinator.GetEpoch()
 				if e > w.epoch {
 					w.RUnlock()
-					w.transition()
+					if err := w.transition(); err != nil {
+						log.Printf("%v\n", err)
+					}
 				} else {
 					w.RUnlock()
 				}
 			}
 		case <-w.tickle:
-			if *SysType == DOPPEL {
-				w.transition()
+			if w.cfg.SysType == DOPPEL {
+				if err := w.transition(); err != nil {
+					log.Printf("%v\n", err)
+				}
 			}
 		}
 	}
 }
 
+// Run t, or stash it until the next phase change.  Fails with
+// ESHUTDOWN once the coordinator is shutting down.
 func (w *Worker) One(t Query) (*Result, error) {
 	w.RLock()
-	if *SysType == DOPPEL {
+	if w.cfg.SysType == DOPPEL {
 		e := w.coordinator.GetEpoch()
 		if w.epoch != e {
 			w.RUnlock()
-			w.tickle <- e
+			select {
+			case w.tickle <- e:
+			case <-w.coordinator.closed:
+				return nil, ESHUTDOWN
+			}
 			w.RLock()
 		}
 	}
+	// Checked holding the lock, so Shutdown()'s last phase change
+	// comes after anything this stashes.
+	if w.coordinator.closing() {
+		w.RUnlock()
+		return nil, ESHUTDOWN
+	}
 	r, err := w.doTxn(t)
 	w.RUnlock()
 	return r, err
 }
 
+func (w *Worker) flushLog(e TID) {
+	if err := w.wal.Flush(e); err != nil {
+		log.Fatalf("Worker %v could not write log: %v\n", w.ID, err)
+	}
+}
+
+func (w *Worker) closeLog() {
+	w.Lock()
+	defer w.Unlock()
+	if w.wal == nil {
+		return
+	}
+	// Nothing runs after this, so the last epoch is over
+	if err := w.wal.Close(w.epoch + EPOCH_INCR); err != nil {
+		log.Fatalf("Worker %v could not close log: %v\n", w.ID, err)
+	}
+	w.wal = nil
+}
+
 func (w *Worker) Finished() {
 	dlog.Printf("%v FINISHED (e=%v)\n", w.ID, w.epoch)
 	w.coordinator.Finished[w.ID] = true
@@ -409,7 +553,7 @@ func (w *Worker) PreallocateRubis(nx, nb, start int) {
 		k = NumBidsKey(uint64(x))
 		w.store.CreateKey(k, int32(0), SUM)
 		k = BidsPerItemKey(uint64(x))
-		w.store.CreateKey(k, nil, LIST)
+		w.store.CreateList(k, BidListOptions())
 
 		k = BuyNowKey(uint64(x))
 		w.store.CreateKey(k, &BuyNow{}, WRITE)